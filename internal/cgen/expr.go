@@ -57,6 +57,8 @@ func (g *gen) writeExpr(b *buffer, n *a.Expr, depth uint32) error {
 		return g.writeExprBinaryOp(b, n, depth)
 	case op.IsXAssociativeOp():
 		return g.writeExprAssociativeOp(b, n, depth)
+	case op.IsXTernaryOp():
+		return g.writeExprTernaryOp(b, n, depth)
 	}
 	return g.writeExprOther(b, n, depth)
 }
@@ -286,6 +288,12 @@ func (g *gen) writeExprBinaryOp(b *buffer, n *a.Expr, depth uint32) error {
 	case t.IDXBinaryAs:
 		return g.writeExprAs(b, n.LHS().AsExpr(), n.RHS().AsTypeExpr(), depth)
 
+	case t.IDXBinaryStarStar:
+		// C has no power operator. Constant expressions, such as "2 ** 4",
+		// are handled by writeExpr's ConstValue check, and lang/check rejects
+		// all others.
+		return fmt.Errorf("internal error: non-constant %q was not rejected by lang/check", n.Str(g.tm))
+
 	case t.IDXBinaryShiftL, t.IDXBinaryShiftR, t.IDXBinaryTildeModShiftL:
 		if lhs := n.LHS().AsExpr(); lhs.ConstValue() != nil {
 			lhsCast = true
@@ -386,6 +394,23 @@ func (g *gen) writeExprAssociativeOp(b *buffer, n *a.Expr, depth uint32) error {
 	return nil
 }
 
+func (g *gen) writeExprTernaryOp(b *buffer, n *a.Expr, depth uint32) error {
+	b.writeb('(')
+	if err := g.writeExpr(b, n.LHS().AsExpr(), depth); err != nil {
+		return err
+	}
+	b.writes(" ? ")
+	if err := g.writeExpr(b, n.MHS().AsExpr(), depth); err != nil {
+		return err
+	}
+	b.writes(" : ")
+	if err := g.writeExpr(b, n.RHS().AsExpr(), depth); err != nil {
+		return err
+	}
+	b.writeb(')')
+	return nil
+}
+
 func (g *gen) writeExprUserDefinedCall(b *buffer, n *a.Expr, depth uint32) error {
 	method := n.LHS().AsExpr()
 	recv := method.LHS().AsExpr()
@@ -565,6 +590,7 @@ var cOpNames = [...]string{
 	t.IDPipeEq:           " |= ",
 	t.IDHatEq:            " ^= ",
 	t.IDPercentEq:        " %= ",
+	t.IDStarStarEq:       noSuchCOperator,
 	t.IDTildeModPlusEq:   " += ",
 	t.IDTildeModMinusEq:  " -= ",
 	t.IDTildeModStarEq:   " *= ",
@@ -585,6 +611,7 @@ var cOpNames = [...]string{
 	t.IDXBinaryPipe:           " | ",
 	t.IDXBinaryHat:            " ^ ",
 	t.IDXBinaryPercent:        " % ",
+	t.IDXBinaryStarStar:       noSuchCOperator,
 	t.IDXBinaryTildeModPlus:   " + ",
 	t.IDXBinaryTildeModMinus:  " - ",
 	t.IDXBinaryTildeModStar:   " * ",
@@ -600,6 +627,7 @@ var cOpNames = [...]string{
 	t.IDXBinaryAnd:            " && ",
 	t.IDXBinaryOr:             " || ",
 	t.IDXBinaryAs:             noSuchCOperator,
+	t.IDXBinaryXor:            " != ",
 
 	t.IDXAssociativePlus: " + ",
 	t.IDXAssociativeStar: " * ",
@@ -608,6 +636,7 @@ var cOpNames = [...]string{
 	t.IDXAssociativeHat:  " ^ ",
 	t.IDXAssociativeAnd:  " && ",
 	t.IDXAssociativeOr:   " || ",
+	t.IDXAssociativeXor:  " != ",
 
 	t.IDXUnaryPlus:  " + ",
 	t.IDXUnaryMinus: " - ",
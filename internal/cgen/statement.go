@@ -194,6 +194,11 @@ func (g *gen) writeStatementAssign1(b *buffer, op t.ID, lhs *a.Expr, rhs *a.Expr
 			b.printf("wuffs_base__u%d__sat_%s_indirect(&", uBits, uOp)
 			opName, closer = ", ", ")"
 
+		case t.IDStarStarEq:
+			// lang/check rejects every "**=", as only constant powers are
+			// supported.
+			return fmt.Errorf("internal error: %q was not rejected by lang/check", op.Str(g.tm))
+
 		case t.IDPlusEq, t.IDMinusEq:
 			if lTyp.IsNumType() {
 				if u := lTyp.QID()[1]; u == t.IDU8 || u == t.IDU16 {
@@ -49,7 +49,15 @@ func (n *Expr) appendStr(buf []byte, tm *t.Map, parenthesize bool, depth uint32)
 			if parenthesize {
 				buf = append(buf, '(')
 			}
-			buf = n.lhs.AsExpr().appendStr(buf, tm, true, depth)
+			// A unary operator binds less tightly than "**", so "(-x) ** 2"
+			// needs its parentheses even though "x + -y" does not.
+			if lhs := n.lhs.AsExpr(); (n.id0 == t.IDXBinaryStarStar) && lhs.id0.IsXUnaryOp() {
+				buf = append(buf, '(')
+				buf = lhs.appendStr(buf, tm, true, depth)
+				buf = append(buf, ')')
+			} else {
+				buf = lhs.appendStr(buf, tm, true, depth)
+			}
 			buf = append(buf, opString(n.id0)...)
 			if n.id0 == t.IDXBinaryAs {
 				buf = append(buf, n.rhs.AsTypeExpr().Str(tm)...)
@@ -74,6 +82,20 @@ func (n *Expr) appendStr(buf []byte, tm *t.Map, parenthesize bool, depth uint32)
 			if parenthesize {
 				buf = append(buf, ')')
 			}
+
+		case n.id0.IsXTernaryOp():
+			if parenthesize {
+				buf = append(buf, '(')
+			}
+			buf = n.lhs.AsExpr().appendStr(buf, tm, true, depth)
+			buf = append(buf, " ? "...)
+			buf = n.mhs.AsExpr().appendStr(buf, tm, true, depth)
+			buf = append(buf, " : "...)
+			rhs := n.rhs.AsExpr()
+			buf = rhs.appendStr(buf, tm, !rhs.Operator().IsXTernaryOp(), depth)
+			if parenthesize {
+				buf = append(buf, ')')
+			}
 		}
 
 	} else {
@@ -159,6 +181,7 @@ var opStrings = [...]string{
 	t.IDXBinaryPipe:           " | ",
 	t.IDXBinaryHat:            " ^ ",
 	t.IDXBinaryPercent:        " % ",
+	t.IDXBinaryStarStar:       " ** ",
 	t.IDXBinaryTildeModPlus:   " ~mod+ ",
 	t.IDXBinaryTildeModMinus:  " ~mod- ",
 	t.IDXBinaryTildeModStar:   " ~mod* ",
@@ -174,6 +197,7 @@ var opStrings = [...]string{
 	t.IDXBinaryAnd:            " and ",
 	t.IDXBinaryOr:             " or ",
 	t.IDXBinaryAs:             " as ",
+	t.IDXBinaryXor:            " xor ",
 
 	t.IDXAssociativePlus: " + ",
 	t.IDXAssociativeStar: " * ",
@@ -182,6 +206,7 @@ var opStrings = [...]string{
 	t.IDXAssociativeHat:  " ^ ",
 	t.IDXAssociativeAnd:  " and ",
 	t.IDXAssociativeOr:   " or ",
+	t.IDXAssociativeXor:  " xor ",
 
 	t.IDXUnaryPlus:  "+",
 	t.IDXUnaryMinus: "-",
@@ -69,6 +69,24 @@ func TestString(tt *testing.T) {
 		"x as ptr T",
 		"x as array[4] T",
 		"x as array[8 + (2 * N)] ptr array[4] ptr pkg.T[i ..= j]",
+
+		"x xor y",
+		"x xor y xor z",
+		"(x < y) xor (y < z)",
+
+		"x ** 2",
+		"x ** (y ** 2)",
+		"x * (y ** 2)",
+		"(-x) ** 2",
+		"x ** -y",
+		"(x + y) ** 2",
+
+		"x ? y : z",
+		"(x < y) ? a : b",
+		"x ? y : z ? a : b",
+		"x ? (y ? z : a) : b",
+		"(x ? y : z) + 1",
+		"x ? f(a: i) : g(b: j)",
 	}
 
 	tm := &t.Map{}
@@ -90,3 +108,33 @@ func TestString(tt *testing.T) {
 		}
 	}
 }
+
+func TestStringAddsParens(tt *testing.T) {
+	const filename = "test.wuffs"
+	testCases := []struct {
+		src  string
+		want string
+	}{
+		{"x * y ** 2", "x * (y ** 2)"},
+		{"x ** y ** 2", "x ** (y ** 2)"},
+		{"x + y + z ** 2 ** n", "x + y + (z ** (2 ** n))"},
+		{"-x ** 2", "-(x ** 2)"},
+	}
+
+	tm := &t.Map{}
+	for _, tc := range testCases {
+		tokens, _, err := t.Tokenize(tm, filename, []byte(tc.src))
+		if err != nil {
+			tt.Errorf("Tokenize(%q): %v", tc.src, err)
+			continue
+		}
+		expr, err := parse.ParseExpr(tm, filename, tokens, nil)
+		if err != nil {
+			tt.Errorf("ParseExpr(%q): %v", tc.src, err)
+			continue
+		}
+		if got := expr.Str(tm); got != tc.want {
+			tt.Errorf("%q: got %q, want %q", tc.src, got, tc.want)
+		}
+	}
+}
@@ -797,6 +797,8 @@ func (q *checker) bcheckExpr1(n *a.Expr, depth uint32) (bounds, error) {
 		return q.bcheckExprBinaryOp(op, n.LHS().AsExpr(), n.RHS().AsExpr(), depth)
 	case op.IsXAssociativeOp():
 		return q.bcheckExprAssociativeOp(n, depth)
+	case op.IsXTernaryOp():
+		return q.bcheckExprTernaryOp(n, depth)
 	}
 
 	return q.bcheckExprOther(n, depth)
@@ -1494,7 +1496,7 @@ func (q *checker) bcheckExprBinaryOp1(op t.ID, lhs *a.Expr, lb bounds, rhs *a.Ex
 		}
 
 	case t.IDXBinaryNotEq, t.IDXBinaryLessThan, t.IDXBinaryLessEq, t.IDXBinaryEqEq,
-		t.IDXBinaryGreaterEq, t.IDXBinaryGreaterThan, t.IDXBinaryAnd, t.IDXBinaryOr:
+		t.IDXBinaryGreaterEq, t.IDXBinaryGreaterThan, t.IDXBinaryAnd, t.IDXBinaryOr, t.IDXBinaryXor:
 		return bounds{zero, one}, nil
 
 	case t.IDXBinaryAs:
@@ -1531,6 +1533,25 @@ func (q *checker) bcheckExprAssociativeOp(n *a.Expr, depth uint32) (bounds, erro
 	return lb, nil
 }
 
+// bcheckExprTernaryOp returns the union of the bounds of the two branches.
+//
+// TODO: refine each branch's facts by the condition, similar to what is done
+// for if-else statements.
+func (q *checker) bcheckExprTernaryOp(n *a.Expr, depth uint32) (bounds, error) {
+	if _, err := q.bcheckExpr(n.LHS().AsExpr(), depth); err != nil {
+		return bounds{}, err
+	}
+	mb, err := q.bcheckExpr(n.MHS().AsExpr(), depth)
+	if err != nil {
+		return bounds{}, err
+	}
+	rb, err := q.bcheckExpr(n.RHS().AsExpr(), depth)
+	if err != nil {
+		return bounds{}, err
+	}
+	return bounds{min(mb[0], rb[0]), max(mb[1], rb[1])}, nil
+}
+
 func (q *checker) bcheckTypeExpr(typ *a.TypeExpr) (bounds, error) {
 	if b := typ.AsNode().MBounds(); b[0] != nil {
 		return b, nil
@@ -191,6 +191,9 @@ func TestConstValues(tt *testing.T) {
 		"i = 10  | 3": 11,
 		"i = 10  ^ 3": 9,
 
+		"i = 2 ** 4":      16,
+		"i = 2 ** 3 ** 2": 512,
+
 		"b = 10 <> 3": 1,
 		"b = 10  < 3": 0,
 		"b = 10 <= 3": 0,
@@ -270,6 +273,70 @@ func TestConstValues(tt *testing.T) {
 	}
 }
 
+func TestPowerOpNonConstant(tt *testing.T) {
+	const filename = "test.wuffs"
+	testCases := []string{
+		"i = i ** 2",
+		"i = 2 ** i",
+		"i **= 2",
+	}
+
+	tm := &t.Map{}
+	for _, s := range testCases {
+		src := "pri func foo() {\nvar i : base.u32\n" + s + "\n}\n"
+
+		tokens, _, err := t.Tokenize(tm, filename, []byte(src))
+		if err != nil {
+			tt.Errorf("%q: Tokenize: %v", s, err)
+			continue
+		}
+
+		file, err := parse.Parse(tm, filename, tokens, nil)
+		if err != nil {
+			tt.Errorf("%q: Parse: %v", s, err)
+			continue
+		}
+
+		if _, err := Check(tm, []*a.File{file}, nil); err == nil {
+			tt.Errorf("%q: Check: got nil error, want non-nil", s)
+		} else if !strings.Contains(err.Error(), "only constant powers") {
+			tt.Errorf("%q: Check: got %q, want an error about constant powers", s, err)
+		}
+	}
+}
+
+func TestTernaryOpMismatchedTypes(tt *testing.T) {
+	const filename = "test.wuffs"
+	testCases := []string{
+		"i = b ? i : b",
+		"i = b ? 1 : false",
+		"b = b ? true : i",
+	}
+
+	tm := &t.Map{}
+	for _, s := range testCases {
+		src := "pri func foo() {\nvar b : base.bool\nvar i : base.u32\n" + s + "\n}\n"
+
+		tokens, _, err := t.Tokenize(tm, filename, []byte(src))
+		if err != nil {
+			tt.Errorf("%q: Tokenize: %v", s, err)
+			continue
+		}
+
+		file, err := parse.Parse(tm, filename, tokens, nil)
+		if err != nil {
+			tt.Errorf("%q: Parse: %v", s, err)
+			continue
+		}
+
+		if _, err := Check(tm, []*a.File{file}, nil); err == nil {
+			tt.Errorf("%q: Check: got nil error, want non-nil", s)
+		} else if !strings.Contains(err.Error(), "do not have compatible types") {
+			tt.Errorf("%q: Check: got %q, want an error about incompatible types", s, err)
+		}
+	}
+}
+
 func TestBitMask(tt *testing.T) {
 	testCases := [][2]uint64{
 		{0, 0},
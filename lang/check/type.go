@@ -255,6 +255,12 @@ func (q *checker) tcheckAssign(n *a.Assign) error {
 		return q.tcheckEq(0, lhs, lTyp, rhs, rTyp)
 	}
 
+	if n.Operator() == t.IDStarStarEq {
+		// The assignee is never constant, and only constant powers are
+		// supported. See tcheckExprBinaryOp.
+		return fmt.Errorf("check: assignment %q: %q is not supported, as only constant powers are",
+			n.Operator().Str(q.tm), lhs.Str(q.tm))
+	}
 	if !lTyp.IsNumType() {
 		return fmt.Errorf("check: assignment %q: assignee %q, of type %q, does not have numeric type",
 			n.Operator().Str(q.tm), lhs.Str(q.tm), lTyp.Str(q.tm))
@@ -319,6 +325,8 @@ func (q *checker) tcheckExpr(n *a.Expr, depth uint32) error {
 		return q.tcheckExprBinaryOp(n, depth)
 	case op.IsXAssociativeOp():
 		return q.tcheckExprAssociativeOp(n, depth)
+	case op.IsXTernaryOp():
+		return q.tcheckExprTernaryOp(n, depth)
 	}
 	return q.tcheckExprOther(n, depth)
 }
@@ -711,7 +719,7 @@ func (q *checker) tcheckExprBinaryOp(n *a.Expr, depth uint32) error {
 
 	pointerComparison := false
 	switch op {
-	case t.IDXBinaryAnd, t.IDXBinaryOr:
+	case t.IDXBinaryAnd, t.IDXBinaryOr, t.IDXBinaryXor:
 		if !lTyp.IsBool() {
 			return fmt.Errorf("check: binary %q: %q, of type %q, does not have a boolean type",
 				op.AmbiguousForm().Str(q.tm), lhs.Str(q.tm), lTyp.Str(q.tm))
@@ -799,6 +807,10 @@ func (q *checker) tcheckExprBinaryOp(n *a.Expr, depth uint32) error {
 			return err
 		}
 		n.SetConstValue(ncv)
+	} else if op == t.IDXBinaryStarStar {
+		// C has no power operator, and there is no base.uN__pow helper.
+		return fmt.Errorf("check: binary %q: %q is not a constant expression; "+
+			"only constant powers are supported", op.AmbiguousForm().Str(q.tm), n.Str(q.tm))
 	}
 
 	if (op < t.ID(len(comparisonOps))) && comparisonOps[op] {
@@ -850,6 +862,12 @@ func evalConstValueBinaryOp(tm *t.Map, n *a.Expr, l *big.Int, r *big.Int) (*big.
 			return nil, fmt.Errorf("check: division by zero in const expression %q", n.Str(tm))
 		}
 		return big.NewInt(0).Mod(l, r), nil
+	case t.IDXBinaryStarStar:
+		if r.Sign() < 0 || r.Cmp(ffff) > 0 {
+			return nil, fmt.Errorf("check: exponent %q out of range in const expression %q",
+				n.RHS().AsExpr().Str(tm), n.Str(tm))
+		}
+		return big.NewInt(0).Exp(l, r, nil), nil
 	case t.IDXBinaryNotEq:
 		return btoi(l.Cmp(r) != 0), nil
 	case t.IDXBinaryLessThan:
@@ -866,6 +884,8 @@ func evalConstValueBinaryOp(tm *t.Map, n *a.Expr, l *big.Int, r *big.Int) (*big.
 		return btoi((l.Sign() != 0) && (r.Sign() != 0)), nil
 	case t.IDXBinaryOr:
 		return btoi((l.Sign() != 0) || (r.Sign() != 0)), nil
+	case t.IDXBinaryXor:
+		return btoi((l.Sign() != 0) != (r.Sign() != 0)), nil
 
 	case t.IDXBinaryTildeModPlus, t.IDXBinaryTildeModMinus,
 		t.IDXBinaryTildeModStar, t.IDXBinaryTildeModShiftL,
@@ -912,7 +932,7 @@ func (q *checker) tcheckExprAssociativeOp(n *a.Expr, depth uint32) error {
 		}
 		n.SetMType(typ)
 
-	case t.IDXAssociativeAnd, t.IDXAssociativeOr:
+	case t.IDXAssociativeAnd, t.IDXAssociativeOr, t.IDXAssociativeXor:
 		for _, o := range n.Args() {
 			o := o.AsExpr()
 			if err := q.tcheckExpr(o, depth); err != nil {
@@ -983,7 +1003,7 @@ func evalConstValueAssociativeOp(tm *t.Map, n *a.Expr) (*big.Int, error) {
 			}
 		}
 
-	case t.IDXAssociativeHat:
+	case t.IDXAssociativeHat, t.IDXAssociativeXor:
 		for _, o := range args {
 			if cv := o.AsExpr().ConstValue(); cv == nil {
 				return nil, nil
@@ -999,6 +1019,51 @@ func evalConstValueAssociativeOp(tm *t.Map, n *a.Expr) (*big.Int, error) {
 	return ncv, nil
 }
 
+func (q *checker) tcheckExprTernaryOp(n *a.Expr, depth uint32) error {
+	cond := n.LHS().AsExpr()
+	if err := q.tcheckExpr(cond, depth); err != nil {
+		return err
+	}
+	if !cond.MType().IsBool() {
+		return fmt.Errorf("check: ternary %q: %q, of type %q, does not have a boolean type",
+			n.Operator().AmbiguousForm().Str(q.tm), cond.Str(q.tm), cond.MType().Str(q.tm))
+	}
+
+	mhs := n.MHS().AsExpr()
+	if err := q.tcheckExpr(mhs, depth); err != nil {
+		return err
+	}
+	mTyp := mhs.MType()
+	rhs := n.RHS().AsExpr()
+	if err := q.tcheckExpr(rhs, depth); err != nil {
+		return err
+	}
+	rTyp := rhs.MType()
+
+	typ := mTyp
+	if mTyp.IsIdeal() && rTyp.IsNumTypeOrIdeal() {
+		typ = rTyp
+	} else if rTyp.IsIdeal() && mTyp.IsNumTypeOrIdeal() {
+		// No-op.
+	} else if !mTyp.EqIgnoringRefinements(rTyp) {
+		return fmt.Errorf("check: ternary %q: %q and %q, of types %q and %q, do not have compatible types",
+			n.Operator().AmbiguousForm().Str(q.tm),
+			mhs.Str(q.tm), rhs.Str(q.tm),
+			mTyp.Str(q.tm), rTyp.Str(q.tm),
+		)
+	}
+
+	if ccv, mcv, rcv := cond.ConstValue(), mhs.ConstValue(), rhs.ConstValue(); ccv != nil && mcv != nil && rcv != nil {
+		if ccv.Sign() != 0 {
+			n.SetConstValue(mcv)
+		} else {
+			n.SetConstValue(rcv)
+		}
+	}
+	n.SetMType(typ.Unrefined())
+	return nil
+}
+
 func (q *checker) tcheckTypeExpr(typ *a.TypeExpr, depth uint32) error {
 	if depth > a.MaxTypeExprDepth {
 		return fmt.Errorf("check: type expression recursion depth too large")
@@ -1243,8 +1243,41 @@ func (p *parser) parseExpr() (*a.Expr, error) {
 	return e, nil
 }
 
+// parseExpr1 parses an expression, possibly a "cond ? a : b" ternary. The
+// ternary operator binds more loosely than any binary operator and is
+// right-associative, so that both the "a" and "b" can be ternary expressions.
 func (p *parser) parseExpr1() (*a.Expr, error) {
-	lhs, err := p.parseOperand()
+	lhs, err := p.parseExpr2()
+	if err != nil {
+		return nil, err
+	}
+	x := p.peek1()
+	if !x.IsTernaryOp() {
+		return lhs, nil
+	}
+	p.src = p.src[1:]
+	mhs, err := p.parseExpr1()
+	if err != nil {
+		return nil, err
+	}
+	if y := p.peek1(); y != t.IDColon {
+		got := p.tm.ByID(y)
+		return nil, fmt.Errorf(`parse: expected ":", got %q at %s:%d`, got, p.filename, p.line())
+	}
+	p.src = p.src[1:]
+	rhs, err := p.parseExpr1()
+	if err != nil {
+		return nil, err
+	}
+	op := x.TernaryForm()
+	if op == 0 {
+		return nil, fmt.Errorf(`parse: internal error: no ternary form for token 0x%02X`, x)
+	}
+	return a.NewExpr(0, op, 0, lhs.AsNode(), mhs.AsNode(), rhs.AsNode(), nil), nil
+}
+
+func (p *parser) parseExpr2() (*a.Expr, error) {
+	lhs, err := p.parseUnary()
 	if err != nil {
 		return nil, err
 	}
@@ -1258,7 +1291,7 @@ func (p *parser) parseExpr1() (*a.Expr, error) {
 			}
 			rhs = o.AsNode()
 		} else {
-			o, err := p.parseOperand()
+			o, err := p.parseUnary()
 			if err != nil {
 				return nil, err
 			}
@@ -1276,7 +1309,7 @@ func (p *parser) parseExpr1() (*a.Expr, error) {
 		args := []*a.Node{lhs.AsNode(), rhs}
 		for p.peek1() == x {
 			p.src = p.src[1:]
-			arg, err := p.parseOperand()
+			arg, err := p.parseUnary()
 			if err != nil {
 				return nil, err
 			}
@@ -1291,20 +1324,47 @@ func (p *parser) parseExpr1() (*a.Expr, error) {
 	return lhs, nil
 }
 
+// parseUnary parses a power, possibly after unary operators. The "**"
+// operator binds more tightly than they do, so that "-x ** 2" means "-(x **
+// 2)", as in mathematics.
+func (p *parser) parseUnary() (*a.Expr, error) {
+	x := p.peek1()
+	if !x.IsUnaryOp() {
+		return p.parsePower()
+	}
+	p.src = p.src[1:]
+	rhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	op := x.UnaryForm()
+	if op == 0 {
+		return nil, fmt.Errorf(`parse: internal error: no unary form for token 0x%02X`, x)
+	}
+	return a.NewExpr(0, op, 0, nil, nil, rhs.AsNode(), nil), nil
+}
+
+// parsePower parses an operand, possibly raised to a power: "x ** y". The
+// "**" operator binds more tightly than any other operator and is
+// right-associative. Its exponent may have unary operators, as in "x ** -y".
+func (p *parser) parsePower() (*a.Expr, error) {
+	lhs, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek1() != t.IDStarStar {
+		return lhs, nil
+	}
+	p.src = p.src[1:]
+	rhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return a.NewExpr(0, t.IDXBinaryStarStar, 0, lhs.AsNode(), nil, rhs.AsNode(), nil), nil
+}
+
 func (p *parser) parseOperand() (*a.Expr, error) {
 	switch x := p.peek1(); {
-	case x.IsUnaryOp():
-		p.src = p.src[1:]
-		rhs, err := p.parseOperand()
-		if err != nil {
-			return nil, err
-		}
-		op := x.UnaryForm()
-		if op == 0 {
-			return nil, fmt.Errorf(`parse: internal error: no unary form for token 0x%02X`, x)
-		}
-		return a.NewExpr(0, op, 0, nil, nil, rhs.AsNode(), nil), nil
-
 	case x.IsLiteral(p.tm):
 		p.src = p.src[1:]
 		return a.NewExpr(0, 0, x, nil, nil, nil, nil), nil
@@ -1335,7 +1395,15 @@ func (p *parser) parseOperand() (*a.Expr, error) {
 		default:
 			return lhs, nil
 
-		case t.IDExclam, t.IDQuestion:
+		case t.IDQuestion:
+			// A "?" that isn't followed by a "()" or "(name:" is a ternary
+			// operator, not a coroutine call.
+			if !p.peekCoroutineCall() {
+				return lhs, nil
+			}
+			fallthrough
+
+		case t.IDExclam:
 			flags |= p.parseEffect().AsFlags()
 			fallthrough
 
@@ -1369,6 +1437,18 @@ func (p *parser) parseOperand() (*a.Expr, error) {
 	}
 }
 
+// peekCoroutineCall returns whether the next tokens are "?()" or "?(name:",
+// the start of a coroutine call's argument list.
+func (p *parser) peekCoroutineCall() bool {
+	if (len(p.src) < 3) || (p.src[0].ID != t.IDQuestion) || (p.src[1].ID != t.IDOpenParen) {
+		return false
+	}
+	if p.src[2].ID == t.IDCloseParen {
+		return true
+	}
+	return (len(p.src) >= 4) && p.src[2].ID.IsIdent(p.tm) && (p.src[3].ID == t.IDColon)
+}
+
 func (p *parser) parseEffect() a.Effect {
 	switch p.peek1() {
 	case t.IDExclam:
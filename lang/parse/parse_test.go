@@ -0,0 +1,112 @@
+// Copyright 2017 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parse_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/wuffs/lang/parse"
+
+	a "github.com/google/wuffs/lang/ast"
+	t "github.com/google/wuffs/lang/token"
+)
+
+// treeShape renders n as an s-expression, so that tests can check how the
+// parser grouped an expression without relying on Str's parenthesization.
+// Operators other than the ones listed below are rendered by Str.
+func treeShape(tm *t.Map, n *a.Expr) string {
+	switch n.Operator() {
+	case t.IDXUnaryMinus:
+		return "(- " + treeShape(tm, n.RHS().AsExpr()) + ")"
+	case t.IDXBinaryStarStar:
+		return "(** " + treeShape(tm, n.LHS().AsExpr()) + " " +
+			treeShape(tm, n.RHS().AsExpr()) + ")"
+	case t.IDXTernary:
+		return "(? " + treeShape(tm, n.LHS().AsExpr()) + " " +
+			treeShape(tm, n.MHS().AsExpr()) + " " +
+			treeShape(tm, n.RHS().AsExpr()) + ")"
+	}
+	return n.Str(tm)
+}
+
+func TestParseExprTreeShape(tt *testing.T) {
+	const filename = "test.wuffs"
+	testCases := []struct {
+		src  string
+		want string
+	}{
+		{"-x ** 2", "(- (** x 2))"},
+		{"(-x) ** 2", "(** (- x) 2)"},
+		{"x ** -y", "(** x (- y))"},
+		{"-x ** -y", "(- (** x (- y)))"},
+		{"a ** b ** c", "(** a (** b c))"},
+		{"-a ** b ** c", "(- (** a (** b c)))"},
+
+		{"a ? b : c", "(? a b c)"},
+		{"a ? b : c ? d : e", "(? a b (? c d e))"},
+		{"a ? b ? c : d : e", "(? a (? b c d) e)"},
+		{"(a ? b : c) ? d : e", "(? (? a b c) d e)"},
+
+		// A "?" followed by "()" or "(ident:" is a coroutine call, not a
+		// ternary. Other "?"s, even before a "(", are ternaries.
+		{"f?()", "f?()"},
+		{"f?(x: y)", "f?(x: y)"},
+		{"f ? (x) : y", "(? f x y)"},
+	}
+
+	tm := &t.Map{}
+	for _, tc := range testCases {
+		tokens, _, err := t.Tokenize(tm, filename, []byte(tc.src))
+		if err != nil {
+			tt.Errorf("Tokenize(%q): %v", tc.src, err)
+			continue
+		}
+		expr, err := parse.ParseExpr(tm, filename, tokens, nil)
+		if err != nil {
+			tt.Errorf("ParseExpr(%q): %v", tc.src, err)
+			continue
+		}
+		if got := treeShape(tm, expr); got != tc.want {
+			tt.Errorf("src %q: got %q, want %q", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestParseExprTernaryNextToCoroutineCall(tt *testing.T) {
+	const filename = "test.wuffs"
+	// These parse "f?(x: y)" and "f?()" as coroutine calls and the other "?"
+	// as a ternary. They are then rejected, but only because the calls have
+	// an effect: misreading any "?" would give an unexpected token error.
+	testCases := []string{
+		"f?(x: y) ? a : b",
+		"c ? f?() : b",
+		"c ? a : f?(x: y)",
+	}
+
+	tm := &t.Map{}
+	for _, src := range testCases {
+		tokens, _, err := t.Tokenize(tm, filename, []byte(src))
+		if err != nil {
+			tt.Errorf("Tokenize(%q): %v", src, err)
+			continue
+		}
+		if _, err := parse.ParseExpr(tm, filename, tokens, nil); err == nil {
+			tt.Errorf("ParseExpr(%q): got nil error, want non-nil", src)
+		} else if !strings.Contains(err.Error(), "effect-ful sub-expression") {
+			tt.Errorf("ParseExpr(%q): got %q, want an effect-ful sub-expression error", src, err)
+		}
+	}
+}
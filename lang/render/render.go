@@ -154,11 +154,35 @@ func Render(w io.Writer, tm *t.Map, src []t.Token, comments []string) (err error
 
 		// Render the lineTokens.
 		prevID, prevIsTightRight := t.ID(0), false
-		for _, tok := range lineTokens {
-			if prevID == t.IDEq || (prevID != 0 && !prevIsTightRight && !tok.ID.IsTightLeft()) {
+		// The "?" and ":" tokens' tight-left-ness is also context dependent.
+		// For "f?(x)" and "f(a: x)", they are tight-left. For the ternary
+		// "c ? x : y", they are not. ternaryDepths holds the open-bracket
+		// nesting depth of each pending ternary "?".
+		openDepth, ternaryDepths, prevTernary := 0, []int(nil), false
+		for i, tok := range lineTokens {
+			ternary := false
+			if tok.ID.IsOpen() {
+				openDepth++
+			} else if tok.ID.IsClose() {
+				openDepth--
+			} else if tok.ID == t.IDQuestion {
+				if (prevID != t.IDYield) && isTernaryQuestion(tm, lineTokens[i:]) {
+					ternary = true
+					ternaryDepths = append(ternaryDepths, openDepth)
+				}
+			} else if tok.ID == t.IDColon {
+				if n := len(ternaryDepths); (n > 0) && (ternaryDepths[n-1] == openDepth) {
+					ternary = true
+					ternaryDepths = ternaryDepths[:n-1]
+				}
+			}
+
+			if ternary && (prevID != 0) {
+				buf = append(buf, ' ')
+			} else if prevID == t.IDEq || (prevID != 0 && !prevIsTightRight && !tok.ID.IsTightLeft()) {
 				// The "(" token's tight-left-ness is context dependent. For
 				// "f(x)", the "(" is tight-left. For "a * (b + c)", it is not.
-				if tok.ID != t.IDOpenParen || !isCloseIdentStrLiteralQuestion(tm, prevID) {
+				if tok.ID != t.IDOpenParen || !isCloseIdentStrLiteralQuestion(tm, prevID) || prevTernary {
 					buf = append(buf, ' ')
 				}
 			}
@@ -181,7 +205,8 @@ func Render(w io.Writer, tm *t.Map, src []t.Token, comments []string) (err error
 				indent--
 			}
 
-			prevIsTightRight = tok.ID.IsTightRight()
+			prevIsTightRight = tok.ID.IsTightRight() && !ternary
+			prevTernary = ternary
 			// The "+" and "-" tokens' tight-right-ness is context dependent.
 			// The unary flavor is tight-right, the binary flavor is not.
 			if prevID != 0 && tok.ID.IsUnaryOp() && tok.ID.IsBinaryOp() {
@@ -308,6 +333,28 @@ func isCloseIdentStrLiteralQuestion(tm *t.Map, x t.ID) bool {
 		x.IsSQStrLiteral(tm) || (x == t.IDQuestion)
 }
 
+// isTernaryQuestion returns whether lineTokens starts with a "?" that is the
+// ternary operator, instead of an effect marker like "f?(x: y)", "func foo?()"
+// or "struct bar?(" or "struct bar? implements". The caller is responsible for
+// checking for a preceding "yield".
+func isTernaryQuestion(tm *t.Map, lineTokens []t.Token) bool {
+	if len(lineTokens) < 2 {
+		return false
+	}
+	switch lineTokens[1].ID {
+	case t.IDImplements:
+		return false
+	case t.IDOpenParen:
+		if len(lineTokens) < 3 {
+			return false
+		} else if lineTokens[2].ID == t.IDCloseParen {
+			return false
+		}
+		return (len(lineTokens) < 4) || !lineTokens[2].ID.IsIdent(tm) || (lineTokens[3].ID != t.IDColon)
+	}
+	return true
+}
+
 func findColon(lineTokens []t.Token) int {
 	for i, lt := range lineTokens {
 		if lt.ID == t.IDColon {
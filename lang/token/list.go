@@ -14,6 +14,14 @@
 
 package token
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
 // MaxIntBits is the largest size (in bits) of the i8, u8, i16, u16, etc.
 // integer types.
 const MaxIntBits = 64
@@ -29,6 +37,22 @@ type ID uint32
 // Str returns a string form of x.
 func (x ID) Str(m *Map) string { return m.ByID(x) }
 
+// LessByName returns whether x's spelling sorts before y's. Unlike comparing
+// the numeric values, the result does not depend on the order in which IDs
+// were declared (for built-ins) or inserted into m (for others).
+func (x ID) LessByName(m *Map, y ID) bool { return m.ByID(x) < m.ByID(y) }
+
+// SortIDsByName sorts ids by their spelling. IDs with equal spellings (such
+// as two IDs with no spelling) are ordered by numeric value.
+func SortIDsByName(m *Map, ids []ID) {
+	sort.Slice(ids, func(i int, j int) bool {
+		if si, sj := m.ByID(ids[i]), m.ByID(ids[j]); si != sj {
+			return si < sj
+		}
+		return ids[i] < ids[j]
+	})
+}
+
 func (x ID) AmbiguousForm() ID {
 	if x >= ID(len(ambiguousForms)) {
 		return 0
@@ -57,12 +81,91 @@ func (x ID) AssociativeForm() ID {
 	return associativeForms[x]
 }
 
+func (x ID) TernaryForm() ID {
+	if x >= ID(len(ternaryForms)) {
+		return 0
+	}
+	return ternaryForms[x]
+}
+
+// DisambiguateForm returns the X-form of the ambiguous operator x, given
+// whether it is in prefix position (with only a right hand operand) or infix
+// position. For example, IDPlus is IDXUnaryPlus in prefix position and
+// IDXBinaryPlus in infix position. A unary-only operator, such as IDNot, has
+// its unary form in either position. It returns 0 if there is no such form,
+// such as for IDSlash in prefix position.
+func (x ID) DisambiguateForm(prefix bool) ID {
+	if !prefix {
+		if y := x.BinaryForm(); y != 0 {
+			return y
+		}
+	}
+	return x.UnaryForm()
+}
+
 func (x ID) IsBuiltIn() bool { return x < nBuiltInIDs }
 
+// BuiltInName returns x's spelling, or "" if x is not a named built-in ID.
+func (x ID) BuiltInName() string {
+	if x < nBuiltInIDs {
+		return builtInsByID[x]
+	}
+	return ""
+}
+
+// AllBuiltInIDs returns, in numerical order, every built-in ID that has a
+// name.
+func AllBuiltInIDs() []ID {
+	ret := []ID(nil)
+	for i, name := range builtInsByID {
+		if name != "" {
+			ret = append(ret, ID(i))
+		}
+	}
+	return ret
+}
+
+// KeywordSpellings returns, in numerical order of their IDs, the spellings of
+// the keywords, such as "func" and "while".
+func KeywordSpellings() []string {
+	return spellings(minKeyword, maxKeyword)
+}
+
+// OperatorSpellings returns, in numerical order of their IDs, the spellings
+// of the assignment operators, such as "=" and "+=", and of the other
+// operators, such as "+", "==" and "not".
+func OperatorSpellings() []string {
+	return append(spellings(minAssign, maxAssign), spellings(minOp, maxOp)...)
+}
+
+// TypeSpellings returns, in numerical order of their IDs, the spellings of the
+// numeric types, such as "u32", and of the type modifiers, such as "ptr" and
+// "slice".
+func TypeSpellings() []string {
+	return append(spellings(minNumType, maxNumType), spellings(minTypeModifier, maxTypeModifier)...)
+}
+
+// spellings returns the names of the built-in IDs in [lo, hi], skipping those
+// without names.
+func spellings(lo ID, hi ID) []string {
+	ret := []string(nil)
+	for x := lo; x <= hi; x++ {
+		if name := builtInsByID[x]; name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
 func (x ID) IsUnaryOp() bool       { return minOp <= x && x <= maxOp && unaryForms[x] != 0 }
 func (x ID) IsBinaryOp() bool      { return minOp <= x && x <= maxOp && binaryForms[x] != 0 }
 func (x ID) IsAssociativeOp() bool { return minOp <= x && x <= maxOp && associativeForms[x] != 0 }
 
+// IsTernaryOp returns whether x is the "?" in "cond ? a : b". Unlike the other
+// operators, the ambiguous form, IDQuestion, lies outside of the [minOp,
+// maxOp] range, as it is also the punctuation for coroutine calls: "f?(etc)".
+func (x ID) IsTernaryOp() bool { return x < ID(len(ternaryForms)) && ternaryForms[x] != 0 }
+
 func (x ID) IsLiteral(m *Map) bool {
 	if x < nBuiltInIDs {
 		return minBuiltInLiteral <= x && x <= maxBuiltInLiteral
@@ -72,6 +175,10 @@ func (x ID) IsLiteral(m *Map) bool {
 	return false
 }
 
+// IsComment returns whether x is a "//" comment, as emitted by
+// TokenizeWithOptions when KeepComments is set.
+func (x ID) IsComment() bool { return x == IDComment }
+
 func (x ID) IsNumLiteral(m *Map) bool {
 	if x < nBuiltInIDs {
 		return minBuiltInNumLiteral <= x && x <= maxBuiltInNumLiteral
@@ -101,6 +208,67 @@ func (x ID) IsSQStrLiteral(m *Map) bool {
 	return false
 }
 
+// IsRawStrLiteral returns whether x is a backtick-delimited raw string
+// literal, such as `a"b\c`, whose contents have no escapes. Backticks do not
+// nest: the first backtick after the opening one ends the literal.
+func (x ID) IsRawStrLiteral(m *Map) bool {
+	if x < nBuiltInIDs {
+		return false
+	} else if s := m.ByID(x); s != "" {
+		return s[0] == '`'
+	}
+	return false
+}
+
+// SpellingWidth returns the number of display columns that x's spelling
+// occupies, one per rune. Built-in and identifier spellings are ASCII, but
+// string literals need not be. An X-form, which has no spelling of its own, is
+// as wide as its RenderForm symbol. Comment text is not covered: it is not in
+// the Map, so an IDComment is as wide as "//".
+func (x ID) SpellingWidth(m *Map) int {
+	s := m.ByID(x)
+	if s == "" {
+		s, _ = x.RenderForm()
+	}
+	return utf8.RuneCountInString(s)
+}
+
+// StrLiteralValue returns the bytes that x, a double-quote or single-quote
+// string literal, denotes: its spelling without the quotes (or the "be" or
+// "le" suffix) and with its backslash escapes, such as "\n" and "\xFF",
+// decoded. The bytes are in source order, regardless of any suffix. For a raw
+// string literal, it is the spelling without the backticks, verbatim.
+func (x ID) StrLiteralValue(m *Map) ([]byte, error) {
+	s := ""
+	if x >= nBuiltInIDs {
+		s = m.ByID(x)
+	}
+	if unescaped, ok := Unescape(s); ok {
+		return []byte(unescaped), nil
+	}
+	return nil, fmt.Errorf("token: invalid string literal %q", s)
+}
+
+// NumLiteralValue returns the value of x, a numeric literal such as "42",
+// "1_000" or "0xFF". The negative result is always false, as Wuffs numeric
+// literals are non-negative: a minus sign is a separate, unary operator.
+//
+// It returns an error if x is not a valid numeric literal or if its value
+// does not fit in MaxIntBits bits.
+func (x ID) NumLiteralValue(m *Map) (value uint64, negative bool, err error) {
+	s := m.ByID(x)
+	if !x.IsNumLiteral(m) || !IsValidNumLiteral(s) {
+		return 0, false, fmt.Errorf("token: invalid numeric literal %q", s)
+	}
+	value, err = strconv.ParseUint(strings.Replace(s, "_", "", -1), 0, MaxIntBits)
+	if e, ok := err.(*strconv.NumError); ok && (e.Err == strconv.ErrRange) {
+		return 0, false, fmt.Errorf("token: numeric literal %q overflows %d bits", s, MaxIntBits)
+	} else if err != nil {
+		return 0, false, fmt.Errorf("token: invalid numeric literal %q", s)
+	}
+	return value, false, nil
+}
+
 func (x ID) IsIdent(m *Map) bool {
 	if x < nBuiltInIDs {
 		return minBuiltInIdent <= x && x <= maxBuiltInIdent
@@ -110,9 +278,32 @@ func (x ID) IsIdent(m *Map) bool {
 	return false
 }
 
+// IsTightLeft returns whether x is rendered without a space to its left, as
+// for ")" or ",". IsTightRight returns whether x is rendered without a space
+// to its right, as for "(" or ".". A formatter puts a space between two
+// adjacent tokens on a line unless the first is tight-right or the second is
+// tight-left. See SpaceBetween.
 func (x ID) IsTightLeft() bool  { return x < ID(len(isTightLeft)) && isTightLeft[x] }
 func (x ID) IsTightRight() bool { return x < ID(len(isTightRight)) && isTightRight[x] }
 
+// SpaceBetween returns whether a formatter should put a space between the
+// adjacent tokens left and right, on the same line: unless left is
+// tight-right or right is tight-left. As a special case, "(" is tight-left
+// after what looks like a callee, such as an identifier, a ")" or the "?" in
+// "f?(x)", so that "foo(x)" has no space but "a * (b + c)" does.
+//
+// Some other spacing is context dependent, beyond what two IDs can tell. For
+// example, the unary "-x" is tight-right but the binary "a - b" is not, and
+// the ternary "c ? x : y" has spaces where "f?(x)" does not. The lang/render
+// package resolves those.
+func SpaceBetween(left ID, right ID) bool {
+	if (right == IDOpenParen) &&
+		(left.IsClose() || (left >= minBuiltInIdent) || (left == IDQuestion)) {
+		return false
+	}
+	return !left.IsTightRight() && !right.IsTightLeft()
+}
+
 func (x ID) IsAssign() bool         { return minAssign <= x && x <= maxAssign }
 func (x ID) IsCannotAssignTo() bool { return minCannotAssignTo <= x && x <= maxCannotAssignTo }
 func (x ID) IsClose() bool          { return minClose <= x && x <= maxClose }
@@ -121,14 +312,129 @@ func (x ID) IsNumType() bool        { return minNumType <= x && x <= maxNumType
 func (x ID) IsNumTypeOrIdeal() bool { return minNumTypeOrIdeal <= x && x <= maxNumTypeOrIdeal }
 func (x ID) IsOpen() bool           { return minOpen <= x && x <= maxOpen }
 
+// IsPointerType returns whether x is a pointer-like type modifier: ptr or
+// nptr.
+func (x ID) IsPointerType() bool { return (x == IDPtr) || (x == IDNptr) }
+
+// IsContainerType returns whether x is a container type modifier: array or
+// slice.
+func (x ID) IsContainerType() bool { return (x == IDArray) || (x == IDSlice) }
+
+// IsNilableType returns whether x is a type modifier whose values can be nil:
+// nptr, but not ptr.
+func (x ID) IsNilableType() bool { return x == IDNptr }
+
+// ComparisonCounterpart returns the ID that x is easily confused with, for a
+// linter to suggest as a fix: IDEqEq for IDEq ("=" where "==" was meant) and
+// vice versa, and IDNotEq for IDExclam (the "!" of a C-style "!=", where Wuffs
+// spells not-equal as "<>"). It returns 0 for all other IDs.
+//
+// This is an advisory mapping for tooling, not a language semantic: the two
+// IDs are not interchangeable.
+func (x ID) ComparisonCounterpart() ID {
+	switch x {
+	case IDEq:
+		return IDEqEq
+	case IDEqEq:
+		return IDEq
+	case IDExclam:
+		return IDNotEq
+	}
+	return 0
+}
+
+// IsRangeOp returns whether x is ".." or "..=", as in "a[i .. j]" or "i ..= j".
+func (x ID) IsRangeOp() bool { return (x == IDDotDot) || (x == IDDotDotEq) }
+
+// IsEffectMarker returns whether x is "!" or "?", which, straight after a
+// function name as in "func foo!()" or "func bar?()", mark that function as
+// impure or as a coroutine (one that can suspend).
+//
+// This is advisory, for a parser that already knows that it is in effect
+// position. Elsewhere, the same IDs play other roles: "!" is half of a
+// C-style "!=" typo (see ComparisonCounterpart) and "?" is the effect marker
+// on a call, as in "x = bar?()". Multi-byte tokens such as "=?" are distinct
+// IDs and never effect markers.
+func (x ID) IsEffectMarker() bool { return (x == IDExclam) || (x == IDQuestion) }
+
 func (x ID) IsImplicitSemicolon(m *Map) bool {
 	return x.IsClose() || x.IsKeyword() || x.IsIdent(m) || x.IsLiteral(m)
 }
 
+// IsUnaryMinus returns whether x is the unary (prefix) minus, as in "-x". The
+// ambiguous IDMinus is not, as it can also be the binary minus: call
+// DisambiguateForm first to resolve it by position.
+func (x ID) IsUnaryMinus() bool { return x == IDXUnaryMinus }
+
 func (x ID) IsXOp() bool            { return minXOp <= x && x <= maxXOp }
 func (x ID) IsXUnaryOp() bool       { return minXOp <= x && x <= maxXOp && unaryForms[x] != 0 }
 func (x ID) IsXBinaryOp() bool      { return minXOp <= x && x <= maxXOp && binaryForms[x] != 0 }
 func (x ID) IsXAssociativeOp() bool { return minXOp <= x && x <= maxXOp && associativeForms[x] != 0 }
+func (x ID) IsXTernaryOp() bool     { return minXOp <= x && x <= maxXOp && ternaryForms[x] != 0 }
+
+// RenderForm returns the source spelling of the X-form x, such as "-" for
+// both IDXUnaryMinus and IDXBinaryMinus, and its arity: 1 for unary forms, 2
+// for binary and associative forms and 3 for the ternary form. It returns
+// ("", 0) if x is not an X-form.
+func (x ID) RenderForm() (symbol string, arity int) {
+	if !x.IsXOp() {
+		return "", 0
+	}
+	y := x.AmbiguousForm()
+	if y == 0 {
+		return "", 0
+	}
+	switch {
+	case x.IsXUnaryOp():
+		arity = 1
+	case x.IsXBinaryOp(), x.IsXAssociativeOp():
+		arity = 2
+	case x.IsXTernaryOp():
+		arity = 3
+	}
+	return builtInsByID[y], arity
+}
+
+// IsBitwiseOp returns whether x, or its ambiguous form, is a bitwise operator
+// such as "&" or "<<".
+func (x ID) IsBitwiseOp() bool {
+	if y := x.AmbiguousForm(); y != 0 {
+		x = y
+	}
+	return x < ID(len(isBitwiseOp)) && isBitwiseOp[x]
+}
+
+// IsArithmeticOp returns whether x, or its ambiguous form, is an arithmetic
+// operator such as "+" or "~mod*".
+func (x ID) IsArithmeticOp() bool {
+	if y := x.AmbiguousForm(); y != 0 {
+		x = y
+	}
+	return x < ID(len(isArithmeticOp)) && isArithmeticOp[x]
+}
+
+// IsControlFlowKeyword returns whether x is a keyword that branches, loops or
+// otherwise transfers control, such as "if", "while" or "return". Unlike
+// IsKeyword, it excludes declaration keywords such as "func", "struct" and
+// "const", and other keywords such as "assert" and "io_bind".
+func (x ID) IsControlFlowKeyword() bool {
+	return x < ID(len(isControlFlowKeyword)) && isControlFlowKeyword[x]
+}
+
+// IsReservedMarker returns whether x is one of the built-in IDs whose string
+// representation is deliberately non-ASCII, such as "†" or "«Ideal»". The
+// tokenizer never produces them and code generators must not emit them.
+func IsReservedMarker(x ID) bool {
+	return ((IDDagger1 <= x) && (x <= IDDagger2)) ||
+		((IDQNullptr <= x) && (x <= IDQIdeal))
+}
+
+// IsDeclarationKeyword returns whether x is a keyword that begins a top-level
+// or scoped declaration, such as "func", "struct" or "var". It is disjoint
+// from IsControlFlowKeyword.
+func (x ID) IsDeclarationKeyword() bool {
+	return x < ID(len(isDeclarationKeyword)) && isDeclarationKeyword[x]
+}
 
 func (x ID) SmallPowerOf2Value() int {
 	switch x {
@@ -154,6 +460,52 @@ func (x ID) SmallPowerOf2Value() int {
 	return 0
 }
 
+// NumTypeMin returns the smallest value representable by the numeric type x,
+// such as -128 for i8. The bool is whether x is a numeric type.
+func (x ID) NumTypeMin() (int64, bool) {
+	if !x.IsNumType() {
+		return 0, false
+	}
+	if x >= IDU8 {
+		return 0, true
+	}
+	return -1 << (numTypeBits(x) - 1), true
+}
+
+// NumTypeMax returns the largest value representable by the numeric type x,
+// such as 65535 for u16. The bool is whether x is a numeric type.
+func (x ID) NumTypeMax() (uint64, bool) {
+	if !x.IsNumType() {
+		return 0, false
+	}
+	if x >= IDU8 {
+		return 1<<numTypeBits(x) - 1, true
+	}
+	return 1<<(numTypeBits(x)-1) - 1, true
+}
+
+// numTypeBits returns the bit width of the numeric type x, which relies on the
+// IDI8..IDU64 block being ordered by signedness and then by width.
+func numTypeBits(x ID) uint32 {
+	return 8 << ((x - minNumType) & 3)
+}
+
+// ZeroValueID returns the literal for the zero (default) value of the type x:
+// ID0 for the numeric types (including ideal), IDFalse for bool and
+// IDNullptr for the nptr type modifier. It returns 0 for types with no
+// literal zero value, such as ptr, slice, array and struct types.
+func (x ID) ZeroValueID() ID {
+	switch {
+	case x.IsNumTypeOrIdeal():
+		return ID0
+	case x == IDBool:
+		return IDFalse
+	case x == IDNptr:
+		return IDNullptr
+	}
+	return 0
+}
+
 // QID is a qualified ID, such as "foo.bar". QID[0] is "foo"'s ID and QID[1] is
 // "bar"'s. QID[0] may be 0 for a plain "bar".
 type QID [2]ID
@@ -201,10 +553,14 @@ func (x QQID) Str(m *Map) string {
 	return m.ByID(x[2])
 }
 
-// Token combines an ID and the line number it was seen.
+// Token combines an ID and the position it was seen: its line number and
+// column. The column is the 1-based byte offset of the token's first byte
+// within its line. It is 0 for a synthesized token with no source text, such
+// as an implicit semicolon.
 type Token struct {
-	ID   ID
-	Line uint32
+	ID     ID
+	Line   uint32
+	Column uint32
 }
 
 // nBuiltInIDs is the number of built-in IDs. The packing is:
@@ -236,6 +592,12 @@ const (
 	IDExclam    = ID(0x06)
 	IDQuestion  = ID(0x07)
 	IDColon     = ID(0x08)
+	IDAt        = ID(0x09)
+
+	// IDComment is a "//" comment, emitted by TokenizeWithOptions when
+	// KeepComments is set. The comment's text is not in the Map: it is the
+	// comments return value's element for the token's Line.
+	IDComment = ID(0x0A)
 )
 
 const (
@@ -271,6 +633,8 @@ const (
 	IDHatEq     = ID(0x28)
 	IDPercentEq = ID(0x29)
 
+	IDStarStarEq = ID(0x2A)
+
 	IDTildeModPlusEq   = ID(0x30)
 	IDTildeModMinusEq  = ID(0x31)
 	IDTildeModStarEq   = ID(0x32)
@@ -302,6 +666,12 @@ const (
 	IDHat     = ID(0x48)
 	IDPercent = ID(0x49)
 
+	// IDStarStar is the power operator. It binds more tightly than any other
+	// binary operator and is right-associative: "a * b ** c ** d" means "a *
+	// (b ** (c ** d))". C has no power operator, so the type checker only
+	// accepts constant powers, such as "2 ** 4", and rejects every "**=".
+	IDStarStar = ID(0x4A)
+
 	IDTildeModPlus   = ID(0x50)
 	IDTildeModMinus  = ID(0x51)
 	IDTildeModStar   = ID(0x52)
@@ -320,6 +690,7 @@ const (
 	IDAnd = ID(0x68)
 	IDOr  = ID(0x69)
 	IDAs  = ID(0x6A)
+	IDXor = ID(0x6B)
 
 	IDNot = ID(0x6F)
 
@@ -337,6 +708,8 @@ const (
 	IDXBinaryHat     = ID(0x78)
 	IDXBinaryPercent = ID(0x79)
 
+	IDXBinaryStarStar = ID(0x7A)
+
 	IDXBinaryTildeModPlus   = ID(0x80)
 	IDXBinaryTildeModMinus  = ID(0x81)
 	IDXBinaryTildeModStar   = ID(0x82)
@@ -355,6 +728,7 @@ const (
 	IDXBinaryAnd = ID(0x98)
 	IDXBinaryOr  = ID(0x99)
 	IDXBinaryAs  = ID(0x9A)
+	IDXBinaryXor = ID(0x9B)
 
 	IDXAssociativePlus = ID(0xA0)
 	IDXAssociativeStar = ID(0xA1)
@@ -363,6 +737,12 @@ const (
 	IDXAssociativeHat  = ID(0xA4)
 	IDXAssociativeAnd  = ID(0xA5)
 	IDXAssociativeOr   = ID(0xA6)
+	IDXAssociativeXor  = ID(0xA7)
+
+	// IDXTernary is the "cond ? a : b" form of IDQuestion. It binds more
+	// loosely than any binary operator and is right-associative: "a ? b : c ?
+	// d : e" means "a ? b : (c ? d : e)".
+	IDXTernary = ID(0xA8)
 
 	IDXUnaryPlus  = ID(0xAC)
 	IDXUnaryMinus = ID(0xAD)
@@ -464,6 +844,11 @@ const (
 	// See the ID.IsNumTypeOrIdeal method.
 	IDQIdeal = ID(0x10F)
 
+	// IdealIntegerTypeID and GenericTypeID are more descriptive names, for
+	// type checker code, of two reserved markers. See IsReservedMarker.
+	IdealIntegerTypeID = IDQIdeal
+	GenericTypeID      = IDDagger1
+
 	IDI8  = ID(0x110)
 	IDI16 = ID(0x111)
 	IDI32 = ID(0x112)
@@ -523,6 +908,9 @@ const (
 	IDLimitedCopyU32FromReader      = ID(0x173)
 	IDLimitedCopyU32FromSlice       = ID(0x174)
 	IDLimitedCopyU32ToSlice         = ID(0x175)
+	IDCopyFromHistory64             = ID(0x176)
+	IDCopyFromReader64              = ID(0x177)
+	IDCopyFromSlice64               = ID(0x178)
 
 	// -------- 0x180 block.
 
@@ -675,6 +1063,8 @@ var builtInsByID = [nBuiltInIDs]string{
 	IDExclam:    "!",
 	IDQuestion:  "?",
 	IDColon:     ":",
+	IDAt:        "@",
+	IDComment:   "//",
 
 	IDOpenParen:       "(",
 	IDOpenBracket:     "[",
@@ -697,6 +1087,8 @@ var builtInsByID = [nBuiltInIDs]string{
 	IDHatEq:     "^=",
 	IDPercentEq: "%=",
 
+	IDStarStarEq: "**=",
+
 	IDTildeModPlusEq:   "~mod+=",
 	IDTildeModMinusEq:  "~mod-=",
 	IDTildeModStarEq:   "~mod*=",
@@ -719,6 +1111,8 @@ var builtInsByID = [nBuiltInIDs]string{
 	IDHat:     "^",
 	IDPercent: "%",
 
+	IDStarStar: "**",
+
 	IDTildeModPlus:   "~mod+",
 	IDTildeModMinus:  "~mod-",
 	IDTildeModStar:   "~mod*",
@@ -737,6 +1131,7 @@ var builtInsByID = [nBuiltInIDs]string{
 	IDAnd: "and",
 	IDOr:  "or",
 	IDAs:  "as",
+	IDXor: "xor",
 
 	IDNot: "not",
 
@@ -890,6 +1285,9 @@ var builtInsByID = [nBuiltInIDs]string{
 	IDLimitedCopyU32FromReader:      "limited_copy_u32_from_reader",
 	IDLimitedCopyU32FromSlice:       "limited_copy_u32_from_slice",
 	IDLimitedCopyU32ToSlice:         "limited_copy_u32_to_slice",
+	IDCopyFromHistory64:             "copy_from_history64",
+	IDCopyFromReader64:              "copy_from_reader64",
+	IDCopyFromSlice64:               "copy_from_slice64",
 
 	// -------- 0x180 block.
 
@@ -1037,6 +1435,64 @@ func init() {
 			builtInsByName[name] = ID(i)
 		}
 	}
+	if err := checkBuiltIns(); err != nil {
+		panic(err)
+	}
+}
+
+// checkBuiltIns checks that the built-in tables are consistent: that no two
+// IDs share a name, that the lexedIDs' assignment forms are named after their
+// operators and that the squiggles and lexers tables produce IDs whose names
+// match the source text that they lex, longest first.
+func checkBuiltIns() error {
+	for i, name := range builtInsByID {
+		if name == "" {
+			continue
+		}
+		if id := builtInsByName[name]; id != ID(i) {
+			return fmt.Errorf("token: IDs 0x%X and 0x%X both have the name %q", id, i, name)
+		}
+	}
+
+	for c, id := range squiggles {
+		if id == 0 {
+			continue
+		}
+		if name := builtInsByID[id]; name != string(rune(c)) {
+			return fmt.Errorf("token: squiggle %q lexes as ID 0x%X, whose name is %q", c, id, name)
+		}
+	}
+
+	for _, x := range lexedIDs {
+		if builtInsByID[x.id] == "" {
+			return fmt.Errorf("token: lexed ID 0x%X has no name", x.id)
+		} else if x.assign == 0 {
+			continue
+		}
+		if name, want := builtInsByID[x.assign], builtInsByID[x.id]+"="; !x.assign.IsAssign() || (name != want) {
+			return fmt.Errorf("token: ID 0x%X, named %q, is not the assignment form %q", x.assign, name, want)
+		}
+	}
+
+	for c, xs := range lexers {
+		for i, x := range xs {
+			spelling := string(rune(c)) + x.suffix
+			if name := builtInsByID[x.id]; name != spelling {
+				return fmt.Errorf("token: %q lexes as ID 0x%X, whose name is %q", spelling, x.id, name)
+			}
+			if (i > 0) && (len(xs[i-1].suffix) < len(x.suffix)) {
+				return fmt.Errorf("token: %q is after the shorter %q",
+					spelling, string(rune(c))+xs[i-1].suffix)
+			}
+			for _, y := range xs[:i] {
+				if strings.HasPrefix(x.suffix, y.suffix) {
+					return fmt.Errorf("token: %q is shadowed by the earlier %q",
+						spelling, string(rune(c))+y.suffix)
+				}
+			}
+		}
+	}
+	return nil
 }
 
 // squiggles are built-in IDs that aren't alpha-numeric.
@@ -1051,6 +1507,7 @@ var squiggles = [256]ID{
 	'?': IDQuestion,
 	':': IDColon,
 	';': IDSemicolon,
+	'@': IDAt,
 }
 
 type suffixLexer struct {
@@ -1058,89 +1515,73 @@ type suffixLexer struct {
 	id     ID
 }
 
-// lexers lex ambiguous 1-byte squiggles. For example, "&" might be the start
-// of "&^" or "&=".
+// lexedIDs are the built-in IDs, each paired with its compound assignment
+// form (if any), that lexers lex. For example, "&" might be the start of "&^"
+// or "&=". Adding an operator here adds both it and its assignment form to
+// the lexers table, and checkBuiltIns checks that the pair's names agree.
+var lexedIDs = [...]struct {
+	id     ID
+	assign ID
+}{
+	{IDDot, 0},
+	{IDDotDot, 0},
+	{IDDotDotEq, 0},
+
+	{IDAmp, IDAmpEq},
+	{IDPipe, IDPipeEq},
+	{IDHat, IDHatEq},
+	{IDPlus, IDPlusEq},
+	{IDMinus, IDMinusEq},
+	{IDStar, IDStarEq},
+	{IDStarStar, IDStarStarEq},
+	{IDSlash, IDSlashEq},
+	{IDPercent, IDPercentEq},
+	{IDShiftL, IDShiftLEq},
+	{IDShiftR, IDShiftREq},
+
+	{IDTildeModPlus, IDTildeModPlusEq},
+	{IDTildeModMinus, IDTildeModMinusEq},
+	{IDTildeModStar, IDTildeModStarEq},
+	{IDTildeModShiftL, IDTildeModShiftLEq},
+	{IDTildeSatPlus, IDTildeSatPlusEq},
+	{IDTildeSatMinus, IDTildeSatMinusEq},
+
+	{IDEq, 0},
+	{IDEqEq, 0},
+	{IDEqQuestion, 0},
+	{IDNotEq, 0},
+	{IDLessThan, 0},
+	{IDLessEq, 0},
+	{IDGreaterThan, 0},
+	{IDGreaterEq, 0},
+
+	{IDOpenCurly, 0},
+	{IDOpenDoubleCurly, 0},
+	{IDCloseCurly, 0},
+	{IDCloseDoubleCurly, 0},
+}
+
+// lexers lex ambiguous 1-byte squiggles. They are derived from lexedIDs,
+// keyed by the first byte of each ID's name.
 //
 // The order of the []suffixLexer elements matters. The first match wins. Since
-// we want to lex greedily, longer suffixes should be earlier in the slice.
-var lexers = [256][]suffixLexer{
-	'.': {
-		{".=", IDDotDotEq},
-		{".", IDDotDot},
-		{"", IDDot},
-	},
-	'&': {
-		{"=", IDAmpEq},
-		{"", IDAmp},
-	},
-	'|': {
-		{"=", IDPipeEq},
-		{"", IDPipe},
-	},
-	'^': {
-		{"=", IDHatEq},
-		{"", IDHat},
-	},
-	'+': {
-		{"=", IDPlusEq},
-		{"", IDPlus},
-	},
-	'-': {
-		{"=", IDMinusEq},
-		{"", IDMinus},
-	},
-	'*': {
-		{"=", IDStarEq},
-		{"", IDStar},
-	},
-	'/': {
-		{"=", IDSlashEq},
-		{"", IDSlash},
-	},
-	'%': {
-		{"=", IDPercentEq},
-		{"", IDPercent},
-	},
-	'=': {
-		{"=", IDEqEq},
-		{"?", IDEqQuestion},
-		{"", IDEq},
-	},
-	'<': {
-		{"<=", IDShiftLEq},
-		{"<", IDShiftL},
-		{"=", IDLessEq},
-		{">", IDNotEq},
-		{"", IDLessThan},
-	},
-	'>': {
-		{">=", IDShiftREq},
-		{">", IDShiftR},
-		{"=", IDGreaterEq},
-		{"", IDGreaterThan},
-	},
-	'{': {
-		{"{", IDOpenDoubleCurly},
-		{"", IDOpenCurly},
-	},
-	'}': {
-		{"}", IDCloseDoubleCurly},
-		{"", IDCloseCurly},
-	},
-	'~': {
-		{"mod<<=", IDTildeModShiftLEq},
-		{"mod<<", IDTildeModShiftL},
-		{"mod+=", IDTildeModPlusEq},
-		{"mod+", IDTildeModPlus},
-		{"mod-=", IDTildeModMinusEq},
-		{"mod-", IDTildeModMinus},
-		{"mod*=", IDTildeModStarEq},
-		{"mod*", IDTildeModStar},
-		{"sat+=", IDTildeSatPlusEq},
-		{"sat+", IDTildeSatPlus},
-		{"sat-=", IDTildeSatMinusEq},
-		{"sat-", IDTildeSatMinus},
-	},
+// we want to lex greedily, longer suffixes are earlier in the slice.
+var lexers = makeLexers()
+
+func makeLexers() (ret [256][]suffixLexer) {
+	for _, x := range lexedIDs {
+		for _, id := range [2]ID{x.id, x.assign} {
+			if name := builtInsByID[id]; (id != 0) && (name != "") {
+				ret[name[0]] = append(ret[name[0]], suffixLexer{name[1:], id})
+			}
+		}
+	}
+	for _, xs := range ret {
+		sort.SliceStable(xs, func(i int, j int) bool {
+			return len(xs[i].suffix) > len(xs[j].suffix)
+		})
+	}
+	return ret
 }
 
 var ambiguousForms = [nBuiltInSymbolicIDs]ID{
@@ -1154,6 +1595,7 @@ var ambiguousForms = [nBuiltInSymbolicIDs]ID{
 	IDXBinaryPipe:           IDPipe,
 	IDXBinaryHat:            IDHat,
 	IDXBinaryPercent:        IDPercent,
+	IDXBinaryStarStar:       IDStarStar,
 	IDXBinaryTildeModPlus:   IDTildeModPlus,
 	IDXBinaryTildeModMinus:  IDTildeModMinus,
 	IDXBinaryTildeModStar:   IDTildeModStar,
@@ -1169,6 +1611,7 @@ var ambiguousForms = [nBuiltInSymbolicIDs]ID{
 	IDXBinaryAnd:            IDAnd,
 	IDXBinaryOr:             IDOr,
 	IDXBinaryAs:             IDAs,
+	IDXBinaryXor:            IDXor,
 
 	IDXAssociativePlus: IDPlus,
 	IDXAssociativeStar: IDStar,
@@ -1177,6 +1620,9 @@ var ambiguousForms = [nBuiltInSymbolicIDs]ID{
 	IDXAssociativeHat:  IDHat,
 	IDXAssociativeAnd:  IDAnd,
 	IDXAssociativeOr:   IDOr,
+	IDXAssociativeXor:  IDXor,
+
+	IDXTernary: IDQuestion,
 
 	IDXUnaryPlus:  IDPlus,
 	IDXUnaryMinus: IDMinus,
@@ -1187,6 +1633,81 @@ func init() {
 	addXForms(&unaryForms)
 	addXForms(&binaryForms)
 	addXForms(&associativeForms)
+	addXForms(&ternaryForms)
+	if err := validateFormTables(); err != nil {
+		panic(err)
+	}
+}
+
+// validateFormTables checks that the X-form tables are consistent with each
+// other: that every table maps to X-forms, that addXForms has added each
+// X-form's reflexive entry, that ambiguousForms is the left inverse of each
+// table, that every operator has an X-form and that every X-form is reachable
+// from its ambiguous form.
+func validateFormTables() error {
+	tables := [...]struct {
+		name  string
+		table *[nBuiltInSymbolicIDs]ID
+	}{
+		{"unary", &unaryForms},
+		{"binary", &binaryForms},
+		{"associative", &associativeForms},
+		{"ternary", &ternaryForms},
+	}
+
+	for _, t := range tables {
+		for x, y := range t.table {
+			if y == 0 {
+				continue
+			}
+			if (y < minXOp) || (maxXOp < y) {
+				return fmt.Errorf("token: %s form of 0x%X is 0x%X, not an X-form", t.name, x, y)
+			}
+			if t.table[y] != y {
+				return fmt.Errorf("token: %s form of X-form 0x%X is 0x%X, not itself", t.name, y, t.table[y])
+			}
+			a := ambiguousForms[y]
+			if a == 0 {
+				return fmt.Errorf("token: %s X-form 0x%X has no ambiguous form", t.name, y)
+			}
+			if t.table[a] != y {
+				return fmt.Errorf("token: %s form of 0x%X is 0x%X, want 0x%X", t.name, a, t.table[a], y)
+			}
+			if ID(x).IsAssign() || (ID(x) == y) {
+				continue
+			}
+			if a != ID(x) {
+				return fmt.Errorf("token: ambiguous form of 0x%X is 0x%X, want 0x%X", y, a, x)
+			}
+		}
+	}
+
+	for x := ID(minAmbiguousOp); x <= maxAmbiguousOp; x++ {
+		if builtInsByID[x] == "" {
+			continue
+		}
+		found := false
+		for _, t := range tables {
+			found = found || (t.table[x] != 0)
+		}
+		if !found {
+			return fmt.Errorf("token: operator 0x%X (%q) has no X-forms", x, builtInsByID[x])
+		}
+	}
+
+	for y, a := range ambiguousForms {
+		if a == 0 {
+			continue
+		}
+		found := false
+		for _, t := range tables {
+			found = found || (t.table[a] == ID(y))
+		}
+		if !found {
+			return fmt.Errorf("token: X-form 0x%X is not a form of its ambiguous form 0x%X", y, a)
+		}
+	}
+	return nil
 }
 
 // addXForms modifies table so that, if table[x] == y, then table[y] = y.
@@ -1220,6 +1741,7 @@ var binaryForms = [nBuiltInSymbolicIDs]ID{
 	IDPipeEq:           IDXBinaryPipe,
 	IDHatEq:            IDXBinaryHat,
 	IDPercentEq:        IDXBinaryPercent,
+	IDStarStarEq:       IDXBinaryStarStar,
 	IDTildeModPlusEq:   IDXBinaryTildeModPlus,
 	IDTildeModMinusEq:  IDXBinaryTildeModMinus,
 	IDTildeModStarEq:   IDXBinaryTildeModStar,
@@ -1237,6 +1759,7 @@ var binaryForms = [nBuiltInSymbolicIDs]ID{
 	IDPipe:           IDXBinaryPipe,
 	IDHat:            IDXBinaryHat,
 	IDPercent:        IDXBinaryPercent,
+	IDStarStar:       IDXBinaryStarStar,
 	IDTildeModPlus:   IDXBinaryTildeModPlus,
 	IDTildeModMinus:  IDXBinaryTildeModMinus,
 	IDTildeModStar:   IDXBinaryTildeModStar,
@@ -1253,6 +1776,7 @@ var binaryForms = [nBuiltInSymbolicIDs]ID{
 	IDAnd:         IDXBinaryAnd,
 	IDOr:          IDXBinaryOr,
 	IDAs:          IDXBinaryAs,
+	IDXor:         IDXBinaryXor,
 }
 
 var associativeForms = [nBuiltInSymbolicIDs]ID{
@@ -1264,6 +1788,11 @@ var associativeForms = [nBuiltInSymbolicIDs]ID{
 	// TODO: IDTildeModPlus, IDTildeSatPlus?
 	IDAnd: IDXAssociativeAnd,
 	IDOr:  IDXAssociativeOr,
+	IDXor: IDXAssociativeXor,
+}
+
+var ternaryForms = [nBuiltInSymbolicIDs]ID{
+	IDQuestion: IDXTernary,
 }
 
 var unaryForms = [nBuiltInSymbolicIDs]ID{
@@ -1292,4 +1821,49 @@ var isTightRight = [...]bool{
 
 	IDDot:    true,
 	IDExclam: true,
+	IDAt:     true,
+}
+
+var isBitwiseOp = [...]bool{
+	IDShiftL:         true,
+	IDShiftR:         true,
+	IDAmp:            true,
+	IDPipe:           true,
+	IDHat:            true,
+	IDTildeModShiftL: true,
+}
+
+var isArithmeticOp = [...]bool{
+	IDPlus:          true,
+	IDMinus:         true,
+	IDStar:          true,
+	IDSlash:         true,
+	IDPercent:       true,
+	IDStarStar:      true,
+	IDTildeModPlus:  true,
+	IDTildeModMinus: true,
+	IDTildeModStar:  true,
+	IDTildeSatPlus:  true,
+	IDTildeSatMinus: true,
+}
+
+var isControlFlowKeyword = [...]bool{
+	IDBreak:    true,
+	IDContinue: true,
+	IDElse:     true,
+	IDIf:       true,
+	IDIterate:  true,
+	IDReturn:   true,
+	IDWhile:    true,
+	IDYield:    true,
+}
+
+var isDeclarationKeyword = [...]bool{
+	IDConst:  true,
+	IDFunc:   true,
+	IDPri:    true,
+	IDPub:    true,
+	IDStruct: true,
+	IDUse:    true,
+	IDVar:    true,
 }
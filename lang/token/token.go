@@ -17,6 +17,7 @@ package token
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"unicode/utf8"
 )
 
@@ -26,6 +27,11 @@ const (
 	maxTokenSize = 1023
 )
 
+// ErrNegativeLiteral is returned by Map.NegatedLiteral when the negation is not
+// zero. Wuffs numeric literals are non-negative, so a negative value, such as
+// "-5", is represented structurally: IDXUnaryMinus applied to the literal "5".
+var ErrNegativeLiteral = errors.New("token: negative literals are represented structurally")
+
 var backslashes = [256]byte{
 	'"':  0x22 | 0x80,
 	'\'': 0x27 | 0x80,
@@ -54,6 +60,12 @@ func Unescape(s string) (unescaped string, ok bool) {
 		} else {
 			return "", false
 		}
+	case '`':
+		// A raw string literal has no escapes.
+		if s[len(s)-1] == '`' {
+			return s[1 : len(s)-1], true
+		}
+		return "", false
 	case '\'':
 		if s[len(s)-1] == '\'' {
 			s = s[1 : len(s)-1]
@@ -137,9 +149,48 @@ func Unescape(s string) (unescaped string, ok bool) {
 	return string(b), true
 }
 
+// Map maps between the names (spellings) and IDs of tokens that are not
+// built-in, such as identifiers and literals. The zero value is an empty Map,
+// ready to use, that grows as names are inserted.
 type Map struct {
 	byName map[string]ID
 	byID   []string
+
+	// numFresh is the number of spellings that Fresh has tried.
+	numFresh uint64
+
+	// internCache is a small cache of recent InternQID and InternQQID
+	// results. Its zero value is valid, as empty names map to zero IDs.
+	internCache [internCacheSize]internCacheEntry
+}
+
+// internCacheSize is the number of internCache entries. It is a power of 2.
+const internCacheSize = 64
+
+// internCacheEntry maps the names in key to the IDs in value. For InternQID,
+// key[2] is "" and value[2] is 0.
+type internCacheEntry struct {
+	key   [3]string
+	value QQID
+}
+
+// NewMap returns an empty Map. It is equivalent to &Map{}.
+func NewMap() *Map {
+	return &Map{}
+}
+
+// NewMapWithCapacity returns an empty Map with room for n names to be inserted
+// without re-allocating its backing storage. A good hint reduces allocations
+// when tokenizing large amounts of source code. Non-positive n is the same as
+// NewMap.
+func NewMapWithCapacity(n int) *Map {
+	if n <= 0 {
+		return &Map{}
+	}
+	return &Map{
+		byName: make(map[string]ID, n),
+		byID:   make([]string, 0, n),
+	}
 }
 
 func (m *Map) Insert(name string) (ID, error) {
@@ -186,6 +237,223 @@ func (m *Map) ByID(x ID) string {
 	return ""
 }
 
+// Len returns the number of identifiers interned in m, not counting the
+// built-in IDs.
+func (m *Map) Len() int {
+	return len(m.byID)
+}
+
+// ForEach calls fn for each identifier interned in m, in ID order. It does not
+// visit the built-in IDs.
+//
+// fn must not call m.Insert.
+func (m *Map) ForEach(fn func(id ID, name string)) {
+	for i, name := range m.byID {
+		fn(nBuiltInIDs+ID(i), name)
+	}
+}
+
+// DynamicIDsInInsertionOrder returns the IDs of the identifiers interned in
+// m, not counting the built-in IDs, in the order that they were first
+// inserted. IDs are assigned sequentially, so this is also ID order, and it
+// does not depend on Go's map iteration order.
+func (m *Map) DynamicIDsInInsertionOrder() []ID {
+	ret := make([]ID, len(m.byID))
+	for i := range ret {
+		ret[i] = nBuiltInIDs + ID(i)
+	}
+	return ret
+}
+
+// Fresh inserts and returns a new identifier, such as a compiler-generated
+// temporary variable, whose spelling was not already in m. The spelling is
+// "__" followed by prefix and a decimal number, such as "__tmp0". Wuffs
+// source code cannot declare names starting with "__" (unless the parser's
+// AllowDoubleUnderscoreNames option is set), so it will not clash with user
+// identifiers inserted later either.
+//
+// prefix must consist of letters, digits and underscores.
+func (m *Map) Fresh(prefix string) (ID, error) {
+	for {
+		b := make([]byte, 0, 2+len(prefix)+20)
+		b = append(b, "__"...)
+		b = append(b, prefix...)
+		b = strconv.AppendUint(b, m.numFresh, 10)
+		m.numFresh++
+		s := string(b)
+		if !IsValidIdentifier(s) {
+			return 0, fmt.Errorf("token: invalid Fresh prefix %q", prefix)
+		} else if m.ByName(s) == 0 {
+			return m.Insert(s)
+		}
+	}
+}
+
+// InternQID inserts pkg and name into m, as Insert does, and returns their IDs
+// as a QID. An empty pkg gives a zero QID[0], for a plain name.
+//
+// Repeatedly interning the same hot combinations, such as "base" and "u32",
+// is cheaper than repeated Insert calls, as recent results are cached.
+func (m *Map) InternQID(pkg string, name string) (QID, error) {
+	x, err := m.intern([3]string{pkg, name, ""})
+	return QID{x[0], x[1]}, err
+}
+
+// InternQQID is like InternQID but for three names, such as a method's
+// receiver package, receiver name and method name.
+func (m *Map) InternQQID(a string, b string, c string) (QQID, error) {
+	return m.intern([3]string{a, b, c})
+}
+
+func (m *Map) intern(key [3]string) (QQID, error) {
+	// The cache index is a cheap hash of the names' lengths and end bytes.
+	h := uint(0)
+	for _, s := range key {
+		h = (h * 31) + uint(len(s))
+		if s != "" {
+			h = (h * 31) + uint(s[0])
+			h = (h * 31) + uint(s[len(s)-1])
+		}
+	}
+
+	e := &m.internCache[h&(internCacheSize-1)]
+	if e.key == key {
+		return e.value, nil
+	}
+
+	value := QQID{}
+	for i, s := range key {
+		id, err := m.Insert(s)
+		if err != nil {
+			return QQID{}, err
+		}
+		value[i] = id
+	}
+	*e = internCacheEntry{key, value}
+	return value, nil
+}
+
+// NegatedLiteral returns the numeric literal whose value is the negation of
+// lit's value. As Wuffs numeric literals are non-negative, that is only
+// possible when lit's value is zero (spelled e.g. "0", "0x00" or "0_0"), in
+// which case it returns ID0. Otherwise, it returns ErrNegativeLiteral, and a
+// constant folder should keep the IDXUnaryMinus node.
+//
+// It returns a different error if lit is not a valid numeric literal.
+func (m *Map) NegatedLiteral(lit ID) (ID, error) {
+	value, _, err := lit.NumLiteralValue(m)
+	if err != nil {
+		return 0, err
+	} else if value != 0 {
+		return 0, ErrNegativeLiteral
+	}
+	return ID0, nil
+}
+
+// Equal returns whether m and other assign the same IDs to the same names. The
+// built-in IDs are always equal.
+func (m *Map) Equal(other *Map) bool {
+	if len(m.byID) != len(other.byID) {
+		return false
+	}
+	for i, name := range m.byID {
+		if other.byID[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidIdentifier returns whether s is lexically an identifier, such as
+// "foo" or "u32", as opposed to a keyword such as "if", a built-in literal such
+// as "true", or something that isn't a single token.
+func IsValidIdentifier(s string) bool {
+	if (len(s) == 0) || (len(s) > maxTokenSize) || !alpha(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !alphaNumeric(s[i]) {
+			return false
+		}
+	}
+	if id, ok := builtInsByName[s]; ok {
+		return id.IsIdent(nil)
+	}
+	return true
+}
+
+// IsValidNumLiteral returns whether s is lexically a numeric literal, such as
+// "42", "1_000" or "0xFF".
+func IsValidNumLiteral(s string) bool {
+	if (len(s) == 0) || (len(s) > maxTokenSize) || !numeric(s[0]) {
+		return false
+	}
+	i, isDigit := 1, numericUnderscore
+	if (s[0] == '0') && (len(s) > 1) {
+		if next := s[1]; (next == 'x') || (next == 'X') {
+			i, isDigit = 2, hexaNumericUnderscore
+		} else if numeric(next) {
+			// Legacy octal syntax.
+			return false
+		}
+	}
+	for ; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return checkNumericUnderscores([]byte(s))
+}
+
+// IsValidStrLiteral returns whether s, including its quotes, is lexically a
+// string literal, such as "foo", '\n', '\x01\x02'le or `a\b`.
+func IsValidStrLiteral(s string) bool {
+	if (len(s) < 2) || (len(s) > maxTokenSize) {
+		return false
+	}
+	quote := s[0]
+	if (quote != '"') && (quote != '\'') && (quote != '`') {
+		return false
+	}
+
+	j := 1
+	for ; ; j++ {
+		if j == len(s) {
+			return false
+		}
+		c := s[j]
+		if c == quote {
+			j++
+			break
+		} else if c == '\\' {
+			if quote == '"' {
+				return false
+			}
+		} else if c < ' ' {
+			return false
+		}
+	}
+
+	hasEndian := (quote == '\'') && (j == (len(s) - 2)) &&
+		((s[j] == 'b') || (s[j] == 'l')) &&
+		(s[j+1] == 'e')
+	if hasEndian {
+		j += 2
+	}
+	if j != len(s) {
+		return false
+	}
+
+	if quote == '\'' {
+		if unescaped, ok := Unescape(s); !ok {
+			return false
+		} else if (len(unescaped) > 1) && !hasEndian {
+			return false
+		}
+	}
+	return true
+}
+
 func unhex(c byte) int32 {
 	switch {
 	case 'A' <= c && c <= 'F':
@@ -247,30 +515,62 @@ func checkNumericUnderscores(a []byte) bool {
 	return !prevUnderscore
 }
 
+// TokenizeOptions are optional arguments to TokenizeWithOptions. The zero
+// value gives the same behavior as Tokenize.
+type TokenizeOptions struct {
+	// KeepComments is whether each "//" comment is also emitted as an
+	// IDComment token, so that a formatter or other rewriter can tell where
+	// the comments were. The comment's text, up to but excluding the end of
+	// its line, is not interned in the Map. Instead, the comments return
+	// value, which KeepComments does not affect, is a side table keyed by the
+	// token's Line: the text is comments[tok.Line]. Passing the tokens and
+	// that table to RoundTripEqual reproduces each comment byte-for-byte.
+	//
+	// Comment tokens are transparent to implicit semicolons: a comment at the
+	// end of a line does not stop a semicolon from being inserted, before the
+	// comment token, if the token before it would otherwise get one.
+	KeepComments bool
+}
+
 func Tokenize(m *Map, filename string, src []byte) (tokens []Token, comments []string, retErr error) {
-	line := uint32(1)
+	return TokenizeWithOptions(m, filename, src, TokenizeOptions{})
+}
+
+func TokenizeWithOptions(m *Map, filename string, src []byte, opts TokenizeOptions) (tokens []Token, comments []string, retErr error) {
+	line, lineStart := uint32(1), 0
 loop:
 	for i := 0; i < len(src); {
 		c := src[i]
+		col := uint32(i-lineStart) + 1
 
 		if c <= ' ' {
 			if c == '\n' {
-				if len(tokens) > 0 && tokens[len(tokens)-1].ID.IsImplicitSemicolon(m) {
-					tokens = append(tokens, Token{IDSemicolon, line})
+				if n := len(tokens); (n > 0) && tokens[n-1].ID.IsComment() {
+					// Insert any implicit semicolon before the trailing comment.
+					if (n > 1) && (tokens[n-2].Line == line) && tokens[n-2].ID.IsImplicitSemicolon(m) {
+						tokens = append(tokens, tokens[n-1])
+						tokens[n-1] = Token{IDSemicolon, line, 0}
+					}
+				} else if (n > 0) && tokens[n-1].ID.IsImplicitSemicolon(m) {
+					tokens = append(tokens, Token{IDSemicolon, line, 0})
 				}
 				if line == maxLine {
 					return nil, nil, fmt.Errorf("token: too many lines in %q", filename)
 				}
 				line++
+				lineStart = i + 1
 			}
 			i++
 			continue
 		}
 
-		if (c == '"') || (c == '\'') {
+		if (c == '"') || (c == '\'') || (c == '`') {
 			quote := c
 			j := i + 1
-			for j < len(src) {
+			for {
+				if j == len(src) {
+					return nil, nil, fmt.Errorf("token: expected final %c in string at %s:%d", quote, filename, line)
+				}
 				c = src[j]
 				j++
 				if c == quote {
@@ -309,7 +609,7 @@ loop:
 			if err != nil {
 				return nil, nil, err
 			}
-			tokens = append(tokens, Token{id, line})
+			tokens = append(tokens, Token{id, line, col})
 			i = j
 			continue
 		}
@@ -325,7 +625,7 @@ loop:
 			if err != nil {
 				return nil, nil, err
 			}
-			tokens = append(tokens, Token{id, line})
+			tokens = append(tokens, Token{id, line, col})
 			i = j
 			continue
 		}
@@ -352,7 +652,7 @@ loop:
 			if err != nil {
 				return nil, nil, err
 			}
-			tokens = append(tokens, Token{id, line})
+			tokens = append(tokens, Token{id, line, col})
 			i = j
 			continue
 		}
@@ -366,18 +666,25 @@ loop:
 				comments = append(comments, "")
 			}
 			comments = append(comments, string(src[h:i]))
+			if opts.KeepComments {
+				tokens = append(tokens, Token{IDComment, line, col})
+			}
 			continue
 		}
 
 		if id := squiggles[c]; id != 0 {
 			i++
-			tokens = append(tokens, Token{id, line})
+			tokens = append(tokens, Token{id, line, col})
 			continue
 		}
 		for _, x := range lexers[c] {
 			if hasPrefix(src[i+1:], x.suffix) {
 				i += len(x.suffix) + 1
-				tokens = append(tokens, Token{x.id, line})
+				// Reject "...", instead of lexing it as ".." and then ".".
+				if (x.id == IDDotDot) && (i < len(src)) && (src[i] == '.') {
+					return nil, nil, fmt.Errorf("token: invalid \"...\" at %s:%d", filename, line)
+				}
+				tokens = append(tokens, Token{x.id, line, col})
 				continue loop
 			}
 		}
@@ -392,3 +699,107 @@ loop:
 	}
 	return tokens, comments, nil
 }
+
+// RoundTripEqual renders tokens as source text and re-tokenizes that text,
+// reporting whether the two ID sequences are equal. When they are not, diff
+// describes the first mismatch. It is meant for testing code that
+// synthesizes token streams.
+//
+// The rendering puts a space between two tokens on the same line unless the
+// first is tight-right or the second is tight-left. It starts a new line
+// whenever a token's Line increases and in place of each implicit semicolon
+// (a semicolon whose Column is 0), so that tokenizing re-inserts it. An
+// IDComment token, whose text is not in the Map, is rendered as
+// comments[tok.Line], the side table returned by TokenizeWithOptions, and
+// ends its line. A comment's text must match, not just its ID.
+func RoundTripEqual(m *Map, tokens []Token, comments []string) (equal bool, diff string) {
+	src := []byte(nil)
+	opts := TokenizeOptions{}
+	prev, atLineStart := Token{}, true
+	for i, tok := range tokens {
+		if (tok.ID == IDSemicolon) && (tok.Column == 0) {
+			if !atLineStart {
+				src = append(src, '\n')
+				atLineStart = true
+			}
+			prev = tok
+			continue
+		}
+		if (i > 0) && (tok.Line > prev.Line) && !atLineStart {
+			src = append(src, '\n')
+			atLineStart = true
+		}
+		if !atLineStart && !prev.ID.IsTightRight() && !tok.ID.IsTightLeft() {
+			src = append(src, ' ')
+		}
+		if tok.ID.IsComment() {
+			if uint32(len(comments)) <= tok.Line {
+				return false, fmt.Sprintf("token #%d: no comment text for line %d", i, tok.Line)
+			}
+			opts.KeepComments = true
+			src = append(src, comments[tok.Line]...)
+			src = append(src, '\n')
+			prev, atLineStart = tok, true
+			continue
+		}
+		src = append(src, m.ByID(tok.ID)...)
+		prev, atLineStart = tok, false
+	}
+	if !atLineStart {
+		src = append(src, '\n')
+	}
+
+	got, gotComments, err := TokenizeWithOptions(m, "", src, opts)
+	if err != nil {
+		return false, fmt.Sprintf("re-tokenizing %q: %v", src, err)
+	}
+	for i := 0; (i < len(tokens)) || (i < len(got)); i++ {
+		have, want := "<none>", "<none>"
+		if i < len(got) {
+			have = fmt.Sprintf("%q", m.ByID(got[i].ID))
+			if got[i].ID.IsComment() {
+				have = fmt.Sprintf("%q", gotComments[got[i].Line])
+			}
+		}
+		if i < len(tokens) {
+			want = fmt.Sprintf("%q", m.ByID(tokens[i].ID))
+			if tokens[i].ID.IsComment() {
+				want = fmt.Sprintf("%q", comments[tokens[i].Line])
+			}
+		}
+		if have != want {
+			return false, fmt.Sprintf("token #%d: have %s, want %s, rendered as %q", i, have, want, src)
+		}
+	}
+	return true, ""
+}
+
+// CheckBalanced returns an error, naming the line of the first mismatch,
+// unless every opening "(", "[", "{" or "{{" in tokens is matched, in order,
+// by a closing token of the same kind. The mismatch can be a close without an
+// open, a close of the wrong kind or an open left unclosed at the end.
+func CheckBalanced(tokens []Token) error {
+	opens := []Token(nil)
+	for _, tok := range tokens {
+		if tok.ID.IsOpen() {
+			opens = append(opens, tok)
+			continue
+		} else if !tok.ID.IsClose() {
+			continue
+		}
+		if len(opens) == 0 {
+			return fmt.Errorf("token: unexpected %q at line %d", tok.ID.BuiltInName(), tok.Line)
+		}
+		open := opens[len(opens)-1]
+		opens = opens[:len(opens)-1]
+		if tok.ID-open.ID != minClose-minOpen {
+			return fmt.Errorf("token: %q at line %d does not match %q at line %d",
+				tok.ID.BuiltInName(), tok.Line, open.ID.BuiltInName(), open.Line)
+		}
+	}
+	if len(opens) > 0 {
+		open := opens[len(opens)-1]
+		return fmt.Errorf("token: unclosed %q at line %d", open.ID.BuiltInName(), open.Line)
+	}
+	return nil
+}
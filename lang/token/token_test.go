@@ -0,0 +1,1639 @@
+// Copyright 2020 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMapForEach(tt *testing.T) {
+	m := &Map{}
+	if got := m.Len(); got != 0 {
+		tt.Fatalf("Len: got %d, want 0", got)
+	}
+
+	names := []string{"foo", "bar", "foo", "u32", "baz", "if", "bar"}
+	want := map[ID]string{}
+	for _, name := range names {
+		id, err := m.Insert(name)
+		if err != nil {
+			tt.Fatalf("Insert(%q): %v", name, err)
+		}
+		if !id.IsBuiltIn() {
+			want[id] = name
+		}
+	}
+	if got, want := m.Len(), 3; got != want {
+		tt.Fatalf("Len: got %d, want %d", got, want)
+	}
+
+	prevID := ID(0)
+	m.ForEach(func(id ID, name string) {
+		if id <= prevID {
+			tt.Errorf("ForEach: ID %d visited after ID %d", id, prevID)
+		}
+		prevID = id
+		if w, ok := want[id]; !ok {
+			tt.Errorf("ForEach: unexpected ID %d (%q)", id, name)
+		} else if w != name {
+			tt.Errorf("ForEach: ID %d: got %q, want %q", id, name, w)
+		}
+		delete(want, id)
+	})
+	for id, name := range want {
+		tt.Errorf("ForEach: ID %d (%q) not visited", id, name)
+	}
+}
+
+func TestMapDynamicIDsInInsertionOrder(tt *testing.T) {
+	m := &Map{}
+	if got := m.DynamicIDsInInsertionOrder(); len(got) != 0 {
+		tt.Fatalf("empty Map: got %v, want none", got)
+	}
+
+	// Built-in names and repeated names do not add IDs.
+	names := []string{"zebra", "apple", "func", "mango", "apple", "u8", "banana", "zebra"}
+	want := []string{"zebra", "apple", "mango", "banana"}
+	for _, name := range names {
+		if _, err := m.Insert(name); err != nil {
+			tt.Fatalf("Insert(%q): %v", name, err)
+		}
+	}
+	got := []string(nil)
+	for _, id := range m.DynamicIDsInInsertionOrder() {
+		got = append(got, m.ByID(id))
+	}
+	if g, w := strings.Join(got, " "), strings.Join(want, " "); g != w {
+		tt.Fatalf("got %q, want %q", g, w)
+	}
+}
+
+func TestMapEqual(tt *testing.T) {
+	newMap := func(names ...string) *Map {
+		m := &Map{}
+		for _, name := range names {
+			if _, err := m.Insert(name); err != nil {
+				tt.Fatalf("Insert(%q): %v", name, err)
+			}
+		}
+		return m
+	}
+
+	testCases := []struct {
+		a, b *Map
+		want bool
+	}{
+		{newMap(), newMap(), true},
+		{newMap("x", "y"), newMap("x", "y"), true},
+		{newMap("x", "if", "y"), newMap("x", "y", "u8"), true},
+		{newMap("x", "y"), newMap("y", "x"), false},
+		{newMap("x", "y"), newMap("x", "y", "z"), false},
+		{newMap("x", "y", "z"), newMap("x", "y"), false},
+	}
+	for i, tc := range testCases {
+		if got := tc.a.Equal(tc.b); got != tc.want {
+			tt.Errorf("i=%d: a.Equal(b): got %t, want %t", i, got, tc.want)
+		}
+		if got := tc.b.Equal(tc.a); got != tc.want {
+			tt.Errorf("i=%d: b.Equal(a): got %t, want %t", i, got, tc.want)
+		}
+	}
+}
+
+func TestBuiltInNameRoundTrip(tt *testing.T) {
+	ids := AllBuiltInIDs()
+	if len(ids) == 0 {
+		tt.Fatalf("AllBuiltInIDs: got no IDs")
+	}
+	for i, id := range ids {
+		if (i > 0) && (ids[i-1] >= id) {
+			tt.Fatalf("AllBuiltInIDs: not in increasing order: 0x%X then 0x%X", ids[i-1], id)
+		}
+		name := id.BuiltInName()
+		if name == "" {
+			tt.Errorf("ID 0x%X: empty BuiltInName", id)
+			continue
+		}
+		if got, ok := builtInsByName[name]; !ok {
+			tt.Errorf("ID 0x%X: name %q is not in builtInsByName", id, name)
+		} else if got != id {
+			tt.Errorf("ID 0x%X: name %q maps back to 0x%X", id, name, got)
+		}
+	}
+
+	if got := nBuiltInIDs.BuiltInName(); got != "" {
+		tt.Errorf("nBuiltInIDs.BuiltInName: got %q, want \"\"", got)
+	}
+}
+
+func TestCheckBuiltIns(tt *testing.T) {
+	if err := checkBuiltIns(); err != nil {
+		tt.Fatalf("checkBuiltIns: %v", err)
+	}
+
+	// Temporarily introduce a copy-paste bug, mislabeling "~mod+".
+	xs := lexers['~']
+	for i, x := range xs {
+		if x.id != IDTildeModPlus {
+			continue
+		}
+		xs[i].id = IDPercent
+		err := checkBuiltIns()
+		xs[i].id = IDTildeModPlus
+		if err == nil {
+			tt.Fatalf("checkBuiltIns: got nil error, want non-nil")
+		}
+		return
+	}
+	tt.Fatalf("could not find IDTildeModPlus in lexers")
+}
+
+func TestLexersLongestFirst(tt *testing.T) {
+	numLexed := 0
+	for c, xs := range lexers {
+		numLexed += len(xs)
+		for i := 1; i < len(xs); i++ {
+			if len(xs[i-1].suffix) < len(xs[i].suffix) {
+				tt.Errorf("%q: %q is after the shorter %q",
+					rune(c), string(rune(c))+xs[i].suffix, string(rune(c))+xs[i-1].suffix)
+			}
+		}
+	}
+
+	// Every lexed ID, and its assignment form, has exactly one lexers entry.
+	want := 0
+	for _, x := range lexedIDs {
+		want++
+		if x.assign != 0 {
+			want++
+		}
+	}
+	if numLexed != want {
+		tt.Fatalf("number of lexers entries: got %d, want %d", numLexed, want)
+	}
+
+	// A mis-named assignment form is rejected.
+	for i, x := range lexedIDs {
+		if x.id != IDPlus {
+			continue
+		}
+		lexedIDs[i].assign = IDMinusEq
+		err := checkBuiltIns()
+		lexedIDs[i].assign = IDPlusEq
+		if err == nil {
+			tt.Fatalf("checkBuiltIns: got nil error, want non-nil")
+		}
+		return
+	}
+	tt.Fatalf("could not find IDPlus in lexedIDs")
+}
+
+func TestTokenizeStars(tt *testing.T) {
+	testCases := []struct {
+		src  string
+		want []ID
+	}{
+		{"*", []ID{IDStar}},
+		{"*=", []ID{IDStarEq}},
+		{"**", []ID{IDStarStar}},
+		{"**=", []ID{IDStarStarEq}},
+		{"***", []ID{IDStarStar, IDStar}},
+		{"***=", []ID{IDStarStar, IDStarEq}},
+		{"****=", []ID{IDStarStar, IDStarStarEq}},
+		{"** =", []ID{IDStarStar, IDEq}},
+		{"* *=", []ID{IDStar, IDStarEq}},
+	}
+
+	m := &Map{}
+	for _, tc := range testCases {
+		tokens, _, err := Tokenize(m, "test.wuffs", []byte(tc.src))
+		if err != nil {
+			tt.Errorf("%q: Tokenize: %v", tc.src, err)
+			continue
+		}
+		got := []ID(nil)
+		for _, tok := range tokens {
+			got = append(got, tok.ID)
+		}
+		if !equalIDs(got, tc.want) {
+			tt.Errorf("%q: got %v, want %v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func equalIDs(xs []ID, ys []ID) bool {
+	if len(xs) != len(ys) {
+		return false
+	}
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTokenizeXor(tt *testing.T) {
+	m := &Map{}
+	tokens, _, err := Tokenize(m, "test.wuffs", []byte("a xor b xor_c"))
+	if err != nil {
+		tt.Fatalf("Tokenize: %v", err)
+	}
+	if len(tokens) != 4 {
+		tt.Fatalf("len(tokens): got %d, want 4", len(tokens))
+	}
+	if got := tokens[1].ID; got != IDXor {
+		tt.Fatalf("tokens[1]: got 0x%X, want IDXor (0x%X)", got, IDXor)
+	}
+	if got := tokens[3].ID; !got.IsIdent(m) {
+		tt.Fatalf("tokens[3]: got 0x%X, want an identifier", got)
+	}
+	if got := m.Len(); got != 3 {
+		tt.Fatalf("Len: got %d, want 3", got)
+	}
+}
+
+func TestXorForms(tt *testing.T) {
+	testCases := []struct {
+		id              ID
+		binary, assoc   ID
+		wantBinaryOp    bool
+		wantAssociative bool
+	}{
+		{IDAnd, IDXBinaryAnd, IDXAssociativeAnd, true, true},
+		{IDOr, IDXBinaryOr, IDXAssociativeOr, true, true},
+		{IDXor, IDXBinaryXor, IDXAssociativeXor, true, true},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.id.IsBinaryOp(); got != tc.wantBinaryOp {
+			tt.Errorf("0x%X: IsBinaryOp: got %t, want %t", tc.id, got, tc.wantBinaryOp)
+		}
+		if got := tc.id.IsAssociativeOp(); got != tc.wantAssociative {
+			tt.Errorf("0x%X: IsAssociativeOp: got %t, want %t", tc.id, got, tc.wantAssociative)
+		}
+		if got := tc.id.IsUnaryOp(); got {
+			tt.Errorf("0x%X: IsUnaryOp: got true, want false", tc.id)
+		}
+		if got := tc.id.BinaryForm(); got != tc.binary {
+			tt.Errorf("0x%X: BinaryForm: got 0x%X, want 0x%X", tc.id, got, tc.binary)
+		}
+		if got := tc.id.AssociativeForm(); got != tc.assoc {
+			tt.Errorf("0x%X: AssociativeForm: got 0x%X, want 0x%X", tc.id, got, tc.assoc)
+		}
+		if got := tc.binary.AmbiguousForm(); got != tc.id {
+			tt.Errorf("0x%X: AmbiguousForm: got 0x%X, want 0x%X", tc.binary, got, tc.id)
+		}
+		if got := tc.assoc.AmbiguousForm(); got != tc.id {
+			tt.Errorf("0x%X: AmbiguousForm: got 0x%X, want 0x%X", tc.assoc, got, tc.id)
+		}
+		if !tc.binary.IsXBinaryOp() || !tc.assoc.IsXAssociativeOp() {
+			tt.Errorf("0x%X: x-forms are not x-ops", tc.id)
+		}
+	}
+}
+
+func TestIsValidSpelling(tt *testing.T) {
+	testCases := []struct {
+		s                           string
+		wantIdent, wantNum, wantStr bool
+	}{
+		{"", false, false, false},
+		{"foo", true, false, false},
+		{"_foo", true, false, false},
+		{"_", true, false, false},
+		{"foo_bar9", true, false, false},
+		{"9foo", false, false, false},
+		{"foo bar", false, false, false},
+		{"foo.bar", false, false, false},
+		{"u32", true, false, false},
+		{"if", false, false, false},
+		{"func", false, false, false},
+		{"true", false, false, false},
+		{"nullptr", false, false, false},
+		{"and", false, false, false},
+
+		{"0", false, true, false},
+		{"42", false, true, false},
+		{"1_000", false, true, false},
+		{"1__000", false, false, false},
+		{"1_", false, false, false},
+		{"0x_FF", false, true, false},
+		{"0xFFg", false, false, false},
+		{"007", false, false, false},
+		{"4a", false, false, false},
+
+		{`""`, false, false, true},
+		{`"foo"`, false, false, true},
+		{`"foo`, false, false, false},
+		{`"fo"o"`, false, false, false},
+		{`"fo\o"`, false, false, false},
+		{`'a'`, false, false, true},
+		{`'\n'`, false, false, true},
+		{`'\q'`, false, false, false},
+		{`'ab'`, false, false, false},
+		{`'ab'le`, false, false, true},
+		{`'\x01\x02'be`, false, false, true},
+		{`'ab'xe`, false, false, false},
+		{"\"a\tb\"", false, false, false},
+	}
+
+	m := &Map{}
+	for _, tc := range testCases {
+		if got := IsValidIdentifier(tc.s); got != tc.wantIdent {
+			tt.Errorf("IsValidIdentifier(%q): got %t, want %t", tc.s, got, tc.wantIdent)
+		}
+		if got := IsValidNumLiteral(tc.s); got != tc.wantNum {
+			tt.Errorf("IsValidNumLiteral(%q): got %t, want %t", tc.s, got, tc.wantNum)
+		}
+		if got := IsValidStrLiteral(tc.s); got != tc.wantStr {
+			tt.Errorf("IsValidStrLiteral(%q): got %t, want %t", tc.s, got, tc.wantStr)
+		}
+
+		// Valid spellings should tokenize as that single token.
+		if !tc.wantIdent && !tc.wantNum && !tc.wantStr {
+			continue
+		}
+		tokens, _, err := Tokenize(m, "test.wuffs", []byte(tc.s+" "))
+		if err != nil {
+			tt.Errorf("Tokenize(%q): %v", tc.s, err)
+		} else if len(tokens) != 1 {
+			tt.Errorf("Tokenize(%q): got %d tokens, want 1", tc.s, len(tokens))
+		} else if got := m.ByID(tokens[0].ID); got != tc.s {
+			tt.Errorf("Tokenize(%q): got %q", tc.s, got)
+		}
+	}
+}
+
+func testBuiltInIdents(tt *testing.T, ids []ID, names []string) {
+	if len(ids) != len(names) {
+		tt.Fatalf("len(ids) != len(names)")
+	}
+	for i, id := range ids {
+		if !id.IsIdent(nil) {
+			tt.Errorf("%q: IsIdent: got false, want true", names[i])
+		}
+		if got := id.BuiltInName(); got != names[i] {
+			tt.Errorf("0x%X: BuiltInName: got %q, want %q", id, got, names[i])
+		}
+		if got := builtInsByName[names[i]]; got != id {
+			tt.Errorf("%q: builtInsByName: got 0x%X, want 0x%X", names[i], got, id)
+		}
+	}
+}
+
+func TestCopyFrom64IDs(tt *testing.T) {
+	testBuiltInIdents(tt, []ID{
+		IDCopyFromHistory64,
+		IDCopyFromReader64,
+		IDCopyFromSlice64,
+	}, []string{
+		"copy_from_history64",
+		"copy_from_reader64",
+		"copy_from_slice64",
+	})
+}
+
+func TestPeekIDs(tt *testing.T) {
+	testBuiltInIdents(tt, []ID{
+		IDPeekU8,
+		IDPeekU16BE,
+		IDPeekU16LE,
+		IDPeekU32BE,
+		IDPeekU32LE,
+		IDPeekU64BE,
+		IDPeekU64LE,
+	}, []string{
+		"peek_u8",
+		"peek_u16be",
+		"peek_u16le",
+		"peek_u32be",
+		"peek_u32le",
+		"peek_u64be",
+		"peek_u64le",
+	})
+}
+
+func TestNumTypeMinMax(tt *testing.T) {
+	testCases := []struct {
+		id      ID
+		wantMin int64
+		wantMax uint64
+	}{
+		{IDI8, -1 << 7, 1<<7 - 1},
+		{IDI16, -1 << 15, 1<<15 - 1},
+		{IDI32, -1 << 31, 1<<31 - 1},
+		{IDI64, -1 << 63, 1<<63 - 1},
+		{IDU8, 0, 1<<8 - 1},
+		{IDU16, 0, 1<<16 - 1},
+		{IDU32, 0, 1<<32 - 1},
+		{IDU64, 0, 1<<64 - 1},
+	}
+	for _, tc := range testCases {
+		if gotMin, ok := tc.id.NumTypeMin(); !ok || gotMin != tc.wantMin {
+			tt.Errorf("%s: NumTypeMin: got (%d, %t), want (%d, true)", tc.id.Str(nil), gotMin, ok, tc.wantMin)
+		}
+		if gotMax, ok := tc.id.NumTypeMax(); !ok || gotMax != tc.wantMax {
+			tt.Errorf("%s: NumTypeMax: got (%d, %t), want (%d, true)", tc.id.Str(nil), gotMax, ok, tc.wantMax)
+		}
+	}
+
+	for _, id := range []ID{IDQIdeal, IDBool, IDBase, 0} {
+		if _, ok := id.NumTypeMin(); ok {
+			tt.Errorf("%#x: NumTypeMin: got ok, want !ok", id)
+		}
+		if _, ok := id.NumTypeMax(); ok {
+			tt.Errorf("%#x: NumTypeMax: got ok, want !ok", id)
+		}
+	}
+}
+
+func TestOpFamilies(tt *testing.T) {
+	bitwise := map[ID]bool{
+		IDShiftL:         true,
+		IDShiftR:         true,
+		IDAmp:            true,
+		IDPipe:           true,
+		IDHat:            true,
+		IDTildeModShiftL: true,
+
+		IDXBinaryShiftL:         true,
+		IDXBinaryShiftR:         true,
+		IDXBinaryAmp:            true,
+		IDXBinaryPipe:           true,
+		IDXBinaryHat:            true,
+		IDXBinaryTildeModShiftL: true,
+		IDXAssociativeAmp:       true,
+		IDXAssociativePipe:      true,
+		IDXAssociativeHat:       true,
+	}
+	arithmetic := map[ID]bool{
+		IDPlus:          true,
+		IDMinus:         true,
+		IDStar:          true,
+		IDSlash:         true,
+		IDPercent:       true,
+		IDStarStar:      true,
+		IDTildeModPlus:  true,
+		IDTildeModMinus: true,
+		IDTildeModStar:  true,
+		IDTildeSatPlus:  true,
+		IDTildeSatMinus: true,
+
+		IDXBinaryPlus:          true,
+		IDXBinaryMinus:         true,
+		IDXBinaryStar:          true,
+		IDXBinarySlash:         true,
+		IDXBinaryPercent:       true,
+		IDXBinaryStarStar:      true,
+		IDXBinaryTildeModPlus:  true,
+		IDXBinaryTildeModMinus: true,
+		IDXBinaryTildeModStar:  true,
+		IDXBinaryTildeSatPlus:  true,
+		IDXBinaryTildeSatMinus: true,
+		IDXAssociativePlus:     true,
+		IDXAssociativeStar:     true,
+		IDXUnaryPlus:           true,
+		IDXUnaryMinus:          true,
+	}
+
+	for x := ID(0); x < nBuiltInIDs; x++ {
+		if got, want := x.IsBitwiseOp(), bitwise[x]; got != want {
+			tt.Errorf("%#x (%q): IsBitwiseOp: got %t, want %t", x, builtInsByID[x], got, want)
+		}
+		if got, want := x.IsArithmeticOp(), arithmetic[x]; got != want {
+			tt.Errorf("%#x (%q): IsArithmeticOp: got %t, want %t", x, builtInsByID[x], got, want)
+		}
+	}
+}
+
+func TestRenderForm(tt *testing.T) {
+	testCases := []struct {
+		id         ID
+		wantSymbol string
+		wantArity  int
+	}{
+		{IDXUnaryMinus, "-", 1},
+		{IDXBinaryMinus, "-", 2},
+		{IDXAssociativePlus, "+", 2},
+		{IDXUnaryNot, "not", 1},
+		{IDXBinaryAs, "as", 2},
+		{IDXTernary, "?", 3},
+		{IDMinus, "", 0},
+		{IDPlusEq, "", 0},
+		{IDIf, "", 0},
+	}
+	for _, tc := range testCases {
+		symbol, arity := tc.id.RenderForm()
+		if (symbol != tc.wantSymbol) || (arity != tc.wantArity) {
+			tt.Errorf("%#x: got (%q, %d), want (%q, %d)",
+				tc.id, symbol, arity, tc.wantSymbol, tc.wantArity)
+		}
+	}
+
+	for x := ID(minXOp); x <= maxXOp; x++ {
+		if x.AmbiguousForm() == 0 {
+			continue
+		}
+		symbol, arity := x.RenderForm()
+		if (symbol == "") || (arity == 0) {
+			tt.Errorf("%#x: got (%q, %d), want a non-zero result", x, symbol, arity)
+			continue
+		}
+		if id := builtInsByName[symbol]; id != x.AmbiguousForm() {
+			tt.Errorf("%#x: symbol %q has ID %#x, want %#x", x, symbol, id, x.AmbiguousForm())
+		}
+	}
+}
+
+func TestDisambiguateForm(tt *testing.T) {
+	testCases := []struct {
+		id     ID
+		prefix bool
+		want   ID
+	}{
+		{IDPlus, true, IDXUnaryPlus},
+		{IDPlus, false, IDXBinaryPlus},
+		{IDMinus, true, IDXUnaryMinus},
+		{IDMinus, false, IDXBinaryMinus},
+		{IDSlash, true, 0},
+		{IDSlash, false, IDXBinarySlash},
+		{IDNot, true, IDXUnaryNot},
+		{IDNot, false, IDXUnaryNot},
+		{IDXor, false, IDXBinaryXor},
+		{IDIf, true, 0},
+		{IDIf, false, 0},
+	}
+	for _, tc := range testCases {
+		if got := tc.id.DisambiguateForm(tc.prefix); got != tc.want {
+			tt.Errorf("%q, prefix=%t: got %#x, want %#x", builtInsByID[tc.id], tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestTokenizeDots(tt *testing.T) {
+	testCases := []struct {
+		src  string
+		want []ID
+	}{
+		{"a.b", []ID{0, IDDot, 0}},
+		{"a .. b", []ID{0, IDDotDot, 0}},
+		{"a ..= b", []ID{0, IDDotDotEq, 0}},
+	}
+	for _, tc := range testCases {
+		m := &Map{}
+		tokens, _, err := Tokenize(m, "test.wuffs", []byte(tc.src))
+		if err != nil {
+			tt.Fatalf("%q: Tokenize: %v", tc.src, err)
+		}
+		if len(tokens) != len(tc.want) {
+			tt.Fatalf("%q: len(tokens): got %d, want %d", tc.src, len(tokens), len(tc.want))
+		}
+		for i, want := range tc.want {
+			if want != 0 && tokens[i].ID != want {
+				tt.Fatalf("%q: tokens[%d]: got 0x%X, want 0x%X", tc.src, i, tokens[i].ID, want)
+			}
+		}
+	}
+
+	for _, src := range []string{"a ... b", "\na[...]", "x ...= y"} {
+		_, _, err := Tokenize(&Map{}, "test.wuffs", []byte(src))
+		if err == nil {
+			tt.Fatalf("%q: Tokenize: got nil error, want non-nil", src)
+		}
+		line := strings.Count(src, "\n") + 1
+		if want := fmt.Sprintf("at test.wuffs:%d", line); !strings.HasSuffix(err.Error(), want) {
+			tt.Fatalf("%q: got %q, want a suffix of %q", src, err, want)
+		}
+	}
+
+	for _, x := range []ID{IDDotDot, IDDotDotEq} {
+		if !x.IsRangeOp() {
+			tt.Errorf("%#x: IsRangeOp: got false, want true", x)
+		}
+	}
+	for _, x := range []ID{IDDot, IDColon, IDPlus} {
+		if x.IsRangeOp() {
+			tt.Errorf("%#x: IsRangeOp: got true, want false", x)
+		}
+	}
+}
+
+func TestValidateFormTables(tt *testing.T) {
+	if err := validateFormTables(); err != nil {
+		tt.Fatalf("validateFormTables: %v", err)
+	}
+
+	// Temporarily introduce bugs: a missing reflexive entry, an X-form that
+	// maps back to the wrong ambiguous form and a missing table entry.
+	bugs := []struct {
+		table *[nBuiltInSymbolicIDs]ID
+		x, y  ID
+	}{
+		{&binaryForms, IDXBinaryHat, 0},
+		{&binaryForms, IDHat, IDXBinaryPipe},
+		{&associativeForms, IDXor, 0},
+	}
+	for _, bug := range bugs {
+		old := bug.table[bug.x]
+		bug.table[bug.x] = bug.y
+		err := validateFormTables()
+		bug.table[bug.x] = old
+		if err == nil {
+			tt.Errorf("x=0x%X, y=0x%X: got nil error, want non-nil", bug.x, bug.y)
+		}
+	}
+}
+
+func TestTypeModifierPredicates(tt *testing.T) {
+	testCases := []struct {
+		id            ID
+		wantPointer   bool
+		wantContainer bool
+		wantNilable   bool
+	}{
+		{IDArray, false, true, false},
+		{IDNptr, true, false, true},
+		{IDPtr, true, false, false},
+		{IDSlice, false, true, false},
+
+		{IDU8, false, false, false},
+		{IDBool, false, false, false},
+		{IDStar, false, false, false},
+		{IDInvalid, false, false, false},
+	}
+	for _, tc := range testCases {
+		if got := tc.id.IsPointerType(); got != tc.wantPointer {
+			tt.Errorf("%#x: IsPointerType: got %t, want %t", tc.id, got, tc.wantPointer)
+		}
+		if got := tc.id.IsContainerType(); got != tc.wantContainer {
+			tt.Errorf("%#x: IsContainerType: got %t, want %t", tc.id, got, tc.wantContainer)
+		}
+		if got := tc.id.IsNilableType(); got != tc.wantNilable {
+			tt.Errorf("%#x: IsNilableType: got %t, want %t", tc.id, got, tc.wantNilable)
+		}
+	}
+}
+
+func TestSortIDsByName(tt *testing.T) {
+	m := &Map{}
+	zebra, err := m.Insert("zebra")
+	if err != nil {
+		tt.Fatalf("Insert: %v", err)
+	}
+	apple, err := m.Insert("apple")
+	if err != nil {
+		tt.Fatalf("Insert: %v", err)
+	}
+
+	ids := []ID{zebra, IDU8, apple, IDBool, IDIf}
+	SortIDsByName(m, ids)
+	got := []string(nil)
+	for _, id := range ids {
+		got = append(got, id.Str(m))
+	}
+	if want := []string{"apple", "bool", "if", "u8", "zebra"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		tt.Fatalf("got %q, want %q", got, want)
+	}
+
+	if !apple.LessByName(m, zebra) || zebra.LessByName(m, apple) || apple.LessByName(m, apple) {
+		tt.Fatalf("LessByName: inconsistent results for %q and %q", "apple", "zebra")
+	}
+}
+
+// TestBuiltInsTablesAreInverses checks that builtInsByID and builtInsByName
+// agree in both directions, so that re-ordering (or re-numbering) the built-in
+// IDs cannot silently break reverse lookups.
+func TestBuiltInsTablesAreInverses(tt *testing.T) {
+	numNamed := 0
+	for i, name := range builtInsByID {
+		if name == "" {
+			continue
+		}
+		numNamed++
+		if id, ok := builtInsByName[name]; !ok || id != ID(i) {
+			tt.Errorf("builtInsByName[%q]: got (0x%X, %t), want (0x%X, true)", name, id, ok, i)
+		}
+	}
+	for name, id := range builtInsByName {
+		if got := builtInsByID[id]; got != name {
+			tt.Errorf("builtInsByID[0x%X]: got %q, want %q", id, got, name)
+		}
+	}
+	if numNamed != len(builtInsByName) {
+		tt.Errorf("got %d named IDs but %d names", numNamed, len(builtInsByName))
+	}
+}
+
+func TestStrLiteralValue(tt *testing.T) {
+	testCases := []struct {
+		spelling string
+		want     string
+		wantOK   bool
+	}{
+		{`""`, "", true},
+		{`"foo bar"`, "foo bar", true},
+		{`"\n\t\"\\"`, "\n\t\"\\", true},
+		{`"a\x00b\xFFc"`, "a\x00b\xFFc", true},
+		{`'\x01\x02'le`, "\x01\x02", true},
+		{`"\q"`, "", false},
+		{`"\x0G"`, "", false},
+		{`"foo`, "", false},
+		{`"`, "", false},
+	}
+
+	m := &Map{}
+	for _, tc := range testCases {
+		x, err := m.Insert(tc.spelling)
+		if err != nil {
+			tt.Fatalf("Insert(%q): %v", tc.spelling, err)
+		}
+		got, err := x.StrLiteralValue(m)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			tt.Errorf("%s: ok: got %t, want %t (err=%v)", tc.spelling, gotOK, tc.wantOK, err)
+		} else if string(got) != tc.want {
+			tt.Errorf("%s: got %q, want %q", tc.spelling, got, tc.want)
+		}
+	}
+
+	if _, err := ID0.StrLiteralValue(m); err == nil {
+		tt.Errorf("ID0: got nil error, want non-nil")
+	}
+}
+
+func TestNumLiteralValue(tt *testing.T) {
+	testCases := []struct {
+		spelling string
+		want     uint64
+		wantOK   bool
+	}{
+		{"0", 0, true},
+		{"255", 255, true},
+		{"1_000", 1000, true},
+		{"0xFF", 0xFF, true},
+		{"0x_FF_FF", 0xFFFF, true},
+		{"18446744073709551615", 0xFFFFFFFFFFFFFFFF, true},
+		{"0xFFFF_FFFF_FFFF_FFFF", 0xFFFFFFFFFFFFFFFF, true},
+		{"18446744073709551616", 0, false},
+		{"0x1_0000_0000_0000_0000", 0, false},
+		{"0x", 0, false},
+		// The tokenizer has no binary or octal syntax.
+		{"0b1010", 0, false},
+		{"0777", 0, false},
+		{"1__000", 0, false},
+		{"foo", 0, false},
+	}
+
+	m := &Map{}
+	for _, tc := range testCases {
+		x, err := m.Insert(tc.spelling)
+		if err != nil {
+			tt.Fatalf("Insert(%q): %v", tc.spelling, err)
+		}
+		got, negative, err := x.NumLiteralValue(m)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			tt.Errorf("%s: ok: got %t, want %t (err=%v)", tc.spelling, gotOK, tc.wantOK, err)
+		} else if (got != tc.want) || negative {
+			tt.Errorf("%s: got (%d, %t), want (%d, false)", tc.spelling, got, negative, tc.want)
+		}
+	}
+
+	for x, want := range map[ID]uint64{ID0: 0, ID1: 1, ID256: 256} {
+		if got, _, err := x.NumLiteralValue(nil); (got != want) || (err != nil) {
+			tt.Errorf("0x%X: got (%d, %v), want (%d, nil)", x, got, err, want)
+		}
+	}
+}
+
+func TestTokenizeKeepComments(tt *testing.T) {
+	const src = "" +
+		"x = 1 // One.\n" +
+		"// Comment-only line.\n" +
+		"y = x + // Two.\n" +
+		"\t2\n"
+
+	testCases := []struct {
+		keepComments bool
+		want         string
+	}{
+		{false, "1:x 1:= 1:1 1:; 3:y 3:= 3:x 3:+ 4:2 4:;"},
+		{true, "1:x 1:= 1:1 1:; 1:// One. 2:// Comment-only line. " +
+			"3:y 3:= 3:x 3:+ 3:// Two. 4:2 4:;"},
+	}
+
+	for _, tc := range testCases {
+		m := &Map{}
+		tokens, comments, err := TokenizeWithOptions(m, "test.wuffs", []byte(src),
+			TokenizeOptions{KeepComments: tc.keepComments})
+		if err != nil {
+			tt.Fatalf("keepComments=%t: %v", tc.keepComments, err)
+		}
+		got := []string(nil)
+		numComments := 0
+		for _, tok := range tokens {
+			str := tok.ID.Str(m)
+			if tok.ID.IsComment() {
+				numComments++
+				if tok.ID.IsLiteral(m) || tok.ID.IsImplicitSemicolon(m) {
+					tt.Errorf("keepComments=%t: comment is a literal", tc.keepComments)
+				}
+				str = comments[tok.Line]
+			}
+			got = append(got, fmt.Sprintf("%d:%s", tok.Line, str))
+		}
+		if s := strings.Join(got, " "); s != tc.want {
+			tt.Errorf("keepComments=%t:\ngot  %q\nwant %q", tc.keepComments, s, tc.want)
+		}
+		if (numComments != 0) != tc.keepComments {
+			tt.Errorf("keepComments=%t: got %d comment tokens", tc.keepComments, numComments)
+		}
+		if got, want := strings.Join(comments, "|"), "|// One.|// Comment-only line.|// Two."; got != want {
+			tt.Errorf("keepComments=%t: comments: got %q, want %q", tc.keepComments, comments, want)
+		}
+		// The comments' text is not interned: the Map holds only "x" and "y".
+		if got := m.Len(); got != 2 {
+			tt.Errorf("keepComments=%t: m.Len: got %d, want 2", tc.keepComments, got)
+		}
+	}
+
+	for _, x := range []ID{IDSemicolon, IDSlash, ID0} {
+		if x.IsComment() {
+			tt.Errorf("0x%X: IsComment: got true, want false", x)
+		}
+	}
+}
+
+func TestTokenizeTildeOps(tt *testing.T) {
+	testCases := []struct {
+		src  string
+		want ID
+	}{
+		{"a ~mod+ b", IDTildeModPlus},
+		{"a ~mod- b", IDTildeModMinus},
+		{"a ~mod* b", IDTildeModStar},
+		{"a ~mod<< b", IDTildeModShiftL},
+		{"a ~sat+ b", IDTildeSatPlus},
+		{"a ~sat- b", IDTildeSatMinus},
+		{"a ~mod+= b", IDTildeModPlusEq},
+		{"a ~mod-= b", IDTildeModMinusEq},
+		{"a ~mod*= b", IDTildeModStarEq},
+		{"a ~mod<<= b", IDTildeModShiftLEq},
+		{"a ~sat+= b", IDTildeSatPlusEq},
+		{"a ~sat-= b", IDTildeSatMinusEq},
+	}
+	for _, tc := range testCases {
+		m := &Map{}
+		tokens, _, err := Tokenize(m, "test.wuffs", []byte(tc.src))
+		if err != nil {
+			tt.Fatalf("%q: Tokenize: %v", tc.src, err)
+		}
+		if (len(tokens) != 3) || (tokens[1].ID != tc.want) {
+			tt.Fatalf("%q: got %v, want the middle token to be 0x%X", tc.src, tokens, tc.want)
+		}
+		if x := tc.want; x.IsAssign() {
+			if (x.BinaryForm() == 0) || !x.BinaryForm().IsXBinaryOp() {
+				tt.Errorf("%q: BinaryForm: got 0x%X", tc.src, x.BinaryForm())
+			}
+		} else if !x.IsBinaryOp() {
+			tt.Errorf("%q: IsBinaryOp: got false, want true", tc.src)
+		}
+	}
+
+	// A '~' must start one of the forms above. In particular, there are no
+	// "~-" or "~*" shorthands for "~mod-" and "~mod*", and no "~sat*".
+	for _, src := range []string{"a ~ b", "a ~/ b", "a ~- b", "a ~* b", "a ~+ b", "a ~sat* b", "a ~mod/ b"} {
+		if _, _, err := Tokenize(&Map{}, "test.wuffs", []byte(src)); err == nil {
+			tt.Errorf("%q: Tokenize: got nil error, want non-nil", src)
+		}
+	}
+}
+
+func TestNewMapWithCapacity(tt *testing.T) {
+	for _, m := range []*Map{NewMap(), NewMapWithCapacity(0), NewMapWithCapacity(100)} {
+		x, err := m.Insert("foo")
+		if err != nil {
+			tt.Fatalf("Insert: %v", err)
+		}
+		if x != nBuiltInIDs {
+			tt.Errorf("Insert: got 0x%X, want 0x%X", x, nBuiltInIDs)
+		}
+		if got := m.ByName("foo"); got != x {
+			tt.Errorf("ByName: got 0x%X, want 0x%X", got, x)
+		}
+		if got := m.ByID(x); got != "foo" {
+			tt.Errorf("ByID: got %q, want %q", got, "foo")
+		}
+	}
+}
+
+func benchmarkMapInsert(b *testing.B, capacity int) {
+	const n = 10000
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("ident%d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := NewMapWithCapacity(capacity)
+		for _, name := range names {
+			if _, err := m.Insert(name); err != nil {
+				b.Fatalf("Insert: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkMapInsertSansCapacity(b *testing.B) { benchmarkMapInsert(b, 0) }
+func BenchmarkMapInsertWithCapacity(b *testing.B) { benchmarkMapInsert(b, 10000) }
+
+func TestZeroValueID(tt *testing.T) {
+	m := &Map{}
+	structType, err := m.Insert("my_struct")
+	if err != nil {
+		tt.Fatalf("Insert: %v", err)
+	}
+
+	testCases := []struct {
+		x    ID
+		want ID
+	}{
+		{IDU32, ID0},
+		{IDI8, ID0},
+		{IDU64, ID0},
+		{IDQIdeal, ID0},
+		{IDBool, IDFalse},
+		{IDNptr, IDNullptr},
+		{IDPtr, 0},
+		{IDSlice, 0},
+		{IDArray, 0},
+		{IDStatus, 0},
+		{structType, 0},
+	}
+	for _, tc := range testCases {
+		if got := tc.x.ZeroValueID(); got != tc.want {
+			tt.Errorf("%q: got 0x%X, want 0x%X", tc.x.Str(m), got, tc.want)
+		}
+	}
+}
+
+func TestTokenizeColumns(tt *testing.T) {
+	const src = "" +
+		"x += f(\"s\", 0x10)  // C.\n" +
+		"\ty = x ~mod+ 1\n"
+
+	m := &Map{}
+	tokens, _, err := TokenizeWithOptions(m, "test.wuffs", []byte(src),
+		TokenizeOptions{KeepComments: true})
+	if err != nil {
+		tt.Fatalf("Tokenize: %v", err)
+	}
+	got := []string(nil)
+	for _, tok := range tokens {
+		got = append(got, fmt.Sprintf("%d:%d:%s", tok.Line, tok.Column, tok.ID.Str(m)))
+	}
+	want := "" +
+		`1:1:x 1:3:+= 1:6:f 1:7:( 1:8:"s" 1:11:, 1:13:0x10 1:17:) 1:0:; 1:20:// ` +
+		`2:2:y 2:4:= 2:6:x 2:8:~mod+ 2:14:1 2:0:;`
+	if s := strings.Join(got, " "); s != want {
+		tt.Errorf("\ngot  %s\nwant %s", s, want)
+	}
+}
+
+func TestSpellings(tt *testing.T) {
+	testCases := []struct {
+		name    string
+		f       func() []string
+		want    []string
+		notWant []string
+	}{
+		{"Keyword", KeywordSpellings, []string{"func", "while", "return"}, []string{"+", "u32", "ptr"}},
+		{"Operator", OperatorSpellings, []string{"+", "+=", "=", "==", "not", "~mod+"}, []string{"func", "u32", ";"}},
+		{"Type", TypeSpellings, []string{"u32", "i8", "ptr", "slice"}, []string{"func", "+", "foo"}},
+	}
+	for _, tc := range testCases {
+		got := map[string]bool{}
+		for _, s := range tc.f() {
+			if s == "" {
+				tt.Errorf("%s: empty spelling", tc.name)
+			} else if got[s] {
+				tt.Errorf("%s: duplicate spelling %q", tc.name, s)
+			}
+			got[s] = true
+		}
+		for _, s := range tc.want {
+			if !got[s] {
+				tt.Errorf("%s: %q is missing", tc.name, s)
+			}
+		}
+		for _, s := range tc.notWant {
+			if got[s] {
+				tt.Errorf("%s: %q is present", tc.name, s)
+			}
+		}
+
+		// Each call returns a new slice.
+		if a := tc.f(); len(a) > 0 {
+			a[0] = "modified"
+			if tc.f()[0] == "modified" {
+				tt.Errorf("%s: the returned slice is shared", tc.name)
+			}
+		}
+	}
+}
+
+func TestNegatedLiteral(tt *testing.T) {
+	m := &Map{}
+	testCases := []struct {
+		s       string
+		want    ID
+		wantErr error
+	}{
+		{"0", ID0, nil},
+		{"0x00", ID0, nil},
+		{"0_0", ID0, nil},
+		{"1", 0, ErrNegativeLiteral},
+		{"5", 0, ErrNegativeLiteral},
+		{"0x7F", 0, ErrNegativeLiteral},
+	}
+	for _, tc := range testCases {
+		lit, err := m.Insert(tc.s)
+		if err != nil {
+			tt.Fatalf("Insert(%q): %v", tc.s, err)
+		}
+		got, err := m.NegatedLiteral(lit)
+		if (got != tc.want) || (err != tc.wantErr) {
+			tt.Errorf("%q: got (%v, %v), want (%v, %v)", tc.s, got, err, tc.want, tc.wantErr)
+		}
+	}
+
+	// A non-literal is an error, but not ErrNegativeLiteral.
+	if _, err := m.NegatedLiteral(IDIf); (err == nil) || (err == ErrNegativeLiteral) {
+		tt.Errorf("IDIf: got %v, want a non-ErrNegativeLiteral error", err)
+	}
+
+	if !IDXUnaryMinus.IsUnaryMinus() {
+		tt.Errorf("IDXUnaryMinus.IsUnaryMinus: got false, want true")
+	}
+	for _, x := range []ID{IDMinus, IDXBinaryMinus, IDXUnaryPlus, IDXUnaryNot} {
+		if x.IsUnaryMinus() {
+			tt.Errorf("ID 0x%02X: IsUnaryMinus: got true, want false", uint32(x))
+		}
+	}
+	if got := IDMinus.DisambiguateForm(true); !got.IsUnaryMinus() {
+		tt.Errorf("IDMinus.DisambiguateForm(true).IsUnaryMinus: got false, want true")
+	}
+}
+
+func TestIsControlFlowKeyword(tt *testing.T) {
+	want := map[ID]bool{
+		IDBreak:    true,
+		IDContinue: true,
+		IDElse:     true,
+		IDIf:       true,
+		IDIterate:  true,
+		IDReturn:   true,
+		IDWhile:    true,
+		IDYield:    true,
+	}
+	for x := ID(0); x < nBuiltInIDs; x++ {
+		if got := x.IsControlFlowKeyword(); got != want[x] {
+			tt.Errorf("ID 0x%X (%q): got %t, want %t", uint32(x), builtInsByID[x], got, want[x])
+		}
+		if want[x] && !x.IsKeyword() {
+			tt.Errorf("ID 0x%X (%q): IsKeyword: got false, want true", uint32(x), builtInsByID[x])
+		}
+	}
+
+	// Declaration keywords are keywords, but not control flow keywords.
+	for _, x := range []ID{IDConst, IDFunc, IDStruct, IDPub, IDPri, IDUse, IDVar} {
+		if !x.IsKeyword() || x.IsControlFlowKeyword() {
+			tt.Errorf("%q: got IsKeyword %t and IsControlFlowKeyword %t, want true and false",
+				builtInsByID[x], x.IsKeyword(), x.IsControlFlowKeyword())
+		}
+	}
+
+	m := &Map{}
+	id, err := m.Insert("if_only")
+	if err != nil {
+		tt.Fatalf("Insert: %v", err)
+	}
+	if id.IsControlFlowKeyword() {
+		tt.Errorf("non-built-in identifier: got true, want false")
+	}
+}
+
+func TestComparisonCounterpart(tt *testing.T) {
+	want := map[ID]ID{
+		IDEq:     IDEqEq,
+		IDEqEq:   IDEq,
+		IDExclam: IDNotEq,
+	}
+	for x := ID(0); x < nBuiltInIDs; x++ {
+		if got := x.ComparisonCounterpart(); got != want[x] {
+			tt.Errorf("ID 0x%X (%q): got 0x%X, want 0x%X", uint32(x), builtInsByID[x], uint32(got), uint32(want[x]))
+		}
+	}
+
+	// A C-style "!=" tokenizes as "!" then "=". The "!" suggests "<>".
+	m := &Map{}
+	tokens, _, err := Tokenize(m, "test.wuffs", []byte("a != b"))
+	if err != nil {
+		tt.Fatalf("Tokenize: %v", err)
+	}
+	if len(tokens) < 3 {
+		tt.Fatalf("len(tokens): got %d, want at least 3", len(tokens))
+	}
+	if got := tokens[1].ID.ComparisonCounterpart(); got != IDNotEq {
+		tt.Errorf("\"!\" in \"a != b\": got 0x%X, want IDNotEq", uint32(got))
+	}
+	if !tokens[2].ID.IsAssign() || (tokens[2].ID.ComparisonCounterpart() != IDEqEq) {
+		tt.Errorf("\"=\" in \"a != b\": got IsAssign %t, counterpart 0x%X",
+			tokens[2].ID.IsAssign(), uint32(tokens[2].ID.ComparisonCounterpart()))
+	}
+}
+
+func TestRoundTripEqual(tt *testing.T) {
+	const src = "pri func foo.bar!(x: u32, y: slice base.u8) base.u32 {\n" +
+		"\tif args.x > 0 {\n" +
+		"\t\treturn args.x + (args.y.length() as base.u32)\n" +
+		"\t}\n" +
+		"\treturn 0\n" +
+		"}\n"
+	m := &Map{}
+	tokens, _, err := Tokenize(m, "test.wuffs", []byte(src))
+	if err != nil {
+		tt.Fatalf("Tokenize: %v", err)
+	}
+	if equal, diff := RoundTripEqual(m, tokens, nil); !equal {
+		tt.Fatalf("function declaration: got not equal: %s", diff)
+	}
+
+	// The same IDs, all on one line, round-trip too: each implicit semicolon
+	// is rendered as a line break.
+	flat := append([]Token(nil), tokens...)
+	for i := range flat {
+		flat[i].Line = 1
+	}
+	if equal, diff := RoundTripEqual(m, flat, nil); !equal {
+		tt.Fatalf("flattened: got not equal: %s", diff)
+	}
+
+	// Dropping the implicit semicolon after the final "}" does not round-trip.
+	if n := len(tokens); tokens[n-1] != (Token{IDSemicolon, 6, 0}) {
+		tt.Fatalf("final token: got %v, want an implicit semicolon", tokens[n-1])
+	} else if equal, diff := RoundTripEqual(m, tokens[:n-1], nil); equal {
+		tt.Fatalf("missing semicolon: got equal, want not equal")
+	} else if !strings.Contains(diff, "have \";\", want <none>") {
+		tt.Fatalf("missing semicolon: diff %q does not describe the extra \";\"", diff)
+	}
+}
+
+func TestSpellingWidth(tt *testing.T) {
+	m := &Map{}
+	id, err := m.Insert("foo_bar")
+	if err != nil {
+		tt.Fatalf("Insert: %v", err)
+	}
+	tokens, _, err := TokenizeWithOptions(m, "test.wuffs",
+		[]byte("x = \"héllo\"  // naïve\n"), TokenizeOptions{KeepComments: true})
+	if err != nil {
+		tt.Fatalf("TokenizeWithOptions: %v", err)
+	}
+	if len(tokens) != 5 {
+		tt.Fatalf("len(tokens): got %d, want 5", len(tokens))
+	}
+
+	testCases := []struct {
+		id   ID
+		want int
+	}{
+		{IDFunc, 4},
+		{IDEqEq, 2},
+		{IDOpenParen, 1},
+		{id, 7},
+		{tokens[2].ID, 7},  // The string literal "héllo", including quotes.
+		{tokens[4].ID, 2},  // The comment, whose text is not in the Map.
+		{IDXUnaryMinus, 1}, // The X-forms are rendered as their symbol.
+		{IDXBinaryNotEq, 2},
+		{0, 0},
+	}
+	for _, tc := range testCases {
+		if got := tc.id.SpellingWidth(m); got != tc.want {
+			tt.Errorf("ID 0x%X (%q): got %d, want %d", uint32(tc.id), m.ByID(tc.id), got, tc.want)
+		}
+	}
+}
+
+func TestRawStrLiteral(tt *testing.T) {
+	m := &Map{}
+	tokens, _, err := Tokenize(m, "test.wuffs", []byte("x = `a\"b\\c'd\\x00`\n"))
+	if err != nil {
+		tt.Fatalf("Tokenize: %v", err)
+	}
+	if len(tokens) != 4 {
+		tt.Fatalf("len(tokens): got %d, want 4", len(tokens))
+	}
+	raw := tokens[2].ID
+	if got, want := m.ByID(raw), "`a\"b\\c'd\\x00`"; got != want {
+		tt.Fatalf("spelling: got %q, want %q", got, want)
+	}
+	if !raw.IsRawStrLiteral(m) || !raw.IsLiteral(m) || raw.IsDQStrLiteral(m) || raw.IsSQStrLiteral(m) {
+		tt.Fatalf("predicates: got IsRawStrLiteral %t, IsLiteral %t, IsDQStrLiteral %t, IsSQStrLiteral %t",
+			raw.IsRawStrLiteral(m), raw.IsLiteral(m), raw.IsDQStrLiteral(m), raw.IsSQStrLiteral(m))
+	}
+	if tokens[0].ID.IsRawStrLiteral(m) {
+		tt.Fatalf("\"x\": got IsRawStrLiteral true, want false")
+	}
+	if got, err := raw.StrLiteralValue(m); err != nil {
+		tt.Fatalf("StrLiteralValue: %v", err)
+	} else if want := "a\"b\\c'd\\x00"; string(got) != want {
+		tt.Fatalf("StrLiteralValue: got %q, want %q", got, want)
+	}
+
+	// Backticks do not nest, so "``" ends one raw string and starts another.
+	tokens, _, err = Tokenize(m, "test.wuffs", []byte("`a``b`"))
+	if err != nil {
+		tt.Fatalf("Tokenize: %v", err)
+	}
+	if len(tokens) != 2 || m.ByID(tokens[0].ID) != "`a`" || m.ByID(tokens[1].ID) != "`b`" {
+		tt.Fatalf("adjacent: got %d tokens, want \"`a`\" and \"`b`\"", len(tokens))
+	}
+
+	for _, src := range []string{"`abc", "`abc\ndef`", "x = `"} {
+		if _, _, err := Tokenize(m, "test.wuffs", []byte(src)); err == nil {
+			tt.Errorf("%q: got nil error, want non-nil", src)
+		}
+	}
+
+	for _, tc := range []struct {
+		s    string
+		want bool
+	}{
+		{"``", true},
+		{"`\\`", true},
+		{"`a\"b`", true},
+		{"`abc", false},
+		{"`a`b`", false},
+		{"`a`le", false},
+	} {
+		if got := IsValidStrLiteral(tc.s); got != tc.want {
+			tt.Errorf("IsValidStrLiteral(%q): got %t, want %t", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestIsDeclarationKeyword(tt *testing.T) {
+	want := map[ID]bool{
+		IDConst:  true,
+		IDFunc:   true,
+		IDPri:    true,
+		IDPub:    true,
+		IDStruct: true,
+		IDUse:    true,
+		IDVar:    true,
+	}
+	for x := ID(0); x < nBuiltInIDs; x++ {
+		if got := x.IsDeclarationKeyword(); got != want[x] {
+			tt.Errorf("ID 0x%X (%q): got %t, want %t", uint32(x), builtInsByID[x], got, want[x])
+		}
+	}
+
+	// Declaration keywords, control flow keywords and these others partition
+	// the keywords.
+	others := map[ID]bool{
+		IDAssert:     true,
+		IDEndwhile:   true,
+		IDIOBind:     true,
+		IDIOLimit:    true,
+		IDImplements: true,
+		IDInv:        true,
+		IDPost:       true,
+		IDPre:        true,
+		IDVia:        true,
+	}
+	for x := ID(minKeyword); x <= maxKeyword; x++ {
+		if builtInsByID[x] == "" {
+			continue
+		}
+		n := 0
+		if x.IsDeclarationKeyword() {
+			n++
+		}
+		if x.IsControlFlowKeyword() {
+			n++
+		}
+		if others[x] {
+			n++
+		}
+		if n != 1 {
+			tt.Errorf("ID 0x%X (%q): in %d of the keyword groups, want 1", uint32(x), builtInsByID[x], n)
+		}
+	}
+}
+
+func TestInternQID(tt *testing.T) {
+	m := &Map{}
+	testCases := [][3]string{
+		{"base", "u32", ""},
+		{"", "my_func", ""},
+		{"foo", "bar", ""},
+		{"foo", "bar", "baz"},
+		{"base", "u32", ""},
+		{"fxo", "bar", ""}, // The same cache slot as "foo.bar".
+		{"foo", "bar", ""},
+		{"", "", ""},
+	}
+	for i := 0; i < 3; i++ {
+		for _, tc := range testCases {
+			want := QQID{}
+			for j, s := range tc {
+				id, err := m.Insert(s)
+				if err != nil {
+					tt.Fatalf("Insert(%q): %v", s, err)
+				}
+				if (id != m.ByName(s)) && (s != "") {
+					tt.Fatalf("%q: Insert and ByName disagree", s)
+				}
+				want[j] = id
+			}
+
+			if tc[2] == "" {
+				got, err := m.InternQID(tc[0], tc[1])
+				if err != nil {
+					tt.Fatalf("InternQID(%q, %q): %v", tc[0], tc[1], err)
+				} else if (got != QID{want[0], want[1]}) {
+					tt.Fatalf("InternQID(%q, %q): got %v, want %v", tc[0], tc[1], got, want)
+				}
+			}
+			got, err := m.InternQQID(tc[0], tc[1], tc[2])
+			if err != nil {
+				tt.Fatalf("InternQQID(%q): %v", tc, err)
+			} else if got != want {
+				tt.Fatalf("InternQQID(%q): got %v, want %v", tc, got, want)
+			}
+		}
+	}
+	if got := (QID{m.ByName("base"), m.ByName("u32")}).Str(m); got != "base.u32" {
+		tt.Fatalf("Str: got %q, want \"base.u32\"", got)
+	}
+}
+
+func benchmarkInternQID(b *testing.B, intern bool) {
+	m := &Map{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if intern {
+			if _, err := m.InternQID("my_package", "my_identifier"); err != nil {
+				b.Fatalf("InternQID: %v", err)
+			}
+		} else {
+			if _, err := m.Insert("my_package"); err != nil {
+				b.Fatalf("Insert: %v", err)
+			}
+			if _, err := m.Insert("my_identifier"); err != nil {
+				b.Fatalf("Insert: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkInternQIDCached(b *testing.B)   { benchmarkInternQID(b, true) }
+func BenchmarkInternQIDUncached(b *testing.B) { benchmarkInternQID(b, false) }
+
+func TestTokenizeAt(tt *testing.T) {
+	m := &Map{}
+	tokens, _, err := Tokenize(m, "test.wuffs", []byte("@inline @hint(x) pri func f()() {}\n"))
+	if err != nil {
+		tt.Fatalf("Tokenize: %v", err)
+	}
+	got := []string(nil)
+	for _, tok := range tokens {
+		got = append(got, m.ByID(tok.ID))
+	}
+	if got, want := strings.Join(got, " "), "@ inline @ hint ( x ) pri func f ( ) ( ) { } ;"; got != want {
+		tt.Fatalf("got %q, want %q", got, want)
+	}
+	if tokens[0].ID != IDAt {
+		tt.Fatalf("tokens[0]: got 0x%X, want IDAt", uint32(tokens[0].ID))
+	}
+	if (tokens[0].Column != 1) || (tokens[1].Column != 2) {
+		tt.Fatalf("columns: got %d and %d, want 1 and 2", tokens[0].Column, tokens[1].Column)
+	}
+	if !IDAt.IsTightRight() || IDAt.IsTightLeft() {
+		tt.Fatalf("IDAt: got IsTightRight %t, IsTightLeft %t, want true, false",
+			IDAt.IsTightRight(), IDAt.IsTightLeft())
+	}
+	if equal, diff := RoundTripEqual(m, tokens, nil); !equal {
+		tt.Fatalf("RoundTripEqual: %s", diff)
+	}
+}
+
+func TestMapFresh(tt *testing.T) {
+	m := &Map{}
+	// An identifier that a Fresh call would otherwise generate.
+	existing, err := m.Insert("__tmp1")
+	if err != nil {
+		tt.Fatalf("Insert: %v", err)
+	}
+
+	seen := map[ID]bool{existing: true}
+	for i := 0; i < 5; i++ {
+		id, err := m.Fresh("tmp")
+		if err != nil {
+			tt.Fatalf("i=%d: Fresh: %v", i, err)
+		}
+		if seen[id] {
+			tt.Fatalf("i=%d: Fresh returned %q, which was already present", i, m.ByID(id))
+		}
+		seen[id] = true
+		if s := m.ByID(id); !IsValidIdentifier(s) || !strings.HasPrefix(s, "__tmp") {
+			tt.Fatalf("i=%d: got %q, want a valid identifier starting with \"__tmp\"", i, s)
+		}
+		if got := m.ByName(m.ByID(id)); got != id {
+			tt.Fatalf("i=%d: ByName: got 0x%X, want 0x%X", i, uint32(got), uint32(id))
+		}
+	}
+	if got, want := m.Len(), 6; got != want {
+		tt.Fatalf("Len: got %d, want %d", got, want)
+	}
+
+	if _, err := m.Fresh("not valid"); err == nil {
+		tt.Fatalf("invalid prefix: got nil error, want non-nil")
+	}
+}
+
+func TestIsReservedMarker(tt *testing.T) {
+	if !IsReservedMarker(IdealIntegerTypeID) || !IsReservedMarker(GenericTypeID) {
+		tt.Fatalf("IdealIntegerTypeID, GenericTypeID: want reserved markers")
+	}
+	for _, x := range []ID{IDT1, IDThis, IDI8, IDU64, IDFunc, IDPlus} {
+		if IsReservedMarker(x) {
+			tt.Fatalf("%q: got reserved marker, want not", x.BuiltInName())
+		}
+	}
+
+	// Tokenize every ASCII byte, and every ASCII built-in name, on its own.
+	srcs := []string(nil)
+	for c := 0; c < 0x80; c++ {
+		srcs = append(srcs, string(rune(c))+"\n")
+	}
+	for x := ID(0); x < nBuiltInIDs; x++ {
+		if s := builtInsByID[x]; s != "" {
+			srcs = append(srcs, s+"\n")
+		}
+	}
+	m := &Map{}
+	for _, src := range srcs {
+		tokens, _, err := Tokenize(m, "test.wuffs", []byte(src))
+		if err != nil {
+			continue
+		}
+		for _, t := range tokens {
+			if IsReservedMarker(t.ID) {
+				tt.Fatalf("src %q: Tokenize returned reserved marker %q", src, m.ByID(t.ID))
+			}
+		}
+	}
+}
+
+func TestIsEffectMarker(tt *testing.T) {
+	for _, x := range []ID{IDExclam, IDQuestion} {
+		if !x.IsEffectMarker() {
+			tt.Errorf("%q: got false, want true", x.BuiltInName())
+		}
+	}
+	for _, x := range []ID{IDNotEq, IDEqQuestion, IDNot, IDColon, IDDot, IDAt} {
+		if x.IsEffectMarker() {
+			tt.Errorf("%q: got true, want false", x.BuiltInName())
+		}
+	}
+
+	// The classifier is context-free: the "!" and "?" in effect position and
+	// the "=?" elsewhere tokenize as they do anywhere else.
+	m := &Map{}
+	tokens, _, err := Tokenize(m, "test.wuffs", []byte("pub func foo!() {\n\tx =? bar?()\n}\n"))
+	if err != nil {
+		tt.Fatalf("Tokenize: %v", err)
+	}
+	got := []string(nil)
+	for _, t := range tokens {
+		if t.ID.IsEffectMarker() {
+			got = append(got, m.ByID(t.ID))
+		}
+	}
+	if g, w := strings.Join(got, " "), "! ?"; g != w {
+		tt.Fatalf("effect markers: got %q, want %q", g, w)
+	}
+}
+
+func TestCheckBalanced(tt *testing.T) {
+	testCases := []struct {
+		src     string
+		wantErr string
+	}{
+		{"f(a[b], {{c}})\n", ""},
+		{"if x {\n\ty = (z)\n}\n", ""},
+		{"f(a,\n\tb\n", `token: unclosed "(" at line 1`},
+		{"x = y)\n", `token: unexpected ")" at line 1`},
+		{"x = [\n\t(y]\n", `token: "]" at line 2 does not match "(" at line 2`},
+	}
+	m := &Map{}
+	for _, tc := range testCases {
+		tokens, _, err := Tokenize(m, "test.wuffs", []byte(tc.src))
+		if err != nil {
+			tt.Errorf("%q: Tokenize: %v", tc.src, err)
+			continue
+		}
+		gotErr := ""
+		if err := CheckBalanced(tokens); err != nil {
+			gotErr = err.Error()
+		}
+		if gotErr != tc.wantErr {
+			tt.Errorf("%q: got %q, want %q", tc.src, gotErr, tc.wantErr)
+		}
+	}
+}
+
+func TestSpaceBetween(tt *testing.T) {
+	testCases := []struct {
+		src  string
+		want string
+	}{
+		{"foo(", "foo("},
+		{"a + b", "a + b"},
+		{"x[", "x["},
+		{"a*(b+c)", "a * (b + c)"},
+		{"f ? (x)", "f?(x)"},
+		{"x . y ( z , 1 )", "x.y(z, 1)"},
+		{"if (a)", "if (a)"},
+	}
+	m := &Map{}
+	for _, tc := range testCases {
+		tokens, _, err := Tokenize(m, "test.wuffs", []byte(tc.src))
+		if err != nil {
+			tt.Errorf("%q: Tokenize: %v", tc.src, err)
+			continue
+		}
+		got := []byte(nil)
+		for i, tok := range tokens {
+			if (i > 0) && SpaceBetween(tokens[i-1].ID, tok.ID) {
+				got = append(got, ' ')
+			}
+			got = append(got, m.ByID(tok.ID)...)
+		}
+		if string(got) != tc.want {
+			tt.Errorf("%q: got %q, want %q", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestTokenizeKeepCommentsRoundTrip(tt *testing.T) {
+	const src = "// Header.\npub func f() {\n\tx = 1  // One.\n}\n"
+	m := &Map{}
+	tokens, comments, err := TokenizeWithOptions(m, "test.wuffs", []byte(src),
+		TokenizeOptions{KeepComments: true})
+	if err != nil {
+		tt.Fatalf("TokenizeWithOptions: %v", err)
+	}
+	got := []string(nil)
+	for _, t := range tokens {
+		if t.ID.IsComment() {
+			got = append(got, fmt.Sprintf("%d:%s", t.Line, comments[t.Line]))
+		}
+	}
+	if g, w := strings.Join(got, " "), "1:// Header. 3:// One."; g != w {
+		tt.Fatalf("comment tokens: got %q, want %q", g, w)
+	}
+	// The comments return value, indexed by line, is unaffected.
+	if (len(comments) < 4) || (comments[1] != "// Header.") || (comments[3] != "// One.") {
+		tt.Fatalf("comments: got %q", comments)
+	}
+	if equal, diff := RoundTripEqual(m, tokens, comments); !equal {
+		tt.Fatalf("RoundTripEqual: %s", diff)
+	}
+
+	// The comments' text is not in the Map, so it has to come from the side
+	// table. Without line 3's entry, the "// One." token cannot be rendered.
+	if equal, diff := RoundTripEqual(m, tokens, comments[:3]); equal {
+		tt.Fatalf("truncated comments: got equal, want not equal")
+	} else if !strings.Contains(diff, "no comment text for line 3") {
+		tt.Fatalf("truncated comments: diff %q does not name line 3", diff)
+	}
+}
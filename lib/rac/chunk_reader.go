@@ -15,7 +15,7 @@
 package rac
 
 import (
-	"hash/crc32"
+	"bytes"
 	"io"
 
 	"github.com/google/wuffs/lib/readerat"
@@ -68,30 +68,91 @@ type Chunk struct {
 	Codec      Codec
 }
 
+// Equal returns whether c and d are the same chunk: whether all of their
+// fields, including the tags and the Codec, are equal.
+func (c Chunk) Equal(d Chunk) bool {
+	return c == d
+}
+
+// TagKind classifies a Chunk's STag or TTag.
+type TagKind uint8
+
+const (
+	// TagKindNone means that the tag refers to no sibling: the chunk has no
+	// secondary (or tertiary) data and the corresponding CRange is empty.
+	TagKindNone = TagKind(0)
+
+	// TagKindChunkRef means that the tag is the index of a sibling in the
+	// chunk's leaf node, and the corresponding CRange is that sibling's
+	// compressed data, such as a dictionary.
+	TagKindChunkRef = TagKind(1)
+
+	// TagKindReserved means that the tag value is reserved by the RAC
+	// specification.
+	TagKindReserved = TagKind(2)
+)
+
+// SecondaryTagKind classifies c.STag. An STag of 0xFF is TagKindNone. As a
+// ChunkReader rejects, as corrupt, an index node with any other STag that is
+// not less than the node's arity, any other STag is TagKindChunkRef.
+func (c Chunk) SecondaryTagKind() TagKind {
+	if c.STag == 0xFF {
+		return TagKindNone
+	}
+	return TagKindChunkRef
+}
+
+// TertiaryTagKind classifies c.TTag. A TTag of 0xFF is TagKindNone and one in
+// the range [0xC0, 0xFF) is TagKindReserved. Any other TTag is
+// TagKindChunkRef, although one that is not less than the leaf node's arity
+// (which a Chunk does not record) yields an empty CTertiary, just like
+// TagKindNone.
+func (c Chunk) TertiaryTagKind() TagKind {
+	if c.TTag == 0xFF {
+		return TagKindNone
+	} else if c.TTag >= 0xC0 {
+		return TagKindReserved
+	}
+	return TagKindChunkRef
+}
+
+// ChunkLess returns whether a's DRange starts before b's. It can be used to
+// sort a slice of chunks, such as those gathered from multiple ChunkReader
+// passes, into DSpace order.
+func ChunkLess(a Chunk, b Chunk) bool {
+	return a.DRange[0] < b.DRange[0]
+}
+
 // nodeSize returns the size (in CSpace) that a node with the given arity
 // occupies.
 func nodeSize(arity uint8) int {
 	return (16 * int(arity)) + 16
 }
 
-// rNode is the ChunkReader's representation of a node.
+// maxNodeSize is the largest nodeSize, for the maximum arity of 255. It is
+// the size of the buffers that nodes are read into.
+const maxNodeSize = (16 * 255) + 16
+
+// rNode is the ChunkReader's representation of a node: its nodeSize(arity)
+// bytes. It is either a sub-slice of a maxNodeSize buffer that the node was
+// read into or, for an in-memory RAC file, a sub-slice of that file's bytes.
 //
 // None of its methods, other than valid, should be called unless valid returns
 // true.
-type rNode [4096]byte
+type rNode []byte
 
-func (b *rNode) arity() int           { return int(b[3]) }
-func (b *rNode) codecHasMixBit() bool { return b[(8*int(b[3]))+7]&0x40 != 0 }
-func (b *rNode) cPtrMax() int64       { return u48LE(b[(16*int(b[3]))+8:]) }
-func (b *rNode) dPtrMax() int64       { return u48LE(b[8*int(b[3]):]) }
-func (b *rNode) version() uint8       { return b[(16*int(b[3]))+14] }
+func (b rNode) arity() int           { return int(b[3]) }
+func (b rNode) codecHasMixBit() bool { return b[(8*int(b[3]))+7]&0x40 != 0 }
+func (b rNode) cPtrMax() int64       { return u48LE(b[(16*int(b[3]))+8:]) }
+func (b rNode) dPtrMax() int64       { return u48LE(b[8*int(b[3]):]) }
+func (b rNode) version() uint8       { return b[(16*int(b[3]))+14] }
 
 // codec returns the 1-byte (short) or 7-byte (long) codec as a valid Codec, or
 // CodecInvalid.
 //
 // The (uint64) Codec value returned does not have the Mix Bit (the 62nd bit)
 // set, regardless of whether it's set in the rNode's bytes.
-func (b *rNode) codec() Codec {
+func (b rNode) codec() Codec {
 	arity := int(b[3])
 	cByte := b[(8*arity)+7]
 
@@ -115,17 +176,17 @@ func (b *rNode) codec() Codec {
 	return CodecInvalid
 }
 
-func (b *rNode) cLen(i int) uint8 {
+func (b rNode) cLen(i int) uint8 {
 	base := (8 * int(b[3])) + 14
 	return b[(8*i)+base]
 }
 
-func (b *rNode) cOff(i int, cBias int64) int64 {
+func (b rNode) cOff(i int, cBias int64) int64 {
 	base := (8 * int(b[3])) + 8
 	return cBias + u48LE(b[(8*i)+base:])
 }
 
-func (b *rNode) cOffRange(i int, cBias int64) Range {
+func (b rNode) cOffRange(i int, cBias int64) Range {
 	m := cBias + b.cPtrMax()
 	if i >= b.arity() {
 		return Range{m, m}
@@ -139,18 +200,18 @@ func (b *rNode) cOffRange(i int, cBias int64) Range {
 	return Range{cOff, m}
 }
 
-func (b *rNode) dOff(i int, dBias int64) int64 {
+func (b rNode) dOff(i int, dBias int64) int64 {
 	if i == 0 {
 		return dBias
 	}
 	return dBias + u48LE(b[8*i:])
 }
 
-func (b *rNode) dOffRange(i int, dBias int64) Range {
+func (b rNode) dOffRange(i int, dBias int64) Range {
 	return Range{b.dOff(i, dBias), b.dOff(i+1, dBias)}
 }
 
-func (b *rNode) dSize(i int) int64 {
+func (b rNode) dSize(i int) int64 {
 	x := int64(0)
 	if i > 0 {
 		x = u48LE(b[8*i:])
@@ -158,16 +219,16 @@ func (b *rNode) dSize(i int) int64 {
 	return u48LE(b[(8*i)+8:]) - x
 }
 
-func (b *rNode) sTag(i int) uint8 {
+func (b rNode) sTag(i int) uint8 {
 	base := (8 * int(b[3])) + 15
 	return b[(8*i)+base]
 }
 
-func (b *rNode) tTag(i int) uint8 {
+func (b rNode) tTag(i int) uint8 {
 	return b[(8*i)+7]
 }
 
-func (b *rNode) isLeaf(i int) bool {
+func (b rNode) isLeaf(i int) bool {
 	return b[(8*i)+7] != 0xFE
 }
 
@@ -175,7 +236,7 @@ func (b *rNode) isLeaf(i int) bool {
 // less than or equal to the dOff argument.
 //
 // The dOff argument must be non-negative and less than DOffMax.
-func (b *rNode) findChunkContaining(dOff int64, dBias int64) int {
+func (b rNode) findChunkContaining(dOff int64, dBias int64) int {
 	// Define f(x) to be whether the x'th DOff is greater than dOff, so that
 	// f(-1) is false and f(arity) is true. The DOff values are non-decreasing
 	// so that f(x) true implies f(x+1) true.
@@ -202,7 +263,7 @@ func (b *rNode) findChunkContaining(dOff int64, dBias int64) int {
 	return lo - 1
 }
 
-func (b *rNode) chunk(i int, cBias int64, dBias int64) Chunk {
+func (b rNode) chunk(i int, cBias int64, dBias int64) Chunk {
 	sTag := b.sTag(i)
 	tTag := b.tTag(i)
 	return Chunk{
@@ -216,14 +277,14 @@ func (b *rNode) chunk(i int, cBias int64, dBias int64) Chunk {
 	}
 }
 
-func (b *rNode) valid() bool {
+func (b rNode) valid(skipChecksum bool) bool {
 	// Check the magic and arity.
 	if (b[0] != magic[0]) || (b[1] != magic[1]) || (b[2] != magic[2]) || (b[3] == 0) {
 		return false
 	}
 	arity := int(b[3])
 	size := (16 * arity) + 16
-	if b[3] != b[size-1] {
+	if (len(b) < size) || (b[3] != b[size-1]) {
 		return false
 	}
 
@@ -279,10 +340,11 @@ func (b *rNode) valid() bool {
 	}
 
 	// Check the checksum.
-	checksum := crc32.ChecksumIEEE(b[6:size])
-	checksum ^= checksum >> 16
-	if (b[4] != uint8(checksum>>0)) || (b[5] != uint8(checksum>>8)) {
-		return false
+	if !skipChecksum {
+		checksum := nodeChecksum(b[:])
+		if (b[4] != uint8(checksum>>0)) || (b[5] != uint8(checksum>>8)) {
+			return false
+		}
 	}
 
 	// Further checking of the codec, version, COffMax and DOffMax requires
@@ -291,6 +353,82 @@ func (b *rNode) valid() bool {
 	return b.codec().Valid()
 }
 
+// invalidChild returns the index of the first child that is inconsistent with
+// its siblings, or -1 if there is no such child. It should only be called
+// after valid returns true.
+//
+// A branch node child (a TTag of 0xFE) must have a positive DSize. Other than
+// for Codec Entries (a TTag of 0xFD), an STag must either refer to a child of
+// this node or be 0xFF, meaning no secondary data.
+func (b rNode) invalidChild() int {
+	arity := b.arity()
+	for i := 0; i < arity; i++ {
+		tTag := b.tTag(i)
+		if tTag == 0xFD {
+			continue
+		}
+		if (tTag == 0xFE) && (b.dSize(i) <= 0) {
+			return i
+		}
+		if sTag := b.sTag(i); (int(sTag) >= arity) && (sTag != 0xFF) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Node is a read-only view of a RAC index node, for inspection and debugging
+// tools. Most users will not need it: a ChunkReader or Reader walks the index
+// on their behalf.
+//
+// The methods that take an i argument require that i is in the range [0,
+// Arity()). Their DOff and COff values are relative to the node itself: they
+// do not add the DBias or CBias that its parent node (if any) would apply.
+//
+// See the RAC specification for further discussion.
+type Node struct {
+	cOffset int64
+	b       rNode
+	buf     [maxNodeSize]byte
+}
+
+// COffset returns the position of the node in the RAC file (in CSpace).
+func (n *Node) COffset() int64 { return n.cOffset }
+
+// Arity returns the node's arity: the number of children, including Codec
+// Entries.
+func (n *Node) Arity() int { return n.b.arity() }
+
+// Codec returns the node's Codec, without the Mix Bit.
+func (n *Node) Codec() Codec { return n.b.codec() }
+
+// CodecHasMixBit returns whether the node's Codec has the Mix Bit set.
+func (n *Node) CodecHasMixBit() bool { return n.b.codecHasMixBit() }
+
+// Version returns the node's RAC file format version.
+func (n *Node) Version() uint8 { return n.b.version() }
+
+// CPtrMax returns the node's CPtrMax value.
+func (n *Node) CPtrMax() int64 { return n.b.cPtrMax() }
+
+// DPtrMax returns the node's DPtrMax value.
+func (n *Node) DPtrMax() int64 { return n.b.dPtrMax() }
+
+// DOff returns the i'th child's DOff.
+func (n *Node) DOff(i int) int64 { return n.b.dOff(i, 0) }
+
+// COff returns the i'th child's COff.
+func (n *Node) COff(i int) int64 { return n.b.cOff(i, 0) }
+
+// CLen returns the i'th child's CLen.
+func (n *Node) CLen(i int) uint8 { return n.b.cLen(i) }
+
+// STag returns the i'th child's STag.
+func (n *Node) STag(i int) uint8 { return n.b.sTag(i) }
+
+// TTag returns the i'th child's TTag.
+func (n *Node) TTag(i int) uint8 { return n.b.tTag(i) }
+
 // ChunkReader parses a RAC file.
 //
 // Do not modify its exported fields after calling any of its methods.
@@ -317,19 +455,90 @@ type ChunkReader struct {
 	// Zero is an invalid value. The smallest valid RAC file is 32 bytes long.
 	CompressedSize int64
 
+	// BaseOffset is where the RAC file starts within the ReadSeeker, for a RAC
+	// file embedded in a larger container. CSpace offset x is read from
+	// ReadSeeker offset (BaseOffset + x). This includes the reads made by
+	// CodecReaders, which are given an io.ReadSeeker that applies the offset.
+	//
+	// Zero, the default, means that the RAC file starts at the ReadSeeker's
+	// start. Negative values are invalid.
+	BaseOffset int64
+
+	// SkipChecksumVerification is whether to skip verifying each index node's
+	// checksum. All other structural checks are still made.
+	//
+	// Skipping saves a CRC-32 computation per node load, which can add up for
+	// a seek-heavy workload, but it means that some corruption (or tampering)
+	// of the index will go undetected and yield garbled chunks instead of an
+	// error. Only set it for trusted input, such as a file that has
+	// previously been read with verification.
+	SkipChecksumVerification bool
+
+	// BestEffort is whether to skip, instead of failing on, a corrupt branch
+	// node (or one that lies past the end of the RAC file). NextChunk then
+	// continues with the chunks after that node's subtree, and SkippedRanges
+	// reports the subtree's DRange. A corrupt root node is still an error.
+	//
+	// It is intended for recovering what data remains in a damaged file. The
+	// default, false, is to treat any corruption as an error.
+	BestEffort bool
+
+	// Metrics, if non-nil, is where this ChunkReader counts its reads and node
+	// validations. Nil means to not count them.
+	Metrics *Metrics
+
+	// MaxArity, if non-zero, is the largest index node arity to accept. A
+	// node with a larger arity is rejected with an *ErrCorruptIndex error,
+	// even if it is otherwise valid.
+	//
+	// The RAC format allows arities up to 255. A smaller limit can detect
+	// corrupt or adversarial input early, for files that are known to never
+	// use a high fanout. Zero, the default, means no limit (other than 255).
+	MaxArity uint8
+
+	// SmallIndexThreshold, if positive, is how many bytes to read, in one go,
+	// from the end of the RAC file where the index usually is (or the start,
+	// for an IndexLocationAtStart file), when finding the root node. Index
+	// nodes within those bytes are then loaded from memory, without any further
+	// I/O, although they are still validated. For a RAC file whose whole index
+	// is smaller than the threshold, that is every node.
+	//
+	// This trades reading up to SmallIndexThreshold bytes up front, some of
+	// which may be chunk data instead of index nodes, for fewer reads. It can
+	// suit a high-latency source, with a threshold such as 65536. Zero, the
+	// default, means to read each node separately.
+	SmallIndexThreshold int64
+
+	// skippedRanges are the DRanges of the subtrees skipped in BestEffort
+	// mode, in the order that they were encountered.
+	skippedRanges []Range
+
+	// skippedErrs are the errors that caused each of the skippedRanges.
+	skippedErrs []error
+
 	// initialized is set true after the first call on this ChunkReader.
 	initialized bool
 
 	// rootNodeArity is the root node's arity.
 	rootNodeArity uint8
 
+	// rootNodeIsLeaf is whether every child of the root node is a leaf, as for
+	// a small RAC file whose sole node holds all of the chunks. If so, seeking
+	// just indexes into rootNode, without walking the index.
+	rootNodeIsLeaf bool
+
 	// needToResolveSeekPosition is whether NextChunk will need to resolve
 	// seekPosition.
 	needToResolveSeekPosition bool
 
+	// indexBuf holds the SmallIndexThreshold bytes at CSpace offset
+	// indexBufCOffset, if non-nil.
+	indexBuf        []byte
+	indexBufCOffset int64
+
 	// readSeeker is either the same as the ReadSeeker field value, or it is
 	// that field value wrapped by a readerat.ReadSeeker to be safe to use
-	// concurrently.
+	// concurrently. Either way, it is also wrapped to apply any BaseOffset.
 	readSeeker io.ReadSeeker
 
 	// err is the first error encountered. It is sticky: once a non-nil error
@@ -342,6 +551,10 @@ type ChunkReader struct {
 	// rootNodeCOffset is the position of the root node in the RAC file.
 	rootNodeCOffset int64
 
+	// rootNodeFromEnd is whether the root node was found at the end of the
+	// RAC file, instead of at the start.
+	rootNodeFromEnd bool
+
 	// seekPosition gives, if needToResolveSeekPosition is true, the position
 	// in DSpace that NextChunk needs to find.
 	seekPosition int64
@@ -356,8 +569,41 @@ type ChunkReader struct {
 	currNodeCBias int64
 	currNodeDBias int64
 
-	// currNode is the 4096 byte buffer to hold the current node.
+	// numResolves counts the successful resolveSeekPosition calls: how many
+	// times currNode has been (re-)loaded.
+	numResolves uint64
+
+	// peeked is whether PeekChunk has already called NextChunk on behalf of
+	// the next NextChunk call. If so, peekedChunk and peekedErr are what that
+	// call returned and peekedSeekPosition is the seekPosition before it.
+	peeked             bool
+	peekedChunk        Chunk
+	peekedErr          error
+	peekedSeekPosition int64
+
+	// resolvePath holds the CSpace offsets of the nodes on the path from the
+	// root to the leaf node, during walkToLeaf.
+	resolvePath []int64
+
+	// src, if non-nil, is the whole RAC file (in CSpace), held in memory. Index
+	// nodes are then sub-sliced from it instead of copied into currNodeBuf.
+	src []byte
+
+	// currNode is the current node, whose children NextChunk returns. It is a
+	// sub-slice of src, rootNodeBuf or currNodeBuf.
 	currNode rNode
+
+	// currNodeBuf is the buffer to hold the current node.
+	currNodeBuf [maxNodeSize]byte
+
+	// rootNode is the root node, kept so that walking the index never needs to
+	// re-load it. It is a sub-slice of src or rootNodeBuf.
+	rootNode    rNode
+	rootNodeBuf [maxNodeSize]byte
+
+	// scratchBuf holds the branch and leaf nodes loaded by walkToLeaf, so that
+	// walking the index does not disturb currNode.
+	scratchBuf [maxNodeSize]byte
 }
 
 func (r *ChunkReader) checkParameters() error {
@@ -370,6 +616,10 @@ func (r *ChunkReader) checkParameters() error {
 		r.err = errInvalidCompressedSize
 		return r.err
 	}
+	if r.BaseOffset < 0 {
+		r.err = errInvalidBaseOffset
+		return r.err
+	}
 	return nil
 }
 
@@ -385,42 +635,70 @@ func (r *ChunkReader) initialize() error {
 	if err := r.checkParameters(); err != nil {
 		return err
 	}
+	r.initReadSeeker()
+
+	if err := r.findRootNode(); err != nil {
+		return err
+	}
+	if r.currNode.version() != 1 {
+		r.err = errUnsupportedRACFileVersion
+		return r.err
+	}
+	return nil
+}
 
+// initReadSeeker sets r.readSeeker (and r.src) from the exported fields.
+func (r *ChunkReader) initReadSeeker() {
 	if ra, ok := r.ReadSeeker.(io.ReaderAt); ok {
+		if r.BaseOffset != 0 {
+			ra = io.NewSectionReader(ra, r.BaseOffset, r.CompressedSize)
+		}
 		r.readSeeker = &readerat.ReadSeeker{
 			ReaderAt: ra,
 			Size:     r.CompressedSize,
 		}
+	} else if r.BaseOffset != 0 {
+		r.readSeeker = &offsetReadSeeker{
+			rs:   r.ReadSeeker,
+			base: r.BaseOffset,
+			size: r.CompressedSize,
+		}
 	} else {
 		r.readSeeker = r.ReadSeeker
 	}
-
-	if err := r.findRootNode(); err != nil {
-		return err
+	if s, ok := r.ReadSeeker.(*bytesReadSeeker); ok &&
+		(r.BaseOffset <= (int64(len(s.b)) - r.CompressedSize)) {
+		r.src = s.b[r.BaseOffset : r.BaseOffset+r.CompressedSize]
 	}
-	if r.currNode.version() != 1 {
-		r.err = errUnsupportedRACFileVersion
-		return r.err
+	if r.Metrics != nil {
+		r.readSeeker = &metricsReadSeeker{
+			rs: r.readSeeker,
+			m:  r.Metrics,
+		}
 	}
-	return nil
 }
 
 func (r *ChunkReader) findRootNode() error {
 	// Look at the start of the compressed file.
-	if _, err := r.readSeeker.Seek(0, io.SeekStart); err != nil {
-		r.err = err
-		return err
-	}
-	if _, err := io.ReadFull(r.readSeeker, r.currNode[:4]); err != nil {
+	b, err := r.readNode(r.currNodeBuf[:], 0, 0, 4)
+	if err != nil {
 		r.err = err
 		return err
 	}
+	r.currNode = b
 	if (r.currNode[0] != magic[0]) ||
 		(r.currNode[1] != magic[1]) ||
 		(r.currNode[2] != magic[2]) {
 		r.err = errInvalidInputMissingMagicBytes
 		return r.err
 	}
+	if (r.SmallIndexThreshold > 0) && (r.src == nil) {
+		// A zero arity in the magic bytes means that the root node (and,
+		// presumably, the rest of the index) is at the end.
+		if err := r.bufferIndex(r.currNode[3] == 0); err != nil {
+			return err
+		}
+	}
 	if found, err := r.tryRootNode(r.currNode[3], false); err != nil {
 		return err
 	} else if found {
@@ -428,23 +706,42 @@ func (r *ChunkReader) findRootNode() error {
 	}
 
 	// Look at the end of the compressed file.
-	if _, err := r.readSeeker.Seek(r.CompressedSize-1, io.SeekStart); err != nil {
-		r.err = err
-		return err
-	}
-	if _, err := io.ReadFull(r.readSeeker, r.currNode[:1]); err != nil {
+	b, err = r.readNode(r.currNodeBuf[:], r.CompressedSize-1, 0, 1)
+	if err != nil {
 		r.err = err
 		return err
 	}
+	r.currNode = b
 	if found, err := r.tryRootNode(r.currNode[0], true); err != nil {
 		return err
 	} else if found {
+		r.rootNodeFromEnd = true
 		return nil
 	}
 
 	return errInvalidInputMissingRootNode
 }
 
+// bufferIndex reads the SmallIndexThreshold bytes at the end (or start) of
+// the RAC file into indexBuf.
+func (r *ChunkReader) bufferIndex(fromEnd bool) error {
+	n := r.SmallIndexThreshold
+	if n > r.CompressedSize {
+		n = r.CompressedSize
+	}
+	cOffset := int64(0)
+	if fromEnd {
+		cOffset = r.CompressedSize - n
+	}
+	buf := make([]byte, n)
+	if err := r.readAt(buf, cOffset); err != nil {
+		r.err = err
+		return err
+	}
+	r.indexBuf, r.indexBufCOffset = buf, cOffset
+	return nil
+}
+
 func (r *ChunkReader) tryRootNode(arity uint8, fromEnd bool) (found bool, ioErr error) {
 	if arity == 0 {
 		return false, nil
@@ -460,19 +757,86 @@ func (r *ChunkReader) tryRootNode(arity uint8, fromEnd bool) (found bool, ioErr
 	if err := r.load(cOffset, arity); err != nil {
 		return false, err
 	}
-	if !r.currNode.valid() {
+	// The arity at the end of the file sized the load, but the node's own
+	// arity, at its start, must agree.
+	if r.currNode[3] != arity {
+		return false, nil
+	}
+	r.Metrics.addNodeValidated()
+	if !r.currNode.valid(r.SkipChecksumVerification) {
 		return false, nil
 	}
 	if r.currNode.cPtrMax() != r.CompressedSize {
 		return false, nil
 	}
+	if r.arityTooLarge(arity) {
+		r.err = &ErrCorruptIndex{NodeCOffset: cOffset, Child: -1}
+		return false, r.err
+	}
+	if i := r.currNode.invalidChild(); i >= 0 {
+		r.err = &ErrCorruptIndex{NodeCOffset: cOffset, Child: i}
+		return false, r.err
+	}
 	r.needToResolveSeekPosition = true
+	if r.src != nil {
+		r.rootNode = r.currNode
+	} else {
+		r.rootNode = append(r.rootNodeBuf[:0], r.currNode...)
+	}
 	r.rootNodeCOffset = cOffset
 	r.rootNodeArity = arity
+	r.rootNodeIsLeaf = true
+	for i := 0; i < int(arity); i++ {
+		if !r.rootNode.isLeaf(i) {
+			r.rootNodeIsLeaf = false
+			break
+		}
+	}
 	r.decompressedSize = r.currNode.dPtrMax()
 	return true, nil
 }
 
+// cloneForIndexWalk returns a new ChunkReader, for walking the index, that
+// shares r's already found and validated root node instead of finding it
+// again. r must be initialized, without error.
+//
+// The clone does not touch r's own fields afterwards, so that it can be used
+// while another goroutine uses r. That is only safe if r.ReadSeeker is an
+// io.ReaderAt. Otherwise, they share a position, which the clone's loadNode
+// calls save and restore.
+func (r *ChunkReader) cloneForIndexWalk() *ChunkReader {
+	c := &ChunkReader{
+		ReadSeeker:               r.ReadSeeker,
+		CompressedSize:           r.CompressedSize,
+		BaseOffset:               r.BaseOffset,
+		SkipChecksumVerification: r.SkipChecksumVerification,
+		Metrics:                  r.Metrics,
+		MaxArity:                 r.MaxArity,
+
+		initialized:               true,
+		rootNodeArity:             r.rootNodeArity,
+		rootNodeIsLeaf:            r.rootNodeIsLeaf,
+		needToResolveSeekPosition: true,
+		decompressedSize:          r.decompressedSize,
+		rootNodeCOffset:           r.rootNodeCOffset,
+		rootNodeFromEnd:           r.rootNodeFromEnd,
+		indexBuf:                  r.indexBuf,
+		indexBufCOffset:           r.indexBufCOffset,
+	}
+	c.initReadSeeker()
+	if c.src != nil {
+		c.rootNode = r.rootNode
+	} else {
+		c.rootNode = append(c.rootNodeBuf[:0], r.rootNode...)
+	}
+	return c
+}
+
+// arityTooLarge returns whether arity exceeds a non-zero r.MaxArity.
+func (r *ChunkReader) arityTooLarge(arity uint8) bool {
+	return (r.MaxArity != 0) && (arity > r.MaxArity)
+}
+
 // load loads a node from the RAC file into r.currNode. It does not check that
 // the result is valid, and the caller should do so if it doesn't already know
 // that it is valid.
@@ -482,62 +846,199 @@ func (r *ChunkReader) load(cOffset int64, arity uint8) error {
 		return r.err
 	}
 	size := nodeSize(arity)
-	if _, err := r.readSeeker.Seek(cOffset, io.SeekStart); err != nil {
+	b, err := r.readNode(r.currNodeBuf[:], cOffset, 0, size)
+	if err != nil {
 		r.err = err
 		return err
 	}
-	if _, err := io.ReadFull(r.readSeeker, r.currNode[:size]); err != nil {
-		r.err = err
+	r.currNode = b
+	return nil
+}
+
+// readNode returns the first hi bytes of the node at cOffset, of which the
+// first lo bytes have already been loaded into buf. For an in-memory RAC file,
+// it returns a sub-slice of r.src and buf is unused, as it is for a node within
+// r.indexBuf. Otherwise, it reads the remaining bytes into buf[lo:hi] and
+// returns buf[:hi].
+//
+// A node that does not fit in buf is an *ErrCorruptIndex error. As an arity is
+// at most 255, that cannot happen for a maxNodeSize buf, but this guards
+// against silently truncating a node if that limit ever changes.
+func (r *ChunkReader) readNode(buf []byte, cOffset int64, lo int, hi int) (rNode, error) {
+	if r.src != nil {
+		if (cOffset < 0) || ((int64(len(r.src)) - int64(hi)) < cOffset) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return r.src[cOffset : cOffset+int64(hi) : cOffset+int64(hi)], nil
+	}
+	if r.indexBuf != nil {
+		if i := cOffset - r.indexBufCOffset; (i >= 0) && (int64(hi) <= (int64(len(r.indexBuf)) - i)) {
+			return r.indexBuf[i : i+int64(hi) : i+int64(hi)], nil
+		}
+		// The first lo bytes may have come from r.indexBuf instead of buf.
+		lo = 0
+	}
+	if hi > len(buf) {
+		return nil, &ErrCorruptIndex{NodeCOffset: cOffset, Child: -1}
+	}
+	if err := r.readAt(buf[lo:hi], cOffset+int64(lo)); err != nil {
+		return nil, err
+	}
+	return buf[:hi], nil
+}
+
+// readAt reads exactly len(p) bytes from the RAC file, starting at cOffset.
+//
+// Reading exactly len(p) bytes is a success (a nil error), even if the source
+// also signaled io.EOF at that boundary. Reading fewer bytes, including zero
+// bytes, is an io.ErrUnexpectedEOF: the RAC file is shorter than its index
+// nodes claim.
+func (r *ChunkReader) readAt(p []byte, cOffset int64) error {
+	if _, err := r.readSeeker.Seek(cOffset, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.ReadFull(r.readSeeker, p)
+	if n == len(p) {
+		return nil
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// bytesReadSeeker is the ReadSeeker of a Reader made by NewReaderBytes. A
+// ChunkReader recognizes it and sub-slices index nodes from b, instead of
+// reading (copying) them.
+type bytesReadSeeker struct {
+	*bytes.Reader
+	b []byte
+}
+
+// offsetReadSeeker presents the part of rs that starts at base as an
+// io.ReadSeeker that starts at zero. Its SeekEnd is relative to size.
+type offsetReadSeeker struct {
+	rs   io.ReadSeeker
+	base int64
+	size int64
+}
+
+// Read implements io.Reader.
+func (o *offsetReadSeeker) Read(p []byte) (int, error) {
+	return o.rs.Read(p)
+}
+
+// Seek implements io.Seeker.
+func (o *offsetReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		offset += o.base
+	case io.SeekEnd:
+		offset, whence = offset+o.base+o.size, io.SeekStart
+	}
+	pos, err := o.rs.Seek(offset, whence)
+	return pos - o.base, err
+}
+
+// loadNode loads and validates the node at cOffset into n. Unlike load, it
+// does not modify r.currNode, r.err or (other than temporarily) the
+// readSeeker's position.
+func (r *ChunkReader) loadNode(n *Node, cOffset int64) error {
+	if err := r.loadNodeWithoutValidation(n, cOffset); err != nil {
+		return err
+	}
+	r.Metrics.addNodeValidated()
+	if !n.b.valid(r.SkipChecksumVerification) {
+		return &ErrCorruptIndex{NodeCOffset: cOffset, Child: -1}
+	}
+	if i := n.b.invalidChild(); i >= 0 {
+		return &ErrCorruptIndex{NodeCOffset: cOffset, Child: i}
+	}
+	return nil
+}
+
+// loadNodeWithoutValidation is like loadNode but only checks that the node's
+// arity, read from its fourth byte, gives a node that fits in the RAC file.
+func (r *ChunkReader) loadNodeWithoutValidation(n *Node, cOffset int64) error {
+	n.cOffset = cOffset
+	if (cOffset < 0) || ((r.CompressedSize - 4) < cOffset) {
+		return &ErrCorruptIndex{NodeCOffset: cOffset, Child: -1}
+	}
+	pos, err := r.readSeeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if n.b, err = r.readNode(n.buf[:], cOffset, 0, 4); err != nil {
+		return err
+	}
+	size := int64(nodeSize(n.b[3]))
+	if (n.b[3] == 0) || ((r.CompressedSize - size) < cOffset) || r.arityTooLarge(n.b[3]) {
+		return &ErrCorruptIndex{NodeCOffset: cOffset, Child: -1}
+	}
+	if n.b, err = r.readNode(n.buf[:], cOffset, 4, int(size)); err != nil {
+		return err
+	}
+	if _, err := r.readSeeker.Seek(pos, io.SeekStart); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *ChunkReader) loadAndValidate(cOffset int64,
+// loadAndValidate loads the node at cOffset into buf (or, for an in-memory
+// RAC file, sub-slices it) and checks that it is valid, both by itself and as
+// the child of a parent node with the given properties.
+func (r *ChunkReader) loadAndValidate(buf []byte, cOffset int64,
 	parentCodec Codec, parentCodecHasMixBit bool, parentVersion uint8, parentCOffMax int64,
-	childCBias int64, childDSize int64) error {
+	childCBias int64, childDSize int64) (rNode, error) {
 
 	if (cOffset < 0) || ((r.CompressedSize - 4) < cOffset) {
 		r.err = errInvalidIndexNode
-		return r.err
-	}
-	if _, err := r.readSeeker.Seek(cOffset, io.SeekStart); err != nil {
-		r.err = err
-		return err
+		return nil, r.err
 	}
-	if _, err := io.ReadFull(r.readSeeker, r.currNode[:4]); err != nil {
+	b, err := r.readNode(buf, cOffset, 0, 4)
+	if err != nil {
 		r.err = err
-		return err
+		return nil, err
 	}
-	arity := r.currNode[3]
+	arity := b[3]
 	if arity == 0 {
 		r.err = errInvalidIndexNode
-		return r.err
+		return nil, r.err
+	}
+	if r.arityTooLarge(arity) {
+		r.err = &ErrCorruptIndex{NodeCOffset: cOffset, Child: -1}
+		return nil, r.err
 	}
 	size := int64(nodeSize(arity))
 	if (r.CompressedSize < size) || ((r.CompressedSize - size) < cOffset) {
 		r.err = errInvalidIndexNode
-		return r.err
+		return nil, r.err
 	}
-	if err := r.load(cOffset, arity); err != nil {
-		return err
+	b, err = r.readNode(buf, cOffset, 0, int(size))
+	if err != nil {
+		r.err = err
+		return nil, err
 	}
 
-	if !r.currNode.valid() {
+	r.Metrics.addNodeValidated()
+	if !b.valid(r.SkipChecksumVerification) {
 		r.err = errInvalidIndexNode
-		return r.err
+		return nil, r.err
+	}
+	if i := b.invalidChild(); i >= 0 {
+		r.err = &ErrCorruptIndex{NodeCOffset: cOffset, Child: i}
+		return nil, r.err
 	}
 
 	// Validate the parent and child codec, version, COffMax and DOffMax.
-	childVersion := r.currNode.version()
-	if !parentChildCodecsValid(parentCodec, r.currNode.codec(), parentCodecHasMixBit) ||
+	childVersion := b.version()
+	if !parentChildCodecsValid(parentCodec, b.codec(), parentCodecHasMixBit) ||
 		(parentVersion < childVersion) ||
-		(parentCOffMax < (childCBias + r.currNode.cPtrMax())) ||
-		(childDSize != r.currNode.dPtrMax()) {
+		(parentCOffMax < (childCBias + b.cPtrMax())) ||
+		(childDSize != b.dPtrMax()) {
 		r.err = errInvalidIndexNode
-		return r.err
+		return nil, r.err
 	}
-	return nil
+	return b, nil
 }
 
 // DecompressedSize returns the total size of the decompressed data.
@@ -562,9 +1063,72 @@ func (r *ChunkReader) SeekToChunkContaining(dSpaceOffset int64) error {
 	}
 	r.needToResolveSeekPosition = true
 	r.seekPosition = dSpaceOffset
+	r.peeked = false
 	return nil
 }
 
+// ChunkContaining returns the chunk containing dSpaceOffset, or io.EOF if
+// dSpaceOffset is at or past the end of the decompressed data. Unlike
+// SeekToChunkContaining, it does not change what NextChunk returns, so it can
+// be interleaved with a NextChunk scan.
+//
+// In BestEffort mode, a corrupt subtree on the way to that chunk is an error
+// for this call only.
+func (r *ChunkReader) ChunkContaining(dSpaceOffset int64) (Chunk, error) {
+	if err := r.initialize(); err != nil {
+		return Chunk{}, err
+	}
+	if dSpaceOffset < 0 {
+		return Chunk{}, errSeekToNegativePosition
+	} else if dSpaceOffset >= r.decompressedSize {
+		return Chunk{}, io.EOF
+	}
+	p, _, err := r.walkToLeaf(dSpaceOffset)
+	if err != nil {
+		if r.BestEffort && isCorruptIndex(err) {
+			r.err = nil
+		}
+		return Chunk{}, err
+	}
+	return p.node.chunk(p.i, p.cBias, p.dBias), nil
+}
+
+// SeekToChunkIndex sets up NextChunk to return the n'th (0-based) non-empty
+// chunk, in DSpace order. It returns an error, and leaves the position
+// unchanged, if there are n or fewer non-empty chunks.
+//
+// It walks the chunks from the start of DSpace, so it takes O(n) time.
+func (r *ChunkReader) SeekToChunkIndex(n int64) error {
+	if err := r.initialize(); err != nil {
+		return err
+	}
+	if n < 0 {
+		return errInvalidChunkIndex
+	}
+	pos := r.seekPosition
+	if r.peeked {
+		pos = r.peekedSeekPosition
+	}
+
+	if err := r.SeekToChunkContaining(0); err != nil {
+		return err
+	}
+	for i := int64(0); ; i++ {
+		c, err := r.NextChunk()
+		if err == io.EOF {
+			if err := r.SeekToChunkContaining(pos); err != nil {
+				return err
+			}
+			return errInvalidChunkIndex
+		} else if err != nil {
+			return err
+		}
+		if i == n {
+			return r.SeekToChunkContaining(c.DRange[0])
+		}
+	}
+}
+
 // NextChunk returns the next independently compressed chunk, or io.EOF if
 // there are no more chunks.
 //
@@ -574,6 +1138,10 @@ func (r *ChunkReader) NextChunk() (Chunk, error) {
 	if err := r.initialize(); err != nil {
 		return Chunk{}, err
 	}
+	if r.peeked {
+		r.peeked = false
+		return r.peekedChunk, r.peekedErr
+	}
 	for {
 		if r.needToResolveSeekPosition {
 			if r.seekPosition >= r.decompressedSize {
@@ -583,8 +1151,17 @@ func (r *ChunkReader) NextChunk() (Chunk, error) {
 			if err := r.resolveSeekPosition(); err != nil {
 				return Chunk{}, err
 			}
+			if r.needToResolveSeekPosition {
+				// A corrupt subtree was skipped (in BestEffort mode).
+				continue
+			}
 		}
 		for n := int32(r.currNode.arity()); r.nextChunk < n; {
+			if !r.currNode.isLeaf(int(r.nextChunk)) {
+				// Descend into the branch node, which starts at the current
+				// seekPosition, by re-walking from the root.
+				break
+			}
 			c := r.currNode.chunk(int(r.nextChunk), r.currNodeCBias, r.currNodeDBias)
 			r.nextChunk++
 			r.seekPosition = c.DRange[1]
@@ -596,43 +1173,540 @@ func (r *ChunkReader) NextChunk() (Chunk, error) {
 	}
 }
 
-func (r *ChunkReader) resolveSeekPosition() error {
-	// Load the root node. It has already been validated, during initialize.
-	if err := r.load(r.rootNodeCOffset, r.rootNodeArity); err != nil {
+// SkipChunks advances past the next n non-empty chunks, as if by calling
+// NextChunk n times, but without constructing each Chunk. Within a leaf node,
+// it only compares DOffs, so that it walks the index once per leaf node
+// instead of once per chunk.
+//
+// It returns io.EOF, positioned at the end, if fewer than n non-empty chunks
+// remain.
+func (r *ChunkReader) SkipChunks(n int64) error {
+	if err := r.initialize(); err != nil {
 		return err
 	}
+	if n < 0 {
+		return errInvalidChunkIndex
+	}
+	if r.peeked && (n > 0) {
+		r.peeked = false
+		if r.peekedErr != nil {
+			return r.peekedErr
+		}
+		n--
+	}
+	for n > 0 {
+		if r.needToResolveSeekPosition {
+			if r.seekPosition >= r.decompressedSize {
+				return io.EOF
+			}
+			r.needToResolveSeekPosition = false
+			if err := r.resolveSeekPosition(); err != nil {
+				return err
+			}
+			if r.needToResolveSeekPosition {
+				// A corrupt subtree was skipped (in BestEffort mode).
+				continue
+			}
+		}
+		for arity := int32(r.currNode.arity()); (n > 0) && (r.nextChunk < arity); {
+			i := int(r.nextChunk)
+			if !r.currNode.isLeaf(i) {
+				break
+			}
+			dRange := r.currNode.dOffRange(i, r.currNodeDBias)
+			r.nextChunk++
+			r.seekPosition = dRange[1]
+			if !dRange.Empty() {
+				n--
+			}
+		}
+		if n > 0 {
+			r.needToResolveSeekPosition = true
+		}
+	}
+	return nil
+}
 
-	// Walk the branch nodes until we find the leaf node containing the
-	// seekPosition.
+// PeekChunk returns what the next NextChunk call will return, without
+// consuming it: the NextChunk call after a PeekChunk call returns the same
+// chunk (or error), as do repeated PeekChunk calls. At the end of the chunks,
+// it returns io.EOF, repeatedly.
+//
+// Calling SeekToChunkContaining discards the peeked chunk.
+func (r *ChunkReader) PeekChunk() (Chunk, error) {
+	if !r.peeked {
+		pos := r.seekPosition
+		c, err := r.NextChunk()
+		r.peeked = true
+		r.peekedChunk = c
+		r.peekedErr = err
+		r.peekedSeekPosition = pos
+	}
+	return r.peekedChunk, r.peekedErr
+}
+
+// NextChunkCodec returns the Codec of the chunk that the next NextChunk call
+// will return, resolving the seek position if needed, without consuming that
+// chunk. For a file whose leaf nodes use different Codecs, it is that chunk's
+// leaf node's Codec. At the end of the chunks, it returns io.EOF.
+//
+// It is equivalent to the Codec field of PeekChunk's result.
+func (r *ChunkReader) NextChunkCodec() (Codec, error) {
+	c, err := r.PeekChunk()
+	if err != nil {
+		return CodecInvalid, err
+	}
+	return c.Codec, nil
+}
+
+// NextChunkIsLast returns whether the chunk most recently returned by
+// NextChunk is the last (non-empty) one: whether the next NextChunk call will
+// return io.EOF. Streaming consumers can use it to finalize (e.g. flush or
+// finish a hash) without waiting for that io.EOF.
+//
+// It does not traverse the index. NextChunk leaves seekPosition at the end of
+// the returned chunk's DRange, so it is a comparison with the decompressed
+// size. After a PeekChunk call, it is whether that call returned io.EOF.
+func (r *ChunkReader) NextChunkIsLast() bool {
+	if r.peeked {
+		return r.peekedErr == io.EOF
+	}
+	return r.initialized && (r.err == nil) && (r.seekPosition >= r.decompressedSize)
+}
+
+// SkippedRanges returns the DRanges of the corrupt subtrees that NextChunk has
+// skipped so far. It is always empty unless BestEffort is set.
+func (r *ChunkReader) SkippedRanges() []Range {
+	return r.skippedRanges
+}
+
+// ValidationErrors returns the corrupt index errors that NextChunk has found
+// so far. NextChunk validates every index node that it loads, so a scan that
+// visits every chunk also validates the whole index, without a separate pass.
+//
+// In BestEffort mode, the scan continues past each corrupt subtree and there
+// is one error per SkippedRanges element. Otherwise, the scan stops at the
+// first one, which is also the sticky error that NextChunk returns.
+func (r *ChunkReader) ValidationErrors() []error {
+	if (r.err != nil) && isCorruptIndex(r.err) {
+		return append(r.skippedErrs[:len(r.skippedErrs):len(r.skippedErrs)], r.err)
+	}
+	return r.skippedErrs
+}
+
+// RootNodeLocation returns the CSpace offset of the root node and whether it
+// was found at the end of the RAC file (as written with IndexLocationAtEnd)
+// instead of at the start (as written with IndexLocationAtStart). The start
+// is tried first, so a file that could be read either way reports the start.
+func (r *ChunkReader) RootNodeLocation() (offset int64, fromEnd bool, err error) {
+	if err := r.initialize(); err != nil {
+		return 0, false, err
+	}
+	return r.rootNodeCOffset, r.rootNodeFromEnd, nil
+}
+
+// IndexExtent returns the CSpace range spanned by the RAC file's index nodes:
+// the smallest Range that contains the root node and every branch and leaf
+// node. For files written by this package's Writer, it is either a prefix or
+// a suffix of the RAC file.
+func (r *ChunkReader) IndexExtent() (Range, error) {
+	extent := Range{}
+	err := r.walkIndex(func(n *Node, cBias int64) error {
+		nodeEnd := n.cOffset + int64(nodeSize(n.b[3]))
+		if extent.Empty() {
+			extent = Range{n.cOffset, nodeEnd}
+			return nil
+		}
+		if extent[0] > n.cOffset {
+			extent[0] = n.cOffset
+		}
+		if extent[1] < nodeEnd {
+			extent[1] = nodeEnd
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return Range{}, err
+	}
+	return extent, nil
+}
+
+// IndexStats returns the number of index nodes and their average fanout: the
+// total number of children, not counting Codec Entries, divided by the number
+// of branch nodes, the index nodes that have at least one branch child. An
+// index with a single node has no branch nodes, and its fanout is that node's
+// number of children. A small fanout, such as about 2 for a chain of nodes
+// that each hold one chunk and one branch child, means that the index is
+// degenerate, more like a linked list than a tree, and random access will be
+// slow.
+func (r *ChunkReader) IndexStats() (numNodes int64, averageFanout float64, err error) {
+	numChildren, numBranchNodes := int64(0), int64(0)
+	err = r.walkIndex(func(n *Node, cBias int64) error {
+		numNodes++
+		isBranch := false
+		for i, arity := 0, n.b.arity(); i < arity; i++ {
+			switch n.b.tTag(i) {
+			case 0xFD:
+				continue
+			case 0xFE:
+				isBranch = true
+			}
+			numChildren++
+		}
+		if isBranch {
+			numBranchNodes++
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if numBranchNodes == 0 {
+		numBranchNodes = 1
+	}
+	return numNodes, float64(numChildren) / float64(numBranchNodes), nil
+}
+
+// walkIndex calls visit for every index node, each visited once, starting
+// with the root node. The *Node passed to visit is only valid during that
+// call, and cBias is the CBias that applies to its children's CSpace offsets.
+// A non-nil error from visit stops the walk and is returned.
+//
+// A node that fails to load also stops the walk. Its error is returned, or if
+// loadFailed is non-nil, loadFailed's result is returned instead. Either of
+// visit and loadFailed may be nil. It does not change the position for
+// subsequent NextChunk calls.
+func (r *ChunkReader) walkIndex(
+	visit func(n *Node, cBias int64) error,
+	loadFailed func(cOffset int64, cBias int64, err error) error) error {
+
+	if err := r.initialize(); err != nil {
+		return err
+	}
+
+	type pending struct {
+		cOffset int64
+		cBias   int64
+	}
+	stack := []pending{{r.rootNodeCOffset, 0}}
+	visited := map[int64]bool{}
+	n := &Node{}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[p.cOffset] {
+			continue
+		}
+		visited[p.cOffset] = true
+
+		if err := r.loadNode(n, p.cOffset); err != nil {
+			r.err = err
+			if loadFailed != nil {
+				return loadFailed(p.cOffset, p.cBias, err)
+			}
+			return err
+		}
+		if visit != nil {
+			if err := visit(n, p.cBias); err != nil {
+				return err
+			}
+		}
+
+		for i, arity := 0, n.b.arity(); i < arity; i++ {
+			if n.b.isLeaf(i) {
+				continue
+			}
+			childCBias := p.cBias
+			if sTag := int(n.b.sTag(i)); sTag < arity {
+				childCBias = n.b.cOff(sTag, p.cBias)
+			}
+			stack = append(stack, pending{n.b.cOff(i, p.cBias), childCBias})
+		}
+	}
+	return nil
+}
+
+// VerifyCodecHierarchy walks the whole index and checks every branch child's
+// Codec against its parent's: unless the parent's Codec has the Mix Bit set,
+// they must be equal. NextChunk only checks the nodes on the paths that it
+// walks, so this audits a file (e.g. one produced by a third party encoder)
+// up front. It does not change the position for subsequent NextChunk calls.
+//
+// On a violation, it returns an *ErrCorruptIndex whose NodeCOffset and Child
+// identify the parent node and the offending child.
+func (r *ChunkReader) VerifyCodecHierarchy() error {
+	// A node can be the child of more than one parent, but walkIndex visits it
+	// only once, so collect every parent-child edge and check them afterwards.
+	type edge struct {
+		parentCOffset        int64
+		child                int
+		childCOffset         int64
+		parentCodec          Codec
+		parentCodecHasMixBit bool
+	}
+	edges := []edge(nil)
+	codecs := map[int64]Codec{}
+	err := r.walkIndex(func(n *Node, cBias int64) error {
+		codecs[n.cOffset] = n.b.codec()
+		for i, arity := 0, n.b.arity(); i < arity; i++ {
+			if !n.b.isLeaf(i) {
+				edges = append(edges, edge{
+					n.cOffset, i, n.b.cOff(i, cBias), n.b.codec(), n.b.codecHasMixBit(),
+				})
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range edges {
+		if !parentChildCodecsValid(e.parentCodec, codecs[e.childCOffset], e.parentCodecHasMixBit) {
+			return &ErrCorruptIndex{NodeCOffset: e.parentCOffset, Child: e.child}
+		}
+	}
+	return nil
+}
+
+// AllChunks walks the whole index and returns every chunk, in DSpace order.
+// Unlike NextChunk, it does not skip empty chunks, such as the shared
+// resources that other chunks' CSecondary and CTertiary ranges refer to, so
+// that a tool can re-encode the RAC file faithfully, e.g. with a different
+// tree shape. It does not return Codec Entries, which are not chunks. It does
+// not change the position for subsequent NextChunk calls.
+func (r *ChunkReader) AllChunks() ([]Chunk, error) {
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
+
+	ret := []Chunk(nil)
+	// Track the path's CSpace offsets, as walkToLeaf does, so that a branch
+	// node that refers back to an ancestor is an error instead of an infinite
+	// loop.
+	path := []int64(nil)
+	var walk func(cOffset int64, cBias int64, dBias int64) error
+	walk = func(cOffset int64, cBias int64, dBias int64) error {
+		n := &Node{}
+		if err := r.loadNode(n, cOffset); err != nil {
+			return err
+		}
+		path = append(path, cOffset)
+		for i, arity := 0, n.b.arity(); i < arity; i++ {
+			if n.b.tTag(i) == 0xFD {
+				continue
+			} else if n.b.isLeaf(i) {
+				ret = append(ret, n.b.chunk(i, cBias, dBias))
+				continue
+			}
+			childCOffset := n.b.cOff(i, cBias)
+			for _, ancestor := range path {
+				if ancestor == childCOffset {
+					return &ErrCorruptIndex{NodeCOffset: cOffset, Child: i}
+				}
+			}
+			childCBias := cBias
+			if sTag := int(n.b.sTag(i)); sTag < arity {
+				childCBias = n.b.cOff(sTag, cBias)
+			}
+			if err := walk(childCOffset, childCBias, n.b.dOff(i, dBias)); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		return nil
+	}
+	if err := walk(r.rootNodeCOffset, 0, 0); err != nil {
+		r.err = err
+		return nil, err
+	}
+	return ret, nil
+}
+
+// IsStandalone walks the whole index and returns whether the RAC file can be
+// decompressed on its own, without external inputs: whether every chunk's
+// CSecondary and CTertiary ranges, such as a shared dictionary, start within
+// the file's own CSpace range. It does not change the position for subsequent
+// NextChunk calls.
+//
+// A node that passes validation can never refer outside of that range, so
+// the check only matters for nodes that fail validation. IsStandalone re-reads
+// such a node, without validating it, to tell an external reference (a false
+// result and a nil error) apart from other corruption or I/O failures (a
+// non-nil error).
+func (r *ChunkReader) IsStandalone() (bool, error) {
+	return r.standalone(r.walkIndex(nil, r.externalReferenceOr))
+}
+
+// standalone returns the IsStandalone result for err, returned by walkIndex
+// or by initialize. If initialize did not find the root node, it re-reads both
+// places, the start and the end of the file, that findRootNode looks at.
+func (r *ChunkReader) standalone(err error) (bool, error) {
+	if err == errInvalidInputMissingRootNode {
+		err = r.externalReferenceOr(0, 0, err)
+	}
+	if err == errInvalidInputMissingRootNode {
+		b, ioErr := r.readNode(r.currNodeBuf[:], r.CompressedSize-1, 0, 1)
+		if ioErr != nil {
+			return false, ioErr
+		} else if arity := b[0]; arity != 0 {
+			err = r.externalReferenceOr(r.CompressedSize-int64(nodeSize(arity)), 0, err)
+		}
+	}
+	if err == errExternalReference {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// externalReferenceOr returns errExternalReference if err means that the node
+// at cOffset is corrupt and that node, re-read without validation, has a chunk
+// whose CSecondary or CTertiary range starts past the end of the RAC file.
+// Otherwise, it returns err, or an I/O error from the re-read.
+func (r *ChunkReader) externalReferenceOr(cOffset int64, cBias int64, err error) error {
+	if !isCorruptIndex(err) && (err != errInvalidInputMissingRootNode) {
+		return err
+	}
+	n := &Node{}
+	if ioErr := r.loadNodeWithoutValidation(n, cOffset); ioErr != nil {
+		if isCorruptIndex(ioErr) {
+			return err
+		}
+		return ioErr
+	}
+	b := n.b
+	if (b[0] != magic[0]) || (b[1] != magic[1]) || (b[2] != magic[2]) || (b[len(b)-1] != b[3]) {
+		return err
+	}
+	for i, arity := 0, b.arity(); i < arity; i++ {
+		if tTag := b.tTag(i); (tTag == 0xFD) || (tTag == 0xFE) {
+			continue
+		}
+		for _, j := range [2]uint8{b.sTag(i), b.tTag(i)} {
+			if (int(j) < arity) && (r.CompressedSize < b.cOff(int(j), cBias)) {
+				return errExternalReference
+			}
+		}
+	}
+	return err
+}
+
+// isCorruptIndex returns whether err, returned by loadAndValidate, means that
+// the node is corrupt (as opposed to e.g. a network error).
+func isCorruptIndex(err error) bool {
+	if _, ok := err.(*ErrCorruptIndex); ok {
+		return true
+	}
+	return (err == errInvalidIndexNode) || (err == io.ErrUnexpectedEOF)
+}
+
+func (r *ChunkReader) resolveSeekPosition() error {
+	if r.rootNodeIsLeaf {
+		r.currNode = r.rootNode
+		r.nextChunk = int32(r.rootNode.findChunkContaining(r.seekPosition, 0))
+		r.currNodeCBias = 0
+		r.currNodeDBias = 0
+		r.numResolves++
+		return nil
+	}
+
+	p, skipped, err := r.walkToLeaf(r.seekPosition)
+	if err != nil {
+		if !r.BestEffort || !isCorruptIndex(err) {
+			return err
+		}
+		r.err = nil
+		r.skippedRanges = append(r.skippedRanges, skipped)
+		r.skippedErrs = append(r.skippedErrs, err)
+		r.seekPosition = skipped[1]
+		r.needToResolveSeekPosition = true
+		return nil
+	}
+
+	if (len(p.node) > 0) && (&p.node[0] == &r.scratchBuf[0]) {
+		r.currNode = append(r.currNodeBuf[:0], p.node...)
+	} else {
+		r.currNode = p.node
+	}
+	r.nextChunk = int32(p.i)
+	r.currNodeCBias = p.cBias
+	r.currNodeDBias = p.dBias
+	r.numResolves++
+	return nil
+}
+
+// forEachRemainingChunk calls fn for each non-empty chunk in currNode that
+// NextChunk has not yet returned.
+func (r *ChunkReader) forEachRemainingChunk(fn func(Chunk)) {
+	for i, n := int(r.nextChunk), r.currNode.arity(); i < n; i++ {
+		if !r.currNode.isLeaf(i) {
+			continue
+		}
+		if c := r.currNode.chunk(i, r.currNodeCBias, r.currNodeDBias); !c.DRange.Empty() {
+			fn(c)
+		}
+	}
+}
+
+// leafPosition is where walkToLeaf found a DSpace offset: the i'th child of
+// the leaf node, whose CBias and DBias are cBias and dBias.
+type leafPosition struct {
+	node  rNode
+	i     int
+	cBias int64
+	dBias int64
+}
+
+// walkToLeaf walks the index from the root node to the leaf node containing
+// dOff, which must be in the range [0, decompressedSize). The branch and leaf
+// nodes on the way are loaded into r.scratchBuf: it does not modify
+// r.currNode or the other NextChunk state.
+//
+// If a node on the way is corrupt then, along with the error, it returns the
+// DRange of that node's subtree, which BestEffort mode skips.
+func (r *ChunkReader) walkToLeaf(dOff int64) (p leafPosition, skipped Range, err error) {
+	// Track the path's CSpace offsets, so that a malicious file whose branch
+	// node refers back to an ancestor is an error instead of an infinite
+	// loop.
+	node := r.rootNode
+	cOffset := r.rootNodeCOffset
 	cBias := int64(0)
 	dBias := int64(0)
+	r.resolvePath = append(r.resolvePath[:0], cOffset)
 	for {
-		i := r.currNode.findChunkContaining(r.seekPosition, dBias)
-		if r.currNode.isLeaf(i) {
-			r.nextChunk = int32(i)
-			r.currNodeCBias = cBias
-			r.currNodeDBias = dBias
-			return nil
+		i := node.findChunkContaining(dOff, dBias)
+		if node.isLeaf(i) {
+			return leafPosition{node, i, cBias, dBias}, Range{}, nil
 		}
 
-		parentCodec := r.currNode.codec()
-		parentCodecHasMixBit := r.currNode.codecHasMixBit()
-		parentVersion := r.currNode.version()
-		parentCOffMax := cBias + r.currNode.cPtrMax()
-		childCOffset := r.currNode.cOff(i, cBias)
+		parentCodec := node.codec()
+		parentCodecHasMixBit := node.codecHasMixBit()
+		parentVersion := node.version()
+		parentCOffMax := cBias + node.cPtrMax()
+		childCOffset := node.cOff(i, cBias)
 		childCBias := cBias
-		if sTag := int(r.currNode.sTag(i)); sTag < r.currNode.arity() {
-			childCBias = r.currNode.cOff(sTag, cBias)
+		if sTag := int(node.sTag(i)); sTag < node.arity() {
+			childCBias = node.cOff(sTag, cBias)
 		}
-		childDBias := r.currNode.dOff(i, dBias)
-		childDSize := r.currNode.dSize(i)
-
-		if err := r.loadAndValidate(childCOffset,
+		childDBias := node.dOff(i, dBias)
+		childDSize := node.dSize(i)
+		childDRange := Range{childDBias, childDBias + childDSize}
+
+		for _, ancestor := range r.resolvePath {
+			if ancestor == childCOffset {
+				r.err = &ErrCorruptIndex{NodeCOffset: cOffset, Child: i}
+				return leafPosition{}, childDRange, r.err
+			}
+		}
+		node, err = r.loadAndValidate(r.scratchBuf[:], childCOffset,
 			parentCodec, parentCodecHasMixBit, parentVersion, parentCOffMax,
-			childCBias, childDSize); err != nil {
-			return err
+			childCBias, childDSize)
+		if err != nil {
+			return leafPosition{}, childDRange, err
 		}
 
+		cOffset = childCOffset
+		r.resolvePath = append(r.resolvePath, cOffset)
 		cBias = childCBias
 		dBias = childDBias
 	}
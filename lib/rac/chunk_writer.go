@@ -16,7 +16,6 @@ package rac
 
 import (
 	"errors"
-	"hash/crc32"
 	"io"
 	"sort"
 )
@@ -123,6 +122,16 @@ type ChunkWriter struct {
 	// each chunk's starting offset will be aligned to a page boundary.
 	CPageSize uint64
 
+	// TargetArity is the maximum arity (the number of children, including
+	// shared resources and Codec Entries) of each index node. Smaller values
+	// give a deeper tree, with smaller nodes: each seek reads more nodes but
+	// fewer bytes per node.
+	//
+	// It must either be zero (which means 255, the largest arity that the RAC
+	// format allows) or at least 4, so that a node can hold a chunk, its two
+	// resources and a Codec Entry.
+	TargetArity uint8
+
 	// initialized is set true after the first AddXxx call.
 	initialized bool
 
@@ -154,6 +163,10 @@ type ChunkWriter struct {
 	// OptResource means unused.
 	resourcesCOffCLens []uint64
 
+	// dictionaries maps the contents of each resource added by AddDictionary
+	// to its OptResource.
+	dictionaries map[string]OptResource
+
 	// leafNodes are the non-resource leaf nodes of the hierarchical index.
 	leafNodes []wNode
 
@@ -170,6 +183,10 @@ func (w *ChunkWriter) checkParameters() error {
 		w.err = errInvalidWriter
 		return w.err
 	}
+	if (0 < w.TargetArity) && (w.TargetArity < 4) {
+		w.err = errInvalidTargetArity
+		return w.err
+	}
 	if !isZeroOrAPowerOf2(w.CPageSize) || (w.CPageSize > MaxSize) {
 		w.err = errInvalidCPageSize
 		return w.err
@@ -334,6 +351,27 @@ func (w *ChunkWriter) AddResource(resource []byte) (OptResource, error) {
 	return id, nil
 }
 
+// AddDictionary is like AddResource, except that adding the same bytes again
+// returns the same OptResource instead of writing another copy. Many chunks
+// can then share a dictionary, each passing its handle to AddChunk, while the
+// RAC file holds the dictionary once.
+//
+// Only resources added by AddDictionary (not AddResource) are deduplicated.
+func (w *ChunkWriter) AddDictionary(dictionary []byte) (OptResource, error) {
+	if id, ok := w.dictionaries[string(dictionary)]; ok {
+		return id, nil
+	}
+	id, err := w.AddResource(dictionary)
+	if err != nil {
+		return 0, err
+	}
+	if w.dictionaries == nil {
+		w.dictionaries = map[string]OptResource{}
+	}
+	w.dictionaries[string(dictionary)] = id
+	return id, nil
+}
+
 // AddChunk adds a chunk of compressed data - the (primary, secondary,
 // tertiary) tuple - to the RAC file. Decompressing that chunk should produce
 // dRangeSize bytes, although the ChunkWriter does not attempt to verify that.
@@ -426,7 +464,11 @@ func (w *ChunkWriter) Close() error {
 		_, err := w.Writer.Write(emptyRACFile[:])
 		return err
 	}
-	rootNode := gather(w.leafNodes, w.codec.isLong())
+	maxArity := 0xFF
+	if w.TargetArity != 0 {
+		maxArity = int(w.TargetArity)
+	}
+	rootNode := gather(w.leafNodes, w.codec.isLong(), maxArity)
 	indexSize := rootNode.calcEncodedSize(0, w.IndexLocation == IndexLocationAtEnd)
 
 	nw := &nodeWriter{
@@ -648,8 +690,7 @@ func (w *nodeWriter) writeIndex(n *wNode, rootAndIsAtEnd bool) error {
 
 	// Checksum.
 	size := (arity * 16) + 16
-	checksum := crc32.ChecksumIEEE(w.buffer[6:size])
-	checksum ^= checksum >> 16
+	checksum := nodeChecksum(w.buffer[:])
 	w.buffer[4] = uint8(checksum >> 0)
 	w.buffer[5] = uint8(checksum >> 8)
 
@@ -696,8 +737,9 @@ func resourceToTag(resources []int, r OptResource) uint64 {
 }
 
 // gather brings the given nodes into a tree, such that every branch node's
-// arity (the count of its resource and non-resource child nodes) is at most
-// 0xFF. It returns the root of that tree.
+// arity (the count of its resource and non-resource child nodes, plus any
+// Codec Entry) is at most maxArity, which is at least 4 and at most 0xFF. It
+// returns the root of that tree.
 //
 // TODO: it currently builds a tree where all leaf nodes have equal depth. For
 // small RAC files (with only one branch node: the mandatory root node), this
@@ -708,19 +750,19 @@ func resourceToTag(resources []int, r OptResource) uint64 {
 // children, as those leaves presumably contain the most commonly accessed
 // parts of the decompressed file.
 //
-// If doing this TODO, we'd also have to change the "codec = codecMixBit |
-// CodecZeroes" line below, as it assumes that no branch nodes have both branch
-// node children and leaf node children.
-func gather(nodes []wNode, codecIsLong bool) wNode {
+// If doing this TODO, we'd also have to change makeBranch's use of
+// Codec.Union, whose "codecMixBit | CodecZeroes" result assumes that no branch
+// nodes have both branch node children and leaf node children.
+func gather(nodes []wNode, codecIsLong bool, maxArity int) wNode {
 	if len(nodes) == 0 {
 		panic("gather: no nodes")
 	}
 
 	resources := map[OptResource]bool{}
 
-	arityBudget := 0xFF
+	arityBudget := maxArity
 	if codecIsLong {
-		arityBudget = 0xFE
+		arityBudget--
 	}
 
 	for {
@@ -777,14 +819,14 @@ func makeBranch(children []wNode, resMap map[OptResource]bool) wNode {
 		dRangeSize += c.dRangeSize
 		if i == 0 {
 			codec = c.codec
-		} else if codec != c.codec {
+		} else {
 			// We construct the node tree so that a branch node's children are
 			// either all branch nodes or all leaf nodes. If they are all leaf
 			// nodes (with the same Codec), or all branch nodes with the same
 			// Codec, the parent uses the same Codec. If they are all branch
-			// nodes, with different Codecs, we set the Mix bit and since it
-			// has no leaf nodes, we might as well use CodecZeroes.
-			codec = codecMixBit | CodecZeroes
+			// nodes, with different Codecs, Union sets the Mix bit and since
+			// it has no leaf nodes, we might as well use CodecZeroes.
+			codec = codec.Union(c.codec)
 		}
 	}
 
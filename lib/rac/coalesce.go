@@ -0,0 +1,72 @@
+// Copyright 2020 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+// +build go1.23
+
+package rac
+
+import (
+	"iter"
+)
+
+// CoalescedChunks returns an iterator over r's chunks, in DSpace order,
+// grouped into runs of consecutive chunks whose combined DRange size is at
+// most maxSize. A chunk larger than maxSize is a group by itself, as is every
+// chunk if maxSize is non-positive.
+//
+// A group never spans a change of Codec or of the CSecondary or CTertiary
+// ranges (the shared dictionaries that the chunks' STag and TTag refer to), so
+// that each group can be decompressed with one set of codec state.
+//
+// It walks the index, without decompressing anything, before yielding the
+// first group. An error is yielded once, with a nil group, after which the
+// iteration stops. It does not change the position for subsequent Read calls.
+func (r *Reader) CoalescedChunks(maxSize int64) iter.Seq2[[]Chunk, error] {
+	return func(yield func([]Chunk, error) bool) {
+		dSize, err := r.DecompressedSize()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		chunks, err := r.ChunksInRange(Range{0, dSize})
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for i, j := 0, 0; i < len(chunks); i = j {
+			size := chunks[i].DRange.Size()
+			for j = i + 1; j < len(chunks); j++ {
+				if !canCoalesce(chunks[j-1], chunks[j]) ||
+					((maxSize - size) < chunks[j].DRange.Size()) {
+					break
+				}
+				size += chunks[j].DRange.Size()
+			}
+			if !yield(chunks[i:j:j], nil) {
+				return
+			}
+		}
+	}
+}
+
+// canCoalesce returns whether the chunk d, which immediately follows c, can be
+// in the same group as c.
+func canCoalesce(c Chunk, d Chunk) bool {
+	return (c.DRange[1] == d.DRange[0]) &&
+		(c.Codec == d.Codec) &&
+		(c.CSecondary == d.CSecondary) &&
+		(c.CTertiary == d.CTertiary)
+}
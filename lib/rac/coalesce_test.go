@@ -0,0 +1,103 @@
+// Copyright 2020 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+// +build go1.23
+
+package rac
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReaderCoalescedChunks(tt *testing.T) {
+	const numChunks, chunkSize = 40, 1024
+
+	// The first 20 chunks have no shared dictionary. The last 20 have one.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	res, err := w.AddResource([]byte("dictionary"))
+	if err != nil {
+		tt.Fatalf("AddResource: %v", err)
+	}
+	data := make([]byte, chunkSize)
+	for i := 0; i < numChunks; i++ {
+		secondary := OptResource(0)
+		if i >= 20 {
+			secondary = res
+		}
+		if err := w.AddChunk(chunkSize, storedCodec, data, secondary, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	testCases := []struct {
+		maxSize        int64
+		wantGroupSizes []int
+	}{
+		{16 * 1024, []int{16, 4, 16, 4}},
+		{16*1024 - 1, []int{15, 5, 15, 5}},
+		{1024 * 1024, []int{20, 20}},
+		{0, []int{
+			1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+			1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		}},
+	}
+
+	for _, tc := range testCases {
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+		}
+		gotGroupSizes := []int(nil)
+		dPos := int64(0)
+		for group, err := range r.CoalescedChunks(tc.maxSize) {
+			if err != nil {
+				tt.Fatalf("maxSize=%d: %v", tc.maxSize, err)
+			}
+			gotGroupSizes = append(gotGroupSizes, len(group))
+			for _, c := range group {
+				if c.DRange[0] != dPos {
+					tt.Fatalf("maxSize=%d: DRange: got %v, want one starting at %d",
+						tc.maxSize, c.DRange, dPos)
+				}
+				dPos = c.DRange[1]
+			}
+		}
+		if !reflect.DeepEqual(gotGroupSizes, tc.wantGroupSizes) {
+			tt.Errorf("maxSize=%d: group sizes: got %v, want %v",
+				tc.maxSize, gotGroupSizes, tc.wantGroupSizes)
+		}
+		if dPos != numChunks*chunkSize {
+			tt.Errorf("maxSize=%d: coverage: got %d, want %d", tc.maxSize, dPos, numChunks*chunkSize)
+		}
+	}
+
+	// Breaking out of the loop early is fine.
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	for range r.CoalescedChunks(16 * 1024) {
+		break
+	}
+}
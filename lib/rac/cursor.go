@@ -0,0 +1,108 @@
+// Copyright 2020 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rac
+
+import (
+	"io"
+)
+
+// A cursor is 17 bytes long: a version byte (cursorVersion), the DSpace
+// position and then the RAC file's decompressed size. The last is only used to
+// reject restoring a cursor saved against a different RAC file.
+//
+// The position is all that is needed to resume. Restoring re-walks the index
+// from the root, once, to find the chunk containing that position, and since
+// a ChunkReader skips empty chunks, that is the chunk that NextChunk would
+// otherwise have returned next.
+const (
+	cursorVersion = 0x01
+	cursorLength  = 17
+)
+
+func encodeCursor(pos int64, decompressedSize int64) []byte {
+	b := make([]byte, cursorLength)
+	b[0] = cursorVersion
+	putU64LE(b[1:], uint64(pos))
+	putU64LE(b[9:], uint64(decompressedSize))
+	return b
+}
+
+func decodeCursor(b []byte, decompressedSize int64) (pos int64, err error) {
+	if (len(b) != cursorLength) || (b[0] != cursorVersion) {
+		return 0, errInvalidCursor
+	}
+	pos = int64(u64LE(b[1:]))
+	if (pos < 0) || (int64(u64LE(b[9:])) != decompressedSize) {
+		return 0, errInvalidCursor
+	}
+	return pos, nil
+}
+
+// SaveCursor returns an opaque encoding of r's position, so that a later
+// RestoreCursor call, possibly on a different ChunkReader for the same RAC
+// file, can resume where r left off.
+func (r *ChunkReader) SaveCursor() ([]byte, error) {
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
+	pos := r.seekPosition
+	if r.peeked {
+		pos = r.peekedSeekPosition
+	}
+	return encodeCursor(pos, r.decompressedSize), nil
+}
+
+// RestoreCursor sets up NextChunk to return the chunk that it would have
+// returned next at the time of the SaveCursor call that produced b.
+//
+// It returns an error if b is not a valid cursor for this RAC file.
+func (r *ChunkReader) RestoreCursor(b []byte) error {
+	if err := r.initialize(); err != nil {
+		return err
+	}
+	pos, err := decodeCursor(b, r.decompressedSize)
+	if err != nil {
+		return err
+	}
+	return r.SeekToChunkContaining(pos)
+}
+
+// SaveCursor returns an opaque encoding of r's position, so that a later
+// RestoreCursor call, possibly on a different Reader for the same RAC file,
+// can resume where r left off. It is equivalent to saving the result of
+// Seek(0, io.SeekCurrent).
+func (r *Reader) SaveCursor() ([]byte, error) {
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return encodeCursor(pos, r.chunkReader.decompressedSize), nil
+}
+
+// RestoreCursor sets r's position to that at the time of the SaveCursor call
+// that produced b. Like Seek, it removes any SeekRange limit.
+//
+// It returns an error if b is not a valid cursor for this RAC file.
+func (r *Reader) RestoreCursor(b []byte) error {
+	if err := r.initialize(); err != nil {
+		return err
+	}
+	pos, err := decodeCursor(b, r.chunkReader.decompressedSize)
+	if err != nil {
+		return err
+	}
+	_, err = r.Seek(pos, io.SeekStart)
+	return err
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package rac
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzReader checks that decoding arbitrary bytes never panics. Errors are
+// fine.
+func FuzzReader(f *testing.F) {
+	for _, s := range []string{
+		writerWantEmpty,
+		writerWantILAEnd,
+		writerWantILAEndCPageSize8,
+		writerWantILAStart,
+		writerWantILAStartCPageSize4,
+		writerWantILAStartCPageSize128,
+	} {
+		f.Add(undoHexDump(s))
+	}
+	f.Add(makeMismatchedArityRAC())
+
+	f.Fuzz(func(tt *testing.T, b []byte) {
+		r := NewReaderBytes(b)
+		r.CodecReaders = []CodecReader{storedCodecReader{}}
+		defer r.Close()
+
+		// Zeroes chunks can claim an arbitrarily large DSize, so cap how much
+		// is read.
+		const maxRead = 1 << 20
+		if _, err := io.Copy(ioutil.Discard, io.LimitReader(r, maxRead)); err != nil {
+			return
+		}
+		if _, err := r.Seek(-1, io.SeekEnd); err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(r, maxRead))
+	})
+}
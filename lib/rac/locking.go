@@ -0,0 +1,116 @@
+// Copyright 2019 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rac
+
+import (
+	"io"
+	"sync"
+)
+
+// LockingReader wraps a Reader so that it is safe for concurrent use by
+// multiple goroutines. Every method holds a mutex for the duration of the
+// call, so that calls are serialized, not parallelized. For parallel
+// decompression, use the Reader's Concurrency field or DecompressPipelined
+// instead.
+//
+// The goroutines share the one position, so interleaving separate Seek and
+// Read calls from different goroutines is rarely useful. ReadAt seeks and
+// reads under the one lock.
+type LockingReader struct {
+	mu sync.Mutex
+	r  *Reader
+}
+
+// NewLockingReader returns a LockingReader that wraps r. After this call, r
+// should only be used via the LockingReader.
+func NewLockingReader(r *Reader) *LockingReader {
+	return &LockingReader{r: r}
+}
+
+// Read implements io.Reader.
+func (l *LockingReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+// ReadAt implements io.ReaderAt. Like Read, it changes the position for
+// subsequent Read calls, to just after the bytes read.
+func (l *LockingReader) ReadAt(p []byte, off int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(l.r, p)
+}
+
+// Seek implements io.Seeker.
+func (l *LockingReader) Seek(offset int64, whence int) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Seek(offset, whence)
+}
+
+// SeekRange is like the Reader method of the same name.
+func (l *LockingReader) SeekRange(low int64, high int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.SeekRange(low, high)
+}
+
+// SeekToChunkIndex is like the Reader method of the same name.
+func (l *LockingReader) SeekToChunkIndex(n int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.SeekToChunkIndex(n)
+}
+
+// DecompressedSize is like the Reader method of the same name.
+func (l *LockingReader) DecompressedSize() (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.DecompressedSize()
+}
+
+// DecompressedRangeOf is like the Reader method of the same name.
+func (l *LockingReader) DecompressedRangeOf(n int64) (Range, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.DecompressedRangeOf(n)
+}
+
+// ChunksInRange is like the Reader method of the same name.
+func (l *LockingReader) ChunksInRange(dr Range) ([]Chunk, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.ChunksInRange(dr)
+}
+
+// SkippedRanges is like the Reader method of the same name.
+func (l *LockingReader) SkippedRanges() []Range {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.SkippedRanges()
+}
+
+// Close is like the Reader method of the same name. Unlike that method, it is
+// safe to call while other goroutines' calls are in progress: it waits for
+// them to finish.
+func (l *LockingReader) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Close()
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rac
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Metrics counts the I/O and validation work done by a ChunkReader or Reader,
+// for tuning. For example, it can confirm that a change in access pattern
+// reduced the number of reads from a network-backed source.
+//
+// The counters are updated atomically, so that one Metrics can be shared by
+// multiple readers, including a Reader's concurrent workers. Read them with
+// atomic loads while those readers are in use.
+type Metrics struct {
+	// ReadCalls is the number of reads issued to the ReadSeeker: calls to its
+	// ReadAt method if it implements io.ReaderAt, or to its Read method
+	// otherwise. This includes the reads made by CodecReaders.
+	ReadCalls int64
+
+	// BytesRead is the total number of bytes returned by those reads.
+	BytesRead int64
+
+	// NodesValidated is the number of index nodes that were loaded and
+	// validated, including their checksums unless SkipChecksumVerification is
+	// set. A node that is loaded more than once is counted each time.
+	NodesValidated int64
+}
+
+func (m *Metrics) addRead(n int) {
+	if m != nil {
+		atomic.AddInt64(&m.ReadCalls, 1)
+		atomic.AddInt64(&m.BytesRead, int64(n))
+	}
+}
+
+func (m *Metrics) addNodeValidated() {
+	if m != nil {
+		atomic.AddInt64(&m.NodesValidated, 1)
+	}
+}
+
+// metricsReadSeeker wraps an io.ReadSeeker to count its reads.
+type metricsReadSeeker struct {
+	rs io.ReadSeeker
+	m  *Metrics
+}
+
+// Read implements io.Reader.
+func (r *metricsReadSeeker) Read(p []byte) (int, error) {
+	n, err := r.rs.Read(p)
+	r.m.addRead(n)
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *metricsReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.rs.Seek(offset, whence)
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rac
+
+import (
+	"sort"
+)
+
+// OffsetIndex maps DSpace offsets to the chunks that contain them, without
+// re-walking the RAC file's index. For example, a caching proxy can translate
+// a request for a decompressed range into requests for compressed ranges.
+//
+// An OffsetIndex is immutable, so it is safe for concurrent use by multiple
+// goroutines, even though the Reader that built it is not.
+type OffsetIndex struct {
+	// chunks are the non-empty chunks, in DSpace order.
+	chunks []Chunk
+}
+
+// BuildOffsetIndex walks the whole index, once, and returns an OffsetIndex of
+// r's chunks. It does not decompress anything, and it does not change the
+// position for subsequent Read calls.
+func (r *Reader) BuildOffsetIndex() (*OffsetIndex, error) {
+	dSize, err := r.DecompressedSize()
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := r.ChunksInRange(Range{0, dSize})
+	if err != nil {
+		return nil, err
+	}
+	return &OffsetIndex{chunks: chunks}, nil
+}
+
+// Lookup returns the chunk containing dOff, using a binary search. It returns
+// false if no chunk does, such as when dOff is negative or at or past the end
+// of the decompressed data.
+func (x *OffsetIndex) Lookup(dOff int64) (Chunk, bool) {
+	i := sort.Search(len(x.chunks), func(i int) bool {
+		return x.chunks[i].DRange[1] > dOff
+	})
+	if (i < len(x.chunks)) && (x.chunks[i].DRange[0] <= dOff) {
+		return x.chunks[i], true
+	}
+	return Chunk{}, false
+}
+
+// Len returns the number of (non-empty) chunks in x.
+func (x *OffsetIndex) Len() int {
+	return len(x.chunks)
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rac
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// pWork is a unit of work for DecompressPipelined: the index'th DSpace range
+// to decompress, and (when done) its decompressed bytes or an error.
+type pWork struct {
+	index int
+	data  []byte
+	err   error
+}
+
+// DecompressPipelined writes all of the decompressed data to w, in DSpace
+// order, using workers goroutines to decompress chunks in parallel.
+//
+// Unlike reading everything into one big buffer, memory use is bounded: at
+// most workers chunks are decompressed but not yet written to w, whether they
+// are still in progress or are waiting for an earlier chunk to be written. A
+// slow w therefore throttles the workers instead of letting them run ahead.
+//
+// Non-positive workers means 1. If workers is more than 1, then the
+// ReadSeeker must also be an io.ReaderAt. Each worker uses clones of r's
+// CodecReaders.
+//
+// It returns early if ctx is canceled, in which case some of the data may
+// already have been written. It does not change the position for subsequent
+// Read calls.
+func (r *Reader) DecompressPipelined(ctx context.Context, w io.Writer, workers int) error {
+	if err := r.initialize(); err != nil {
+		return err
+	}
+	if workers <= 0 {
+		workers = 1
+	} else if workers > 1 {
+		if _, ok := r.ReadSeeker.(io.ReaderAt); !ok {
+			return fmt.Errorf("rac: DecompressPipelined with more than 1 worker requires the ReadSeeker to be an io.ReaderAt")
+		}
+	}
+
+	// Split DSpace at the chunk boundaries. In BestEffort mode, a skipped
+	// prefix is not covered by any chunk, so give it its own range.
+	boundaries, err := r.ChunkBoundaries()
+	if err != nil {
+		return err
+	}
+	if boundaries[0] > 0 {
+		boundaries = append([]int64{0}, boundaries...)
+	}
+	dRanges := make([]Range, 0, len(boundaries)-1)
+	for i := 1; i < len(boundaries); i++ {
+		dRanges = append(dRanges, Range{boundaries[i-1], boundaries[i]})
+	}
+	if len(dRanges) == 0 {
+		return nil
+	}
+	if workers > len(dRanges) {
+		workers = len(dRanges)
+	}
+
+	reqc := make(chan pWork)
+	resc := make(chan pWork, workers)
+	donec := make(chan struct{})
+	wg := sync.WaitGroup{}
+	defer func() {
+		close(reqc)
+		close(donec)
+		wg.Wait()
+	}()
+
+	for i := 0; i < workers; i++ {
+		rr := r.clone()
+		rr.Concurrency = 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer rr.Close()
+			for work := range reqc {
+				dr := dRanges[work.index]
+				work.data = make([]byte, dr.Size())
+				if work.err = rr.SeekRange(dr[0], dr[1]); work.err == nil {
+					_, work.err = io.ReadFull(rr, work.data)
+				}
+				select {
+				case resc <- work:
+				case <-donec:
+					return
+				}
+			}
+		}()
+	}
+
+	// completedWorks holds decompressed ranges that arrived out of order. It
+	// is keyed by pWork.index.
+	completedWorks := map[int][]byte{}
+
+	// Ranges [0, numWritten) have been written to w. Ranges [numWritten,
+	// numSent) have been sent to the workers.
+	numWritten, numSent := 0, 0
+	for numWritten < len(dRanges) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sendc := (chan<- pWork)(nil)
+		if (numSent < len(dRanges)) && ((numSent - numWritten) < workers) {
+			sendc = reqc
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sendc <- pWork{index: numSent}:
+			numSent++
+		case work := <-resc:
+			if work.err != nil {
+				if work.err == io.EOF {
+					work.err = io.ErrUnexpectedEOF
+				}
+				return work.err
+			}
+			completedWorks[work.index] = work.data
+		}
+
+		for {
+			data, ok := completedWorks[numWritten]
+			if !ok {
+				break
+			}
+			delete(completedWorks, numWritten)
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			numWritten++
+		}
+	}
+	return nil
+}
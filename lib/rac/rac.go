@@ -27,6 +27,8 @@ package rac
 
 import (
 	"errors"
+	"fmt"
+	"hash/crc32"
 )
 
 const (
@@ -59,7 +61,9 @@ const (
 //
 // The Mix Bit is not part of the uint64 representation. Neither is a Long
 // Codec's 'c64' index. This package's exported API deals with leaf nodes.
-// Branch nodes' wire formats are internal implementation details.
+// Branch nodes' wire formats are internal implementation details. The
+// exceptions are Contains and Union, which relate a branch node's Codec to its
+// children's, and represent the Mix Bit as the 1<<62 bit.
 //
 // See the RAC specification for further discussion.
 type Codec uint64
@@ -91,8 +95,34 @@ func (c Codec) name() string {
 	return ""
 }
 
+// Contains returns whether a branch node whose Codec is c can have a child
+// node whose Codec is inner. Unless c has the Mix Bit set, in which case its
+// children's Codecs are unconstrained, the two Codecs (ignoring inner's Mix
+// Bit) must be equal. Codecs are enumerated values, not sets of bits, so this
+// is not a bitwise subset relation: CodecZstandard does not contain CodecLZ4.
+func (c Codec) Contains(inner Codec) bool {
+	return ((c &^ codecMixBit) == (inner &^ codecMixBit)) || ((c & codecMixBit) != 0)
+}
+
+// Union returns the narrowest Codec that Contains both c and other: c itself
+// if the two are equal (ignoring their Mix Bits), and otherwise a Codec with
+// the Mix Bit set. Folding Union over a branch node's children's Codecs gives
+// a valid Codec for that node.
+//
+// If c and other differ, the result has the Mix Bit set, so it is not Valid:
+// it is only meaningful as a branch node's Codec.
+func (c Codec) Union(other Codec) Codec {
+	if (c &^ codecMixBit) == (other &^ codecMixBit) {
+		return c | (other & codecMixBit)
+	}
+	return codecMixBit | CodecZeroes
+}
+
 func parentChildCodecsValid(parent Codec, child Codec, parentHasMixBit bool) bool {
-	return (parent == child) || parentHasMixBit
+	if parentHasMixBit {
+		parent |= codecMixBit
+	}
+	return parent.Contains(child)
 }
 
 const (
@@ -120,25 +150,77 @@ const (
 
 var indexLocationAtEndMagic = []byte("\x72\xC3\x63\x00")
 
+// NodeChecksum returns the 16-bit checksum of an index node, as stored in its
+// bytes 4 and 5 (in little-endian order). It is the CRC-32 IEEE checksum of
+// the node's bytes after the checksum, with the high 16 bits XOR'ed into the
+// low 16 bits.
+//
+// The node's arity is its byte 3, and len(node) must be at least (16 * arity)
+// + 16. The bytes beyond that are ignored, as are the bytes (such as the
+// existing checksum) before byte 6.
+func NodeChecksum(node []byte) uint16 {
+	return nodeChecksum(node)
+}
+
+func nodeChecksum(b []byte) uint16 {
+	size := nodeSize(b[3])
+	checksum := crc32.ChecksumIEEE(b[6:size])
+	checksum ^= checksum >> 16
+	return uint16(checksum)
+}
+
+// ErrCorruptIndex is returned when an index node passes the low level (e.g.
+// magic, reserved bytes and checksum) checks but its children are
+// inconsistent with one another. For example, a branch node child might have
+// an empty DRange, or an STag might refer to a non-existent sibling.
+type ErrCorruptIndex struct {
+	// NodeCOffset is the position, in CSpace, of the offending index node.
+	NodeCOffset int64
+
+	// Child is the index of the offending child within that node, or -1 if
+	// the node as a whole is invalid.
+	Child int
+}
+
+func (e *ErrCorruptIndex) Error() string {
+	return fmt.Sprintf("rac: corrupt index: node at 0x%X, child %d", e.NodeCOffset, e.Child)
+}
+
 var (
 	ErrCodecWriterDoesNotSupportCChunkSize = errors.New("rac: CodecWriter does not support CChunkSize")
 
+	// ErrDecompressedSizeTooLarge is returned when a RAC file's decompressed
+	// size exceeds the Reader's MaxDecompressedSize.
+	ErrDecompressedSizeTooLarge = errors.New("rac: decompressed size too large")
+
+	// ErrNotYetAvailable is returned when reading a chunk whose compressed
+	// data is not within the Reader's AvailablePrefix.
+	ErrNotYetAvailable = errors.New("rac: chunk data not yet available")
+
 	errAlreadyClosed                 = errors.New("rac: already closed")
+	errBufferTooSmall                = errors.New("rac: buffer too small")
 	errCChunkSizeIsTooSmall          = errors.New("rac: CChunkSize is too small")
+	errExternalReference             = errors.New("rac: external reference")
 	errILAEndTempFile                = errors.New("rac: IndexLocationAtEnd requires a nil TempFile")
 	errILAStartTempFile              = errors.New("rac: IndexLocationAtStart requires a non-nil TempFile")
 	errInconsistentCompressedSize    = errors.New("rac: inconsistent compressed size")
+	errInvalidBaseOffset             = errors.New("rac: invalid BaseOffset")
 	errInvalidCPageSize              = errors.New("rac: invalid CPageSize")
+	errInvalidCRange                 = errors.New("rac: invalid CSpace range")
 	errInvalidChunk                  = errors.New("rac: invalid chunk")
+	errInvalidChunkIndex             = errors.New("rac: invalid chunk index")
 	errInvalidChunkTooLarge          = errors.New("rac: invalid chunk (too large)")
 	errInvalidChunkTruncated         = errors.New("rac: invalid chunk (truncated)")
 	errInvalidCodec                  = errors.New("rac: invalid Codec")
 	errInvalidCodecWriter            = errors.New("rac: invalid CodecWriter")
 	errInvalidCompressedSize         = errors.New("rac: invalid CompressedSize")
+	errInvalidCursor                 = errors.New("rac: invalid cursor")
 	errInvalidIndexNode              = errors.New("rac: invalid index node")
+	errInvalidIndexRange             = errors.New("rac: invalid index range")
 	errInvalidInputMissingMagicBytes = errors.New("rac: invalid input: missing magic bytes")
 	errInvalidInputMissingRootNode   = errors.New("rac: invalid input: missing root node")
 	errInvalidReadSeeker             = errors.New("rac: invalid ReadSeeker")
+	errInvalidTargetArity            = errors.New("rac: invalid TargetArity")
 	errInvalidWriter                 = errors.New("rac: invalid Writer")
 	errSeekToInvalidWhence           = errors.New("rac: seek to invalid whence")
 	errSeekToNegativePosition        = errors.New("rac: seek to negative position")
@@ -147,6 +229,7 @@ var (
 	errTooManyResources              = errors.New("rac: too many resources")
 	errTooMuchInput                  = errors.New("rac: too much input")
 	errUnsupportedRACFileVersion     = errors.New("rac: unsupported RAC file version")
+	errZeroDecompressedSize          = errors.New("rac: zero decompressed size")
 
 	errInternalArityIsTooLarge      = errors.New("rac: internal error: arity is too large")
 	errInternalEmptyDRange          = errors.New("rac: internal error: empty DRange")
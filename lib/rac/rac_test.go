@@ -16,6 +16,7 @@ package rac
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"hash/crc32"
@@ -23,8 +24,12 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const bytesPerHexDumpLine = 79
@@ -379,6 +384,14 @@ func TestMultiLevelIndex(tt *testing.T) {
 	if !bytes.Equal(gotPrimaries, primaries) {
 		tt.Fatalf("\ngot:\n%s\nwant:\n%s", gotPrimaries, primaries)
 	}
+
+	// The root node (arity 2) is at 0x0000, followed by the two branch nodes
+	// (arity 255 and 9) at 0x0030 and 0x1030.
+	if got, err := r.IndexExtent(); err != nil {
+		tt.Fatalf("IndexExtent: %v", err)
+	} else if want := (Range{0x0000, 0x10D0}); got != want {
+		tt.Fatalf("IndexExtent: got %#x, want %#x", got, want)
+	}
 }
 
 func TestWriter1000Chunks(tt *testing.T) {
@@ -669,7 +682,7 @@ func TestFindChunkContaining(tt *testing.T) {
 		arity = rng.Intn(255) + 1
 		size := (16 * arity) + 16
 
-		node := rNode{}
+		node := make(rNode, 4096)
 
 		dptrMax := 0
 		for j := 1; j <= arity; j++ {
@@ -693,7 +706,7 @@ func TestFindChunkContaining(tt *testing.T) {
 		node[4] = uint8(checksum >> 0)
 		node[5] = uint8(checksum >> 8)
 
-		if !node.valid() {
+		if !node.valid(false) {
 			tt.Fatalf("i=%d: invalid node", i)
 		}
 
@@ -707,3 +720,4020 @@ func TestFindChunkContaining(tt *testing.T) {
 		}
 	}
 }
+
+// resetChecksum recalculates the checksum of the node at the start of b.
+func resetChecksum(b []byte) {
+	size := nodeSize(b[3])
+	checksum := crc32.ChecksumIEEE(b[6:size])
+	checksum ^= checksum >> 16
+	b[4] = uint8(checksum >> 0)
+	b[5] = uint8(checksum >> 8)
+}
+
+func testCorruptIndex(tt *testing.T, encoded []byte, wantChild int) {
+	r := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	_, err := r.NextChunk()
+	if e, ok := err.(*ErrCorruptIndex); !ok {
+		tt.Fatalf("NextChunk: got %v, want an *ErrCorruptIndex", err)
+	} else if (e.NodeCOffset != 0) || (e.Child != wantChild) {
+		tt.Fatalf("NextChunk: got (0x%X, %d), want (0x%X, %d)",
+			e.NodeCOffset, e.Child, 0, wantChild)
+	}
+}
+
+func TestCorruptIndexOutOfRangeSTag(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAStart)
+	arity := int(encoded[3])
+	// Give the first child an STag that is neither 0xFF nor less than arity.
+	encoded[(8*arity)+15] = uint8(arity)
+	resetChecksum(encoded)
+	testCorruptIndex(tt, encoded, 0)
+}
+
+func TestCorruptIndexZeroDSizeBranch(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:        buf,
+		IndexLocation: IndexLocationAtStart,
+		TempFile:      &bytes.Buffer{},
+	}
+	for i := 0; i < 300; i++ {
+		_ = w.AddChunk(1, fakeCodec, []byte{'x'}, 0, 0)
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+	if encoded[7] != 0xFE {
+		tt.Fatalf("TTag[0]: got 0x%02X, want 0xFE", encoded[7])
+	}
+	// Set DPtr[1] to zero, so that the first (branch node) child is empty.
+	for i := 8; i < 14; i++ {
+		encoded[i] = 0
+	}
+	resetChecksum(encoded)
+	testCorruptIndex(tt, encoded, 0)
+}
+
+func TestReaderNodeAt(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAEnd)
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	defer r.Close()
+
+	if _, err := r.NodeAt(0); err == nil {
+		tt.Fatalf("NodeAt(0): got nil error, want non-nil")
+	} else if _, ok := err.(*ErrCorruptIndex); !ok {
+		tt.Fatalf("NodeAt(0): got %v, want an *ErrCorruptIndex", err)
+	}
+
+	n, err := r.NodeAt(0x1C)
+	if err != nil {
+		tt.Fatalf("NodeAt(0x1C): %v", err)
+	}
+	if got, want := n.COffset(), int64(0x1C); got != want {
+		tt.Errorf("COffset: got 0x%X, want 0x%X", got, want)
+	}
+	if got, want := n.Arity(), 5; got != want {
+		tt.Fatalf("Arity: got %d, want %d", got, want)
+	}
+	if got, want := n.Codec(), fakeCodec; got != want {
+		tt.Errorf("Codec: got 0x%X, want 0x%X", got, want)
+	}
+	if got, want := n.Version(), uint8(1); got != want {
+		tt.Errorf("Version: got %d, want %d", got, want)
+	}
+	if got, want := n.CPtrMax(), int64(0x7C); got != want {
+		tt.Errorf("CPtrMax: got 0x%X, want 0x%X", got, want)
+	}
+	if got, want := n.DPtrMax(), int64(0x77); got != want {
+		tt.Errorf("DPtrMax: got 0x%X, want 0x%X", got, want)
+	}
+
+	wants := []struct {
+		dOff int64
+		cOff int64
+		cLen uint8
+		sTag uint8
+		tTag uint8
+	}{
+		{0x00, 0x04, 1, 0xFF, 0xFF},
+		{0x00, 0x07, 1, 0xFF, 0xFF},
+		{0x00, 0x09, 1, 0xFF, 0xFF},
+		{0x11, 0x0C, 1, 0x00, 0xFF},
+		{0x33, 0x10, 1, 0x00, 0x01},
+	}
+	for i, want := range wants {
+		if got := n.DOff(i); got != want.dOff {
+			tt.Errorf("i=%d: DOff: got 0x%X, want 0x%X", i, got, want.dOff)
+		}
+		if got := n.COff(i); got != want.cOff {
+			tt.Errorf("i=%d: COff: got 0x%X, want 0x%X", i, got, want.cOff)
+		}
+		if got := n.CLen(i); got != want.cLen {
+			tt.Errorf("i=%d: CLen: got %d, want %d", i, got, want.cLen)
+		}
+		if got := n.STag(i); got != want.sTag {
+			tt.Errorf("i=%d: STag: got 0x%02X, want 0x%02X", i, got, want.sTag)
+		}
+		if got := n.TTag(i); got != want.tTag {
+			tt.Errorf("i=%d: TTag: got 0x%02X, want 0x%02X", i, got, want.tTag)
+		}
+	}
+}
+
+// oneByteReadSeeker returns at most 1 byte per Read call. It deliberately does
+// not implement io.ReaderAt.
+type oneByteReadSeeker struct {
+	rs io.ReadSeeker
+}
+
+func (r *oneByteReadSeeker) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return r.rs.Read(p)
+}
+
+func (r *oneByteReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.rs.Seek(offset, whence)
+}
+
+// eagerEOFReadSeeker returns (n, io.EOF), with positive n, for the Read call
+// that reaches the end of its data, instead of waiting for the next Read call
+// to return (0, io.EOF). It deliberately does not implement io.ReaderAt.
+type eagerEOFReadSeeker struct {
+	data []byte
+	pos  int64
+}
+
+func (r *eagerEOFReadSeeker) Read(p []byte) (int, error) {
+	if r.pos >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos += int64(n)
+	if r.pos == int64(len(r.data)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *eagerEOFReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += int64(len(r.data))
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("eagerEOFReadSeeker: negative position")
+	}
+	r.pos = offset
+	return r.pos, nil
+}
+
+func TestChunkReaderShortReads(tt *testing.T) {
+	for _, hexDump := range []string{writerWantILAEnd, writerWantILAStart} {
+		encoded := undoHexDump(hexDump)
+		want, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+		if err != nil {
+			tt.Fatalf("readAllChunks: %v", err)
+		}
+
+		readSeekers := []io.ReadSeeker{
+			&oneByteReadSeeker{bytes.NewReader(encoded)},
+			&eagerEOFReadSeeker{data: encoded},
+		}
+		for i, rs := range readSeekers {
+			got, err := readAllChunks(rs, int64(len(encoded)))
+			if err != nil {
+				tt.Errorf("i=%d: readAllChunks: %v", i, err)
+			} else if printChunks(got) != printChunks(want) {
+				tt.Errorf("i=%d: chunks:\ngot\n%s\nwant\n%s", i, printChunks(got), printChunks(want))
+			}
+		}
+	}
+}
+
+func readAllChunks(rs io.ReadSeeker, compressedSize int64) ([]Chunk, error) {
+	r := &ChunkReader{
+		ReadSeeker:     rs,
+		CompressedSize: compressedSize,
+	}
+	ret := []Chunk(nil)
+	for {
+		c, err := r.NextChunk()
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ret = append(ret, c)
+	}
+}
+
+func TestChunkReaderReadAt(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAEnd)
+	n := int64(len(encoded))
+
+	testCases := []struct {
+		cOffset int64
+		length  int
+		wantErr error
+	}{
+		{0, 4, nil},
+		{n - 4, 4, nil},
+		{n - 1, 1, nil},
+		{n - 4, 5, io.ErrUnexpectedEOF},
+		{n, 1, io.ErrUnexpectedEOF},
+	}
+
+	for _, tc := range testCases {
+		readSeekers := []io.ReadSeeker{
+			&oneByteReadSeeker{bytes.NewReader(encoded)},
+			&eagerEOFReadSeeker{data: encoded},
+			bytes.NewReader(encoded),
+		}
+		for i, rs := range readSeekers {
+			r := &ChunkReader{
+				ReadSeeker:     rs,
+				CompressedSize: n,
+			}
+			if err := r.initialize(); err != nil {
+				tt.Fatalf("i=%d: initialize: %v", i, err)
+			}
+			p := make([]byte, tc.length)
+			if err := r.readAt(p, tc.cOffset); err != tc.wantErr {
+				tt.Errorf("cOffset=%d, length=%d, i=%d: got %v, want %v",
+					tc.cOffset, tc.length, i, err, tc.wantErr)
+			} else if (err == nil) && !bytes.Equal(p, encoded[tc.cOffset:tc.cOffset+int64(tc.length)]) {
+				tt.Errorf("cOffset=%d, length=%d, i=%d: contents differ", tc.cOffset, tc.length, i)
+			}
+		}
+	}
+}
+
+func TestSplitChunkReader(tt *testing.T) {
+	testCases := []struct {
+		name    string
+		hexDump string
+		atEnd   bool
+	}{
+		{"ILAEnd", writerWantILAEnd, true},
+		{"ILAStart", writerWantILAStart, false},
+	}
+
+	for _, tc := range testCases {
+		encoded := undoHexDump(tc.hexDump)
+		n := int64(len(encoded))
+		indexRange := Range{0, int64(nodeSize(encoded[3]))}
+		if tc.atEnd {
+			indexRange = Range{n - int64(nodeSize(encoded[n-1])), n}
+		}
+
+		// Overwrite the data source's copy of the index, so that any index
+		// node reads from it would fail.
+		data := append([]byte(nil), encoded...)
+		for i := indexRange[0]; i < indexRange[1]; i++ {
+			data[i] = 0xEE
+		}
+		index := encoded[indexRange[0]:indexRange[1]]
+
+		want, err := readAllChunks(bytes.NewReader(encoded), n)
+		if err != nil {
+			tt.Errorf("%q test case: readAllChunks: %v", tc.name, err)
+			continue
+		}
+
+		r := NewSplitChunkReader(bytes.NewReader(index), indexRange, bytes.NewReader(data), n)
+		if got, err := r.DecompressedSize(); err != nil {
+			tt.Errorf("%q test case: DecompressedSize: %v", tc.name, err)
+			continue
+		} else if got != 0x77 {
+			tt.Errorf("%q test case: DecompressedSize: got 0x%X, want 0x77", tc.name, got)
+			continue
+		}
+
+		got := []Chunk(nil)
+		for {
+			c, err := r.NextChunk()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				tt.Errorf("%q test case: NextChunk: %v", tc.name, err)
+				break
+			}
+			got = append(got, c)
+		}
+		if printChunks(got) != printChunks(want) {
+			tt.Errorf("%q test case: chunks:\ngot\n%s\nwant\n%s", tc.name, printChunks(got), printChunks(want))
+			continue
+		}
+
+		if err := r.SeekToChunkContaining(0x30); err != nil {
+			tt.Errorf("%q test case: SeekToChunkContaining: %v", tc.name, err)
+			continue
+		}
+		if c, err := r.NextChunk(); err != nil {
+			tt.Errorf("%q test case: NextChunk: %v", tc.name, err)
+			continue
+		} else if got, want := c.DRange, want[1].DRange; got != want {
+			tt.Errorf("%q test case: DRange: got %v, want %v", tc.name, got, want)
+			continue
+		}
+
+		// Reading a CRange through the split source should see the data, and
+		// reading the whole CSpace should see the original RAC file.
+		rs := r.ReadSeeker.(io.ReaderAt)
+		chunkData := make([]byte, want[0].CPrimary.Size())
+		if _, err := rs.ReadAt(chunkData, want[0].CPrimary[0]); err != nil {
+			tt.Errorf("%q test case: ReadAt: %v", tc.name, err)
+		} else if !bytes.Equal(chunkData, encoded[want[0].CPrimary[0]:want[0].CPrimary[1]]) {
+			tt.Errorf("%q test case: ReadAt: chunk data differs", tc.name)
+		}
+		whole := make([]byte, n)
+		if _, err := rs.ReadAt(whole, 0); err != nil {
+			tt.Errorf("%q test case: ReadAt: %v", tc.name, err)
+		} else if !bytes.Equal(whole, encoded) {
+			tt.Errorf("%q test case: ReadAt: whole file differs", tc.name)
+		}
+	}
+}
+
+func TestReaderIndexExtent(tt *testing.T) {
+	testCases := []struct {
+		name    string
+		hexDump string
+		atEnd   bool
+	}{
+		{"ILAEnd", writerWantILAEnd, true},
+		{"ILAEndCPageSize8", writerWantILAEndCPageSize8, true},
+		{"ILAStart", writerWantILAStart, false},
+		{"ILAStartCPageSize128", writerWantILAStartCPageSize128, false},
+	}
+
+	for _, tc := range testCases {
+		encoded := undoHexDump(tc.hexDump)
+		n := int64(len(encoded))
+		want := Range{0, int64(nodeSize(encoded[3]))}
+		if tc.atEnd {
+			want = Range{n - int64(nodeSize(encoded[n-1])), n}
+		}
+
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: n,
+		}
+		if got, err := r.IndexExtent(); err != nil {
+			tt.Errorf("%q test case: IndexExtent: %v", tc.name, err)
+		} else if got != want {
+			tt.Errorf("%q test case: IndexExtent: got %#x, want %#x", tc.name, got, want)
+		}
+	}
+}
+
+// countingReadSeeker counts its Read calls. It deliberately does not implement
+// io.ReaderAt.
+type countingReadSeeker struct {
+	rs    io.ReadSeeker
+	reads int
+}
+
+func (r *countingReadSeeker) Read(p []byte) (int, error) {
+	r.reads++
+	return r.rs.Read(p)
+}
+
+func (r *countingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.rs.Seek(offset, whence)
+}
+
+// makeMismatchedArityRAC returns a 32 byte file whose start (magic and arity
+// 200) and end (arity 1) disagree on the root node's arity. Loading the
+// 32-byte node that the end implies must not trust the arity at its start.
+func makeMismatchedArityRAC() []byte {
+	b := make([]byte, 32)
+	copy(b, magic)
+	b[3] = 0xC8
+	b[31] = 0x01
+	return b
+}
+
+func TestChunkReaderMismatchedRootArity(tt *testing.T) {
+	encoded := makeMismatchedArityRAC()
+	r := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	if _, err := r.NextChunk(); err == nil {
+		tt.Fatalf("ChunkReader.NextChunk: got nil error, want non-nil")
+	}
+
+	rr := NewReaderBytes(encoded)
+	if _, err := rr.Read(make([]byte, 1)); err == nil {
+		tt.Fatalf("Reader.Read: got nil error, want non-nil")
+	}
+}
+
+func TestChunkReaderZeroLengthFile(tt *testing.T) {
+	encoded := undoHexDump(writerWantEmpty)
+	r := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	if got, err := r.DecompressedSize(); err != nil {
+		tt.Fatalf("DecompressedSize: %v", err)
+	} else if got != 0 {
+		tt.Fatalf("DecompressedSize: got %d, want 0", got)
+	}
+	if _, err := r.NextChunk(); err != io.EOF {
+		tt.Fatalf("NextChunk: got %v, want io.EOF", err)
+	}
+	if err := r.SeekToChunkContaining(0); err != nil {
+		tt.Fatalf("SeekToChunkContaining: %v", err)
+	}
+	if _, err := r.NextChunk(); err != io.EOF {
+		tt.Fatalf("NextChunk: got %v, want io.EOF", err)
+	}
+}
+
+func TestChunkReaderSingleChunkFile(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	if err := w.AddChunk(0x99, fakeCodec, []byte("Hello"), 0, 0); err != nil {
+		tt.Fatalf("AddChunk: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	crs := &countingReadSeeker{rs: bytes.NewReader(encoded)}
+	r := &ChunkReader{
+		ReadSeeker:     crs,
+		CompressedSize: int64(len(encoded)),
+	}
+	if got, err := r.DecompressedSize(); err != nil {
+		tt.Fatalf("DecompressedSize: %v", err)
+	} else if got != 0x99 {
+		tt.Fatalf("DecompressedSize: got 0x%X, want 0x99", got)
+	}
+	readsAfterInitialize := crs.reads
+
+	for i := 0; i < 3; i++ {
+		if err := r.SeekToChunkContaining(0x42); err != nil {
+			tt.Fatalf("i=%d: SeekToChunkContaining: %v", i, err)
+		}
+		c, err := r.NextChunk()
+		if err != nil {
+			tt.Fatalf("i=%d: NextChunk: %v", i, err)
+		}
+		if got, want := c.DRange, (Range{0, 0x99}); got != want {
+			tt.Fatalf("i=%d: DRange: got %v, want %v", i, got, want)
+		}
+		// The CPrimary range may extend beyond the "Hello", up to COffMax.
+		if got := string(encoded[c.CPrimary[0]:c.CPrimary[1]]); !strings.HasPrefix(got, "Hello") {
+			tt.Fatalf("i=%d: CPrimary: got %q, want a \"Hello\" prefix", i, got)
+		}
+		if _, err := r.NextChunk(); err != io.EOF {
+			tt.Fatalf("i=%d: NextChunk: got %v, want io.EOF", i, err)
+		}
+	}
+
+	// The root node is the only node, and it was loaded during initialization,
+	// so NextChunk should not have needed to re-load it.
+	if got := crs.reads - readsAfterInitialize; got != 0 {
+		tt.Fatalf("reads after initialization: got %d, want 0", got)
+	}
+}
+
+// makeLeafRootRAC returns a RAC file whose root node is a leaf node holding
+// numChunks chunks, each 0x100 bytes of CodecZeroes.
+func makeLeafRootRAC(numChunks int) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < numChunks; i++ {
+		if err := w.AddChunk(0x100, CodecZeroes, nil, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func TestChunkReaderLeafRootArity8(tt *testing.T) {
+	encoded, err := makeLeafRootRAC(8)
+	if err != nil {
+		tt.Fatalf("makeLeafRootRAC: %v", err)
+	}
+
+	crs := &countingReadSeeker{rs: bytes.NewReader(encoded)}
+	r := &ChunkReader{
+		ReadSeeker:     crs,
+		CompressedSize: int64(len(encoded)),
+	}
+	if _, err := r.DecompressedSize(); err != nil {
+		tt.Fatalf("DecompressedSize: %v", err)
+	}
+	if got := r.rootNode.arity(); got != 8 {
+		tt.Fatalf("root node arity: got %d, want 8", got)
+	}
+	if !r.rootNodeIsLeaf {
+		tt.Fatalf("rootNodeIsLeaf: got false, want true")
+	}
+	readsAfterInitialize := crs.reads
+
+	for _, dOff := range []int64{0, 0x480, 0x7FF, 0x100} {
+		if err := r.SeekToChunkContaining(dOff); err != nil {
+			tt.Fatalf("dOff=0x%X: SeekToChunkContaining: %v", dOff, err)
+		}
+		want := dOff &^ 0xFF
+		for ; ; want += 0x100 {
+			c, err := r.NextChunk()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				tt.Fatalf("dOff=0x%X: NextChunk: %v", dOff, err)
+			}
+			if got := c.DRange; got != (Range{want, want + 0x100}) {
+				tt.Fatalf("dOff=0x%X: DRange: got %v, want [0x%X, 0x%X)", dOff, got, want, want+0x100)
+			}
+		}
+		if want != 0x800 {
+			tt.Fatalf("dOff=0x%X: scan ended at 0x%X, want 0x800", dOff, want)
+		}
+	}
+
+	if got := crs.reads - readsAfterInitialize; got != 0 {
+		tt.Fatalf("reads after initialization: got %d, want 0", got)
+	}
+}
+
+// storedCodec is a test-only Codec whose compressed form is the decompressed
+// form, byte for byte. A chunk's CPrimary range can extend past its data (e.g.
+// into the index), so the decompressor is limited by the DRange size.
+const storedCodec = Codec(0x3F << 56)
+
+type storedCodecReader struct{}
+
+func (storedCodecReader) Close() error         { return nil }
+func (storedCodecReader) Accepts(c Codec) bool { return c == storedCodec }
+func (storedCodecReader) Clone() CodecReader   { return storedCodecReader{} }
+func (storedCodecReader) MakeDecompressor(racFile io.ReadSeeker, c Chunk) (io.Reader, error) {
+	if _, err := racFile.Seek(c.CPrimary[0], io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(racFile, c.DRange.Size()), nil
+}
+
+func TestReaderSeek(tt *testing.T) {
+	const dSize = 0x30
+	want := make([]byte, dSize)
+	for i := range want {
+		want[i] = byte(i)
+	}
+
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < dSize; i += 0x10 {
+		if err := w.AddChunk(0x10, storedCodec, want[i:i+0x10], 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	testCases := []struct {
+		offset  int64
+		whence  int
+		wantPos int64
+	}{
+		{dSize - 1, io.SeekStart, dSize - 1},
+		{0x15, io.SeekStart, 0x15},
+		{-0x08, io.SeekEnd, dSize - 0x08},
+		{0, io.SeekEnd, dSize},
+		{dSize + 5, io.SeekStart, dSize + 5},
+	}
+
+	for _, concurrency := range []int{0, 2} {
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+			CodecReaders:   []CodecReader{storedCodecReader{}},
+			Concurrency:    concurrency,
+		}
+		for _, tc := range testCases {
+			pos, err := r.Seek(tc.offset, tc.whence)
+			if err != nil {
+				tt.Fatalf("c=%d, tc=%v: Seek: %v", concurrency, tc, err)
+			}
+			if pos != tc.wantPos {
+				tt.Fatalf("c=%d, tc=%v: pos: got %d, want %d", concurrency, tc, pos, tc.wantPos)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				tt.Fatalf("c=%d, tc=%v: ReadAll: %v", concurrency, tc, err)
+			}
+			wantSuffix := []byte(nil)
+			if tc.wantPos < dSize {
+				wantSuffix = want[tc.wantPos:]
+			}
+			if !bytes.Equal(got, wantSuffix) {
+				tt.Fatalf("c=%d, tc=%v: got %x, want %x", concurrency, tc, got, wantSuffix)
+			}
+		}
+
+		if _, err := r.Seek(-1, io.SeekStart); err != errSeekToNegativePosition {
+			tt.Fatalf("c=%d: negative Seek: got %v, want %v", concurrency, err, errSeekToNegativePosition)
+		}
+		r.Close()
+	}
+}
+
+func TestSkipChecksumVerification(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAEnd)
+	// The root node is at the end. Flip a bit in its checksum, which is
+	// otherwise structurally valid.
+	size := nodeSize(encoded[len(encoded)-1])
+	encoded[len(encoded)-size+4] ^= 0x01
+
+	for _, skip := range []bool{false, true} {
+		r := &ChunkReader{
+			ReadSeeker:               bytes.NewReader(encoded),
+			CompressedSize:           int64(len(encoded)),
+			SkipChecksumVerification: skip,
+		}
+		_, err := r.NextChunk()
+		if gotOK, wantOK := err == nil, skip; gotOK != wantOK {
+			tt.Fatalf("skip=%t: NextChunk: got %v", skip, err)
+		}
+	}
+}
+
+func BenchmarkSeekVerifyChecksum(b *testing.B) { benchmarkSeek(b, false, false) }
+func BenchmarkSeekSkipChecksum(b *testing.B)   { benchmarkSeek(b, true, false) }
+
+// The InMemory variants use the ReadSeeker made by NewReaderBytes, whose index
+// nodes are sub-sliced instead of copied.
+func BenchmarkSeekVerifyChecksumInMemory(b *testing.B) { benchmarkSeek(b, false, true) }
+func BenchmarkSeekSkipChecksumInMemory(b *testing.B)   { benchmarkSeek(b, true, true) }
+
+func benchmarkSeek(b *testing.B, skipChecksum bool, inMemory bool) {
+	// Write enough chunks for a multi-level index, so that seeking has to
+	// load (and re-validate) branch nodes.
+	const numChunks = 4000
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < numChunks; i++ {
+		if err := w.AddChunk(0x100, CodecZeroes, nil, 0, 0); err != nil {
+			b.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	rs := io.ReadSeeker(bytes.NewReader(encoded))
+	if inMemory {
+		rs = NewReaderBytes(encoded).ReadSeeker
+	}
+	r := &ChunkReader{
+		ReadSeeker:               rs,
+		CompressedSize:           int64(len(encoded)),
+		SkipChecksumVerification: skipChecksum,
+	}
+	rng := rand.New(rand.NewSource(1))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := r.SeekToChunkContaining(rng.Int63n(numChunks * 0x100)); err != nil {
+			b.Fatalf("SeekToChunkContaining: %v", err)
+		}
+		if _, err := r.NextChunk(); err != nil {
+			b.Fatalf("NextChunk: %v", err)
+		}
+	}
+}
+
+func BenchmarkNextChunkLeafRoot(b *testing.B) {
+	encoded, err := makeLeafRootRAC(8)
+	if err != nil {
+		b.Fatalf("makeLeafRootRAC: %v", err)
+	}
+	r := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := r.SeekToChunkContaining(0); err != nil {
+			b.Fatalf("SeekToChunkContaining: %v", err)
+		}
+		for {
+			if _, err := r.NextChunk(); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatalf("NextChunk: %v", err)
+			}
+		}
+	}
+}
+
+func TestChunkReaderNextChunkBranchChildren(tt *testing.T) {
+	// In a chain of nodes, each node's branch child is its last child, after a
+	// leaf. NextChunk must descend into it instead of returning it as a chunk.
+	const numChain = 20
+	chain := makeChainRAC(numChain)
+	chunks, err := readAllChunks(bytes.NewReader(chain), int64(len(chain)))
+	if err != nil {
+		tt.Fatalf("chain: readAllChunks: %v", err)
+	}
+	gotDRanges := []Range(nil)
+	for _, c := range chunks {
+		gotDRanges = append(gotDRanges, c.DRange)
+	}
+	wantDRanges := []Range(nil)
+	for i := int64(0); i < numChain; i++ {
+		wantDRanges = append(wantDRanges, Range{i, i + 1})
+	}
+	if !reflect.DeepEqual(gotDRanges, wantDRanges) {
+		tt.Fatalf("chain: DRanges: got %v, want %v", gotDRanges, wantDRanges)
+	}
+
+	// A ChunkWriter with a small TargetArity writes a root node whose children
+	// are all branch nodes.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{Writer: buf, TargetArity: 4}
+	const numChunks = 30
+	for i := 0; i < numChunks; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d;", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+	chunks, err = readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("tree: readAllChunks: %v", err)
+	}
+	if len(chunks) != numChunks {
+		tt.Fatalf("tree: got %d chunks, want %d", len(chunks), numChunks)
+	}
+	for i, c := range chunks {
+		const n = int64(len("chunk #00;"))
+		if want := (Range{n * int64(i), n * int64(i+1)}); c.DRange != want {
+			tt.Fatalf("tree: chunk #%d: DRange: got %v, want %v", i, c.DRange, want)
+		}
+		// A zero CLen means that CPrimary extends to the node's CPtrMax, so
+		// only check its prefix.
+		if got, want := encoded[c.CPrimary[0]:c.CPrimary[1]], fmt.Sprintf("chunk #%02d;", i); !bytes.HasPrefix(got, []byte(want)) {
+			tt.Fatalf("tree: chunk #%d: CPrimary: got %q, want prefix %q", i, got, want)
+		}
+	}
+}
+
+func TestChunkReaderSmallIndexThreshold(tt *testing.T) {
+	for _, ila := range []IndexLocation{IndexLocationAtEnd, IndexLocationAtStart} {
+		buf := &bytes.Buffer{}
+		w := &ChunkWriter{Writer: buf, IndexLocation: ila, TargetArity: 4}
+		if ila == IndexLocationAtStart {
+			w.TempFile = &bytes.Buffer{}
+		}
+		res, err := w.AddResource([]byte("resource"))
+		if err != nil {
+			tt.Fatalf("ila=%d: AddResource: %v", ila, err)
+		}
+		for i := 0; i < 40; i++ {
+			data := []byte(fmt.Sprintf("chunk #%02d;", i))
+			if err := w.AddChunk(uint64(len(data)), storedCodec, data, res, 0); err != nil {
+				tt.Fatalf("ila=%d: AddChunk: %v", ila, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			tt.Fatalf("ila=%d: Close: %v", ila, err)
+		}
+		encoded := buf.Bytes()
+
+		scan := func(threshold int64) ([]Chunk, *Metrics) {
+			m := &Metrics{}
+			r := &ChunkReader{
+				ReadSeeker:          bytes.NewReader(encoded),
+				CompressedSize:      int64(len(encoded)),
+				Metrics:             m,
+				SmallIndexThreshold: threshold,
+			}
+			chunks := []Chunk(nil)
+			for {
+				c, err := r.NextChunk()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					tt.Fatalf("ila=%d, threshold=%d: NextChunk: %v", ila, threshold, err)
+				}
+				chunks = append(chunks, c)
+			}
+			return chunks, m
+		}
+
+		want, wantMetrics := scan(0)
+		// Thresholds smaller than the index only buffer some of its nodes.
+		for _, threshold := range []int64{1, 100, 300, 65536} {
+			got, gotMetrics := scan(threshold)
+			if !reflect.DeepEqual(got, want) {
+				tt.Fatalf("ila=%d, threshold=%d: chunks:\ngot  %v\nwant %v", ila, threshold, got, want)
+			}
+			if gotMetrics.NodesValidated != wantMetrics.NodesValidated {
+				tt.Fatalf("ila=%d, threshold=%d: NodesValidated: got %d, want %d",
+					ila, threshold, gotMetrics.NodesValidated, wantMetrics.NodesValidated)
+			}
+			if threshold == 65536 {
+				// One read for the magic bytes, one for the whole file.
+				if gotMetrics.ReadCalls != 2 {
+					tt.Fatalf("ila=%d, threshold=%d: ReadCalls: got %d, want 2 (compared to %d)",
+						ila, threshold, gotMetrics.ReadCalls, wantMetrics.ReadCalls)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSmallIndexThresholdZero(b *testing.B) { benchmarkSmallIndexThreshold(b, 0) }
+func BenchmarkSmallIndexThreshold64K(b *testing.B)  { benchmarkSmallIndexThreshold(b, 65536) }
+
+func benchmarkSmallIndexThreshold(b *testing.B, threshold int64) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{Writer: buf, TargetArity: 4}
+	for i := 0; i < 64; i++ {
+		if err := w.AddChunk(0x100, CodecZeroes, nil, 0, 0); err != nil {
+			b.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	m := &Metrics{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := &ChunkReader{
+			ReadSeeker:          bytes.NewReader(encoded),
+			CompressedSize:      int64(len(encoded)),
+			Metrics:             m,
+			SmallIndexThreshold: threshold,
+		}
+		for {
+			if _, err := r.NextChunk(); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatalf("NextChunk: %v", err)
+			}
+		}
+	}
+	b.ReportMetric(float64(m.ReadCalls)/float64(b.N), "reads/op")
+}
+
+func TestReaderChunksInRange(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAEnd)
+
+	// The three chunks' DRanges are [0x00, 0x11), [0x11, 0x33) and [0x33,
+	// 0x77).
+	testCases := []struct {
+		dr   Range
+		want []int64
+	}{
+		{Range{0x14, 0x20}, []int64{0x11}},
+		{Range{0x00, 0x11}, []int64{0x00}},
+		{Range{0x10, 0x34}, []int64{0x00, 0x11, 0x33}},
+		{Range{0x40, 0x1000}, []int64{0x33}},
+		{Range{0x77, 0x1000}, nil},
+		{Range{0x20, 0x20}, nil},
+	}
+
+	for _, concurrency := range []int{0, 2} {
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+			Concurrency:    concurrency,
+		}
+		for _, tc := range testCases {
+			chunks, err := r.ChunksInRange(tc.dr)
+			if err != nil {
+				tt.Fatalf("c=%d, dr=%v: ChunksInRange: %v", concurrency, tc.dr, err)
+			}
+			got := []int64(nil)
+			for _, c := range chunks {
+				got = append(got, c.DRange[0])
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				tt.Fatalf("c=%d, dr=%v: got %#x, want %#x", concurrency, tc.dr, got, tc.want)
+			}
+		}
+
+		if _, err := r.ChunksInRange(Range{0x20, 0x10}); err != errSeekToNegativeRange {
+			tt.Fatalf("c=%d: got %v, want %v", concurrency, err, errSeekToNegativeRange)
+		}
+		r.Close()
+	}
+}
+
+// closeTrackingCodecReader is a storedCodecReader whose decompressors track,
+// in *numOpen, how many have been made but not yet closed.
+type closeTrackingCodecReader struct {
+	storedCodecReader
+	numOpen *int64
+}
+
+func (c closeTrackingCodecReader) Clone() CodecReader { return c }
+func (c closeTrackingCodecReader) MakeDecompressor(racFile io.ReadSeeker, chunk Chunk) (io.Reader, error) {
+	d, err := c.storedCodecReader.MakeDecompressor(racFile, chunk)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(c.numOpen, 1)
+	return &closeTrackingReader{Reader: d, numOpen: c.numOpen}, nil
+}
+
+type closeTrackingReader struct {
+	io.Reader
+	numOpen *int64
+}
+
+func (r *closeTrackingReader) Close() error {
+	atomic.AddInt64(r.numOpen, -1)
+	return nil
+}
+
+func TestReaderChunksInRangeKeepsPosition(tt *testing.T) {
+	want := make([]byte, 0x30)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < len(want); i += 0x10 {
+		if err := w.AddChunk(0x10, storedCodec, want[i:i+0x10], 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	numOpen := int64(0)
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{closeTrackingCodecReader{numOpen: &numOpen}},
+	}
+	defer r.Close()
+
+	got := make([]byte, 0x18)
+	if _, err := io.ReadFull(r, got[:0x08]); err != nil {
+		tt.Fatalf("ReadFull #0: %v", err)
+	}
+	if _, err := r.ChunksInRange(Range{0x20, 0x30}); err != nil {
+		tt.Fatalf("ChunksInRange: %v", err)
+	}
+	// Resetting to "State A" must close the partially read chunk's
+	// decompressor, not just drop it.
+	if n := atomic.LoadInt64(&numOpen); n != 0 {
+		tt.Fatalf("after ChunksInRange: got %d open decompressors, want 0", n)
+	}
+	if _, err := io.ReadFull(r, got[0x08:]); err != nil {
+		tt.Fatalf("ReadFull #1: %v", err)
+	}
+	if !bytes.Equal(got, want[:0x18]) {
+		tt.Fatalf("got %x, want %x", got, want[:0x18])
+	}
+}
+
+func TestBestEffort(tt *testing.T) {
+	// Write enough chunks for the root node to have at least three branch
+	// node children.
+	const numChunks = 760
+	want := make([]byte, numChunks*0x10)
+	for i := range want {
+		want[i] = byte(i>>4) | 1
+	}
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < len(want); i += 0x10 {
+		if err := w.AddChunk(0x10, storedCodec, want[i:i+0x10], 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Find the root node's middle branch child and corrupt its checksum.
+	rootCOffset := int64(len(encoded) - nodeSize(encoded[len(encoded)-1]))
+	root, err := NewReaderBytes(encoded).NodeAt(rootCOffset)
+	if err != nil {
+		tt.Fatalf("NodeAt: %v", err)
+	}
+	branches := []int(nil)
+	for i := 0; i < root.Arity(); i++ {
+		if root.TTag(i) == 0xFE {
+			branches = append(branches, i)
+		}
+	}
+	if len(branches) < 3 {
+		tt.Fatalf("got %d branches, want at least 3", len(branches))
+	}
+	middle := branches[len(branches)/2]
+	wantSkipped := Range{root.DOff(middle), root.DOff(middle + 1)}
+	encoded[root.COff(middle)+4] ^= 0x01
+
+	// In strict mode, reading fails.
+	if _, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded))); err == nil {
+		tt.Fatalf("strict mode: got nil error, want non-nil")
+	}
+
+	// In BestEffort mode, every chunk outside of the middle subtree is
+	// returned.
+	cr := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		BestEffort:     true,
+	}
+	dPos := int64(0)
+	for {
+		c, err := cr.NextChunk()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			tt.Fatalf("NextChunk: %v", err)
+		}
+		if dPos == wantSkipped[0] {
+			dPos = wantSkipped[1]
+		}
+		if c.DRange != (Range{dPos, dPos + 0x10}) {
+			tt.Fatalf("DRange: got %v, want %v", c.DRange, Range{dPos, dPos + 0x10})
+		}
+		dPos = c.DRange[1]
+	}
+	if dPos != int64(len(want)) {
+		tt.Fatalf("final DRange: got %d, want %d", dPos, len(want))
+	}
+	if got := cr.SkippedRanges(); !reflect.DeepEqual(got, []Range{wantSkipped}) {
+		tt.Fatalf("SkippedRanges: got %v, want %v", got, []Range{wantSkipped})
+	}
+
+	// The Reader serves the skipped range as zeroes.
+	r := NewReaderBytes(encoded)
+	r.CodecReaders = []CodecReader{storedCodecReader{}}
+	r.BestEffort = true
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		tt.Fatalf("ReadAll: %v", err)
+	}
+	for i := wantSkipped[0]; i < wantSkipped[1]; i++ {
+		want[i] = 0
+	}
+	if !bytes.Equal(got, want) {
+		tt.Fatalf("ReadAll: got %d bytes, want %d, or contents differ", len(got), len(want))
+	}
+	if got := r.SkippedRanges(); !reflect.DeepEqual(got, []Range{wantSkipped}) {
+		tt.Fatalf("Reader.SkippedRanges: got %v, want %v", got, []Range{wantSkipped})
+	}
+}
+
+func TestReaderChunkBoundaries(tt *testing.T) {
+	for _, s := range []string{
+		writerWantEmpty,
+		writerWantILAEnd,
+		writerWantILAStart,
+		writerWantILAStartCPageSize128,
+	} {
+		encoded := undoHexDump(s)
+		r := NewReaderBytes(encoded)
+		got, err := r.ChunkBoundaries()
+		if err != nil {
+			tt.Fatalf("ChunkBoundaries: %v", err)
+		}
+		dSize, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			tt.Fatalf("Seek: %v", err)
+		}
+		if n := len(got); (n == 0) || (got[n-1] != dSize) {
+			tt.Fatalf("got %v, want a final element of %d", got, dSize)
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i-1] >= got[i] {
+				tt.Fatalf("got %v, want strictly increasing", got)
+			}
+		}
+		r.Close()
+	}
+
+	r := NewReaderBytes(undoHexDump(writerWantILAEnd))
+	defer r.Close()
+	got, err := r.ChunkBoundaries()
+	if err != nil {
+		tt.Fatalf("ChunkBoundaries: %v", err)
+	}
+	if want := []int64{0x00, 0x11, 0x33, 0x77}; !reflect.DeepEqual(got, want) {
+		tt.Fatalf("got %#x, want %#x", got, want)
+	}
+}
+
+func TestSequentialReader(tt *testing.T) {
+	const numChunks = 300
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < numChunks; i++ {
+		data := make([]byte, 1+rng.Intn(100))
+		rng.Read(data)
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Decompress each chunk independently.
+	chunks, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+	wantHash := crc32.NewIEEE()
+	for _, c := range chunks {
+		d, err := storedCodecReader{}.MakeDecompressor(bytes.NewReader(encoded), c)
+		if err != nil {
+			tt.Fatalf("MakeDecompressor: %v", err)
+		}
+		if _, err := io.Copy(wantHash, d); err != nil {
+			tt.Fatalf("io.Copy: %v", err)
+		}
+	}
+
+	cr := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	gotHash := crc32.NewIEEE()
+	if _, err := io.Copy(gotHash, NewSequentialReader(cr, []CodecReader{storedCodecReader{}})); err != nil {
+		tt.Fatalf("io.Copy: %v", err)
+	}
+	if got, want := gotHash.Sum32(), wantHash.Sum32(); got != want {
+		tt.Fatalf("hash: got 0x%08X, want 0x%08X", got, want)
+	}
+
+	// Without a matching CodecReader, the error surfaces from Read.
+	cr = &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	if _, err := io.Copy(ioutil.Discard, NewSequentialReader(cr, nil)); err == nil {
+		tt.Fatalf("no CodecReaders: got nil error, want non-nil")
+	}
+}
+
+func TestIndexCycle(tt *testing.T) {
+	// A 32 byte RAC file whose root node (at CSpace offset 0) has one child, a
+	// branch node whose COff is also 0: the root node itself.
+	encoded := []byte{
+		0x72, 0xC3, 0x63, 0x01, 0x00, 0x00, 0x00, 0xFE, // Magic, arity, TTag.
+		0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, // DPtrMax, codec.
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, // CPtr, STag.
+		0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, // CPtrMax, version.
+	}
+	resetChecksum(encoded)
+
+	r := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	_, err := r.NextChunk()
+	if e, ok := err.(*ErrCorruptIndex); !ok {
+		tt.Fatalf("NextChunk: got %v, want an *ErrCorruptIndex", err)
+	} else if (e.NodeCOffset != 0) || (e.Child != 0) {
+		tt.Fatalf("NextChunk: got (0x%X, %d), want (0x0, 0)", e.NodeCOffset, e.Child)
+	}
+
+	r = &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		BestEffort:     true,
+	}
+	if _, err := r.NextChunk(); err != io.EOF {
+		tt.Fatalf("BestEffort NextChunk: got %v, want %v", err, io.EOF)
+	}
+	if got, want := r.SkippedRanges(), []Range{{0, 0x10}}; !reflect.DeepEqual(got, want) {
+		tt.Fatalf("SkippedRanges: got %v, want %v", got, want)
+	}
+}
+
+func TestReaderReadChunkData(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAStart)
+	chunks, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+
+	// Use a non-io.ReaderAt source, in the second iteration, to exercise the
+	// Seek-based code path.
+	sources := []io.ReadSeeker{
+		bytes.NewReader(encoded),
+		&countingReadSeeker{rs: bytes.NewReader(encoded)},
+	}
+	for i, rs := range sources {
+		r := &Reader{
+			ReadSeeker:     rs,
+			CompressedSize: int64(len(encoded)),
+		}
+		for _, c := range chunks {
+			want := make([]byte, c.CPrimary.Size())
+			if _, err := bytes.NewReader(encoded).ReadAt(want, c.CPrimary[0]); err != nil {
+				tt.Fatalf("i=%d: ReadAt: %v", i, err)
+			}
+			got := make([]byte, len(want)+5)
+			n, err := r.ReadChunkData(c, got)
+			if err != nil {
+				tt.Fatalf("i=%d: ReadChunkData: %v", i, err)
+			}
+			if !bytes.Equal(got[:n], want) {
+				tt.Fatalf("i=%d: got %q, want %q", i, got[:n], want)
+			}
+
+			if c.CPrimary.Size() > 0 {
+				if _, err := r.ReadChunkData(c, got[:len(want)-1]); err != errBufferTooSmall {
+					tt.Fatalf("i=%d: short buffer: got %v, want %v", i, err, errBufferTooSmall)
+				}
+			}
+		}
+
+		if _, err := r.ReadCSpace(Range{0, int64(len(encoded)) + 1}, nil); err != errInvalidCRange {
+			tt.Fatalf("i=%d: got %v, want %v", i, err, errInvalidCRange)
+		}
+		r.Close()
+	}
+}
+
+func TestReaderSizes(tt *testing.T) {
+	testCases := []struct {
+		encoded   string
+		wantCSize int64
+		wantDSize int64
+	}{
+		{writerWantILAEnd, 0x7C, 0x77},
+		{writerWantILAStart, 0x78, 0x77},
+		{writerWantEmpty, 0x20, 0x00},
+	}
+	for _, tc := range testCases {
+		r := NewReaderBytes(undoHexDump(tc.encoded))
+		if got := r.CompressedLen(); got != tc.wantCSize {
+			tt.Fatalf("CompressedLen: got 0x%X, want 0x%X", got, tc.wantCSize)
+		}
+		dSize, err := r.DecompressedSize()
+		if err != nil {
+			tt.Fatalf("DecompressedSize: %v", err)
+		} else if dSize != tc.wantDSize {
+			tt.Fatalf("DecompressedSize: got 0x%X, want 0x%X", dSize, tc.wantDSize)
+		}
+
+		ratio, err := r.CompressionRatio()
+		if tc.wantDSize == 0 {
+			if err != errZeroDecompressedSize {
+				tt.Fatalf("CompressionRatio: got %v, want %v", err, errZeroDecompressedSize)
+			}
+		} else if err != nil {
+			tt.Fatalf("CompressionRatio: %v", err)
+		} else if want := float64(tc.wantCSize) / float64(tc.wantDSize); ratio != want {
+			tt.Fatalf("CompressionRatio: got %v, want %v", ratio, want)
+		}
+		r.Close()
+	}
+}
+
+func TestNodeChecksum(tt *testing.T) {
+	// The root node of writerWantILAStart starts with "72 c3 63 05 8c 03".
+	encoded := undoHexDump(writerWantILAStart)
+	if got, want := NodeChecksum(encoded), uint16(0x038C); got != want {
+		tt.Fatalf("got 0x%04X, want 0x%04X", got, want)
+	}
+
+	// Changing the checksum bytes doesn't change the checksum, but changing
+	// the bytes after it does.
+	encoded[4], encoded[5] = 0, 0
+	if got, want := NodeChecksum(encoded), uint16(0x038C); got != want {
+		tt.Fatalf("zeroed: got 0x%04X, want 0x%04X", got, want)
+	}
+	encoded[8] ^= 0x01
+	if got := NodeChecksum(encoded); got == 0x038C {
+		tt.Fatalf("modified: got 0x%04X, want something else", got)
+	}
+}
+
+func TestCursorRoundTrip(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAEnd)
+	newChunkReader := func() *ChunkReader {
+		return &ChunkReader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+		}
+	}
+
+	// Save a ChunkReader's cursor after each chunk, and check that restoring
+	// it into a fresh ChunkReader yields the same next chunk.
+	r := newChunkReader()
+	for {
+		cursor, err := r.SaveCursor()
+		if err != nil {
+			tt.Fatalf("SaveCursor: %v", err)
+		}
+		want, wantErr := r.NextChunk()
+
+		s := newChunkReader()
+		if err := s.RestoreCursor(cursor); err != nil {
+			tt.Fatalf("RestoreCursor: %v", err)
+		}
+		got, gotErr := s.NextChunk()
+		if (got != want) || (gotErr != wantErr) {
+			tt.Fatalf("got (%v, %v), want (%v, %v)", got, gotErr, want, wantErr)
+		}
+		if wantErr == io.EOF {
+			break
+		} else if wantErr != nil {
+			tt.Fatalf("NextChunk: %v", wantErr)
+		}
+	}
+
+	// Likewise for a Reader, mid-chunk.
+	want := make([]byte, 0x30)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < len(want); i += 0x10 {
+		if err := w.AddChunk(0x10, storedCodec, want[i:i+0x10], 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	stored := buf.Bytes()
+
+	r0 := NewReaderBytes(stored)
+	r0.CodecReaders = []CodecReader{storedCodecReader{}}
+	defer r0.Close()
+	if _, err := io.ReadFull(r0, make([]byte, 0x15)); err != nil {
+		tt.Fatalf("ReadFull: %v", err)
+	}
+	cursor, err := r0.SaveCursor()
+	if err != nil {
+		tt.Fatalf("Reader.SaveCursor: %v", err)
+	}
+
+	r1 := NewReaderBytes(stored)
+	r1.CodecReaders = []CodecReader{storedCodecReader{}}
+	defer r1.Close()
+	if err := r1.RestoreCursor(cursor); err != nil {
+		tt.Fatalf("Reader.RestoreCursor: %v", err)
+	}
+	got, err := ioutil.ReadAll(r1)
+	if err != nil {
+		tt.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, want[0x15:]) {
+		tt.Fatalf("got %x, want %x", got, want[0x15:])
+	}
+
+	// A cursor for a different RAC file is rejected.
+	if err := newChunkReader().RestoreCursor(cursor); err != errInvalidCursor {
+		tt.Fatalf("mismatched file: got %v, want %v", err, errInvalidCursor)
+	}
+}
+
+func TestIndexStats(tt *testing.T) {
+	// A balanced, two-level tree.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < 500; i++ {
+		if err := w.AddChunk(0x10, CodecZeroes, nil, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	r := NewReaderBytes(buf.Bytes())
+	numNodes, err := r.NumNodes()
+	if err != nil {
+		tt.Fatalf("NumNodes: %v", err)
+	}
+	fanout, err := r.AverageFanout()
+	if err != nil {
+		tt.Fatalf("AverageFanout: %v", err)
+	}
+	// The root node has two branch children, holding 500 chunks between them.
+	// The root is the only branch node.
+	if (numNodes != 3) || (fanout != 502.0) {
+		tt.Fatalf("balanced: got (%d, %v), want (3, %v)", numNodes, fanout, 502.0)
+	}
+	r.Close()
+
+	// A degenerate tree: a chain of nodes, each holding one chunk and one
+	// branch child (the next node), other than the last node.
+	const numChain = 20
+	encoded := makeChainRAC(numChain)
+	r = NewReaderBytes(encoded)
+	defer r.Close()
+	numNodes, err = r.NumNodes()
+	if err != nil {
+		tt.Fatalf("chain: NumNodes: %v", err)
+	}
+	fanout, err = r.AverageFanout()
+	if err != nil {
+		tt.Fatalf("chain: AverageFanout: %v", err)
+	}
+	// Every node but the last is a branch node.
+	if want := float64(2*numChain-1) / (numChain - 1); (numNodes != numChain) || (fanout != want) {
+		tt.Fatalf("chain: got (%d, %v), want (%d, %v)", numNodes, fanout, numChain, want)
+	}
+
+	// The chain is a valid RAC file, and the index walk did not disturb the
+	// Reader's position.
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		tt.Fatalf("chain: ReadAll: %v", err)
+	} else if !bytes.Equal(got, make([]byte, numChain)) {
+		tt.Fatalf("chain: got %x, want %d zeroes", got, numChain)
+	}
+}
+
+// makeChainRAC returns a RAC file whose index is a chain of n nodes, each with
+// one 1-byte CodecZeroes chunk. Other than the last node, each node's second
+// child is a branch node: the next node in the chain.
+func makeChainRAC(n int) []byte {
+	const size2, size1 = 48, 32
+	cSize := uint64((size2 * (n - 1)) + size1)
+	encoded := make([]byte, cSize)
+	for i, b := 0, encoded; i < n; i++ {
+		arity := uint64(2)
+		if i == n-1 {
+			arity = 1
+		}
+		size := (16 * arity) + 16
+		node := b[:size]
+		b = b[size:]
+
+		copy(node, "\x72\xC3\x63")
+		node[3] = uint8(arity)
+		node[7] = 0xFF // Leaf TTag.
+		if arity == 2 {
+			putU64LE(node[8:], 1|(0xFE<<56)) // DPtr[1] and branch TTag.
+			putU64LE(node[24:], cSize|(0xFF<<56))
+			putU64LE(node[32:], uint64(cSize-uint64(len(b)))|(0xFF<<56))
+		} else {
+			putU64LE(node[16:], cSize|(0xFF<<56))
+		}
+		// DPtrMax and the Zeroes Codec.
+		putU64LE(node[8*arity:], uint64(n-i))
+		// CPtrMax, version and arity.
+		putU64LE(node[size-8:], cSize|(0x01<<48)|(arity<<56))
+		resetChecksum(node)
+	}
+	return encoded
+}
+
+func TestNextChunkIsLast(tt *testing.T) {
+	for _, s := range []string{
+		writerWantILAEnd,
+		writerWantILAStart,
+		writerWantILAStartCPageSize128,
+	} {
+		encoded := undoHexDump(s)
+		r := &ChunkReader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+		}
+		numChunks, numLast := 0, 0
+		for {
+			if _, err := r.NextChunk(); err == io.EOF {
+				break
+			} else if err != nil {
+				tt.Fatalf("NextChunk: %v", err)
+			}
+			numChunks++
+			if r.NextChunkIsLast() {
+				numLast++
+				if numChunks != 3 {
+					tt.Fatalf("NextChunkIsLast: got true after %d chunks, want 3", numChunks)
+				}
+			}
+		}
+		if numLast != 1 {
+			tt.Fatalf("NextChunkIsLast: got true %d times, want once", numLast)
+		}
+	}
+}
+
+func TestChunkWriterTargetArity(tt *testing.T) {
+	want := make([]byte, 100*7)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+
+	write := func(targetArity uint8) ([]byte, error) {
+		buf := &bytes.Buffer{}
+		w := &ChunkWriter{
+			Writer:      buf,
+			TargetArity: targetArity,
+		}
+		for i := 0; i < len(want); i += 7 {
+			if err := w.AddChunk(7, storedCodec, want[i:i+7], 0, 0); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	// indexDepth returns the number of nodes on the longest root-to-leaf path,
+	// checking that no node's arity exceeds maxArity.
+	indexDepth := func(r *Reader, cOffset int64, maxArity int) (int, error) {
+		var depth func(cOffset int64) (int, error)
+		depth = func(cOffset int64) (int, error) {
+			n, err := r.NodeAt(cOffset)
+			if err != nil {
+				return 0, err
+			}
+			if n.Arity() > maxArity {
+				return 0, fmt.Errorf("node at 0x%X has arity %d", cOffset, n.Arity())
+			}
+			ret := 0
+			for i := 0; i < n.Arity(); i++ {
+				if n.TTag(i) != 0xFE {
+					continue
+				}
+				d, err := depth(n.COff(i))
+				if err != nil {
+					return 0, err
+				}
+				if ret < d {
+					ret = d
+				}
+			}
+			return ret + 1, nil
+		}
+		return depth(cOffset)
+	}
+
+	depths := [2]int{}
+	for i, targetArity := range []uint8{4, 255} {
+		encoded, err := write(targetArity)
+		if err != nil {
+			tt.Fatalf("targetArity=%d: write: %v", targetArity, err)
+		}
+		r := NewReaderBytes(encoded)
+		r.CodecReaders = []CodecReader{storedCodecReader{}}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			tt.Fatalf("targetArity=%d: ReadAll: %v", targetArity, err)
+		} else if !bytes.Equal(got, want) {
+			tt.Fatalf("targetArity=%d: round trip mismatch", targetArity)
+		}
+		depths[i], err = indexDepth(r, r.chunkReader.rootNodeCOffset, int(targetArity))
+		if err != nil {
+			tt.Fatalf("targetArity=%d: indexDepth: %v", targetArity, err)
+		}
+		r.Close()
+	}
+	// With 100 chunks, an arity of 4 needs 4 levels of index nodes.
+	if (depths[0] != 4) || (depths[1] != 1) {
+		tt.Fatalf("depths: got %v, want [4 1]", depths)
+	}
+
+	if _, err := write(3); err != errInvalidTargetArity {
+		tt.Fatalf("targetArity=3: got %v, want %v", err, errInvalidTargetArity)
+	}
+}
+
+func TestReaderVerifyDecompressedSize(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAEnd)
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	if err := r.VerifyDecompressedSize(); err != nil {
+		tt.Fatalf("well-formed: %v", err)
+	}
+
+	// makeChainRAC(3)'s nodes are at 0, 48 and 96 bytes in. The first two have
+	// arity 2 and the third has arity 1. Each node is valid by itself, so
+	// walking the index succeeds, but the leaves' DSizes no longer add up.
+	testCases := []struct {
+		name   string
+		mutate func(b []byte)
+	}{{
+		// Shrinking the third node's only chunk leaves [2, 3) uncovered.
+		name: "gap",
+		mutate: func(b []byte) {
+			putU64LE(b[96+8:], 0)
+			resetChecksum(b[96:])
+		},
+	}, {
+		// The root's DPtrMax claims 10 bytes but its leaves cover 3.
+		name: "overstated",
+		mutate: func(b []byte) {
+			putU64LE(b[16:], 10)
+			resetChecksum(b[:48])
+		},
+	}}
+
+	for _, tc := range testCases {
+		for _, bestEffort := range []bool{false, true} {
+			encoded := makeChainRAC(3)
+			r := &Reader{
+				ReadSeeker:     bytes.NewReader(encoded),
+				CompressedSize: int64(len(encoded)),
+			}
+			if err := r.VerifyDecompressedSize(); err != nil {
+				tt.Fatalf("%s: unmutated: %v", tc.name, err)
+			}
+
+			tc.mutate(encoded)
+			r = &Reader{
+				ReadSeeker:     bytes.NewReader(encoded),
+				CompressedSize: int64(len(encoded)),
+				BestEffort:     bestEffort,
+			}
+			if _, err := r.IndexExtent(); err != nil {
+				tt.Fatalf("%s: IndexExtent: %v", tc.name, err)
+			}
+			if err := r.VerifyDecompressedSize(); !isCorruptIndex(err) {
+				tt.Errorf("%s, bestEffort=%t: got %v, want a corrupt index error",
+					tc.name, bestEffort, err)
+			}
+		}
+	}
+}
+
+func TestReaderBaseOffset(tt *testing.T) {
+	const dSize = 0x30
+	want := make([]byte, dSize)
+	for i := range want {
+		want[i] = byte(i)
+	}
+
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < dSize; i += 0x10 {
+		if err := w.AddChunk(0x10, storedCodec, want[i:i+0x10], 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Embed the RAC file between 100 bytes of junk and some more junk.
+	const baseOffset = 100
+	container := bytes.Repeat([]byte{0xEE}, baseOffset)
+	container = append(container, encoded...)
+	container = append(container, "more junk"...)
+
+	// Use a non-io.ReaderAt source, in the second iteration, to exercise the
+	// Seek-based code path. The third iteration is concurrent.
+	sources := []io.ReadSeeker{
+		bytes.NewReader(container),
+		&countingReadSeeker{rs: bytes.NewReader(container)},
+		bytes.NewReader(container),
+	}
+	for i, rs := range sources {
+		r := &Reader{
+			ReadSeeker:     rs,
+			CompressedSize: int64(len(encoded)),
+			BaseOffset:     baseOffset,
+			CodecReaders:   []CodecReader{storedCodecReader{}},
+		}
+		if i == 2 {
+			r.Concurrency = 2
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			tt.Fatalf("i=%d: ReadAll: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			tt.Fatalf("i=%d: got %x, want %x", i, got, want)
+		}
+
+		gotCSpace := make([]byte, 4)
+		if _, err := r.ReadCSpace(Range{0, 4}, gotCSpace); err != nil {
+			tt.Fatalf("i=%d: ReadCSpace: %v", i, err)
+		} else if !bytes.Equal(gotCSpace, encoded[:4]) {
+			tt.Fatalf("i=%d: ReadCSpace: got %x, want %x", i, gotCSpace, encoded[:4])
+		}
+	}
+
+	// Without the BaseOffset, the junk is not a RAC file.
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(container),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		tt.Fatalf("no BaseOffset: got nil error, want non-nil")
+	}
+
+	r = &Reader{
+		ReadSeeker:     bytes.NewReader(container),
+		CompressedSize: int64(len(encoded)),
+		BaseOffset:     -1,
+	}
+	if _, err := r.DecompressedSize(); err != errInvalidBaseOffset {
+		tt.Fatalf("negative BaseOffset: got %v, want %v", err, errInvalidBaseOffset)
+	}
+}
+
+func TestChunkEqualAndLess(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAEnd)
+	gotChunks, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+
+	wantChunks := []Chunk{{
+		DRange:     Range{0x00, 0x11},
+		CPrimary:   Range{0x09, 0x7C},
+		CSecondary: Range{0x7C, 0x7C},
+		CTertiary:  Range{0x7C, 0x7C},
+		STag:       0xFF,
+		TTag:       0xFF,
+		Codec:      CodecZlib,
+	}, {
+		DRange:     Range{0x11, 0x33},
+		CPrimary:   Range{0x0C, 0x7C},
+		CSecondary: Range{0x04, 0x7C},
+		CTertiary:  Range{0x7C, 0x7C},
+		STag:       0x00,
+		TTag:       0xFF,
+		Codec:      CodecZlib,
+	}, {
+		DRange:     Range{0x33, 0x77},
+		CPrimary:   Range{0x10, 0x7C},
+		CSecondary: Range{0x04, 0x7C},
+		CTertiary:  Range{0x07, 0x7C},
+		STag:       0x00,
+		TTag:       0x01,
+		Codec:      CodecZlib,
+	}}
+
+	if len(gotChunks) != len(wantChunks) {
+		tt.Fatalf("len(chunks): got %d, want %d", len(gotChunks), len(wantChunks))
+	}
+	for i := range gotChunks {
+		if !gotChunks[i].Equal(wantChunks[i]) {
+			tt.Errorf("chunk #%d:\ngot  %+v\nwant %+v", i, gotChunks[i], wantChunks[i])
+		}
+	}
+
+	// Changing any one field makes the chunks unequal.
+	c := wantChunks[2]
+	for i, mutate := range []func(*Chunk){
+		func(c *Chunk) { c.DRange[1]++ },
+		func(c *Chunk) { c.CPrimary[0]++ },
+		func(c *Chunk) { c.CSecondary[0]++ },
+		func(c *Chunk) { c.CTertiary[0]++ },
+		func(c *Chunk) { c.STag++ },
+		func(c *Chunk) { c.TTag++ },
+		func(c *Chunk) { c.Codec = CodecLZ4 },
+	} {
+		d := c
+		mutate(&d)
+		if c.Equal(d) || d.Equal(c) {
+			tt.Errorf("mutation #%d: got equal, want unequal", i)
+		}
+	}
+
+	shuffled := []Chunk{wantChunks[2], wantChunks[0], wantChunks[1]}
+	sort.Slice(shuffled, func(i int, j int) bool {
+		return ChunkLess(shuffled[i], shuffled[j])
+	})
+	for i := range shuffled {
+		if !shuffled[i].Equal(wantChunks[i]) {
+			tt.Errorf("sorted chunk #%d: got DRange %v, want %v",
+				i, shuffled[i].DRange, wantChunks[i].DRange)
+		}
+	}
+	if ChunkLess(wantChunks[1], wantChunks[1]) {
+		tt.Errorf("ChunkLess(c, c): got true, want false")
+	}
+}
+
+func TestChunkReaderPeekChunk(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAStart)
+	wantChunks, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+
+	r := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	for i, want := range wantChunks {
+		cursor, err := r.SaveCursor()
+		if err != nil {
+			tt.Fatalf("i=%d: SaveCursor: %v", i, err)
+		}
+
+		peek0, err := r.PeekChunk()
+		if err != nil {
+			tt.Fatalf("i=%d: PeekChunk #0: %v", i, err)
+		}
+		peek1, err := r.PeekChunk()
+		if err != nil {
+			tt.Fatalf("i=%d: PeekChunk #1: %v", i, err)
+		}
+		if !peek0.Equal(peek1) {
+			tt.Fatalf("i=%d: PeekChunks disagree:\n%+v\n%+v", i, peek0, peek1)
+		}
+
+		// Peeking does not move the cursor.
+		if peekCursor, err := r.SaveCursor(); err != nil {
+			tt.Fatalf("i=%d: SaveCursor: %v", i, err)
+		} else if !bytes.Equal(peekCursor, cursor) {
+			tt.Fatalf("i=%d: cursor: got %x, want %x", i, peekCursor, cursor)
+		}
+
+		got, err := r.NextChunk()
+		if err != nil {
+			tt.Fatalf("i=%d: NextChunk: %v", i, err)
+		}
+		if !got.Equal(peek0) || !got.Equal(want) {
+			tt.Fatalf("i=%d: NextChunk:\ngot  %+v\npeek %+v\nwant %+v", i, got, peek0, want)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.PeekChunk(); err != io.EOF {
+			tt.Fatalf("PeekChunk #%d at EOF: got %v, want %v", i, err, io.EOF)
+		}
+	}
+	if !r.NextChunkIsLast() {
+		tt.Fatalf("NextChunkIsLast: got false, want true")
+	}
+	if _, err := r.NextChunk(); err != io.EOF {
+		tt.Fatalf("NextChunk at EOF: got %v, want %v", err, io.EOF)
+	}
+
+	// Seeking discards the peeked chunk.
+	if err := r.SeekToChunkContaining(0); err != nil {
+		tt.Fatalf("SeekToChunkContaining: %v", err)
+	}
+	if _, err := r.PeekChunk(); err != nil {
+		tt.Fatalf("PeekChunk: %v", err)
+	}
+	if err := r.SeekToChunkContaining(wantChunks[2].DRange[0]); err != nil {
+		tt.Fatalf("SeekToChunkContaining: %v", err)
+	}
+	if got, err := r.NextChunk(); err != nil {
+		tt.Fatalf("NextChunk: %v", err)
+	} else if !got.Equal(wantChunks[2]) {
+		tt.Fatalf("NextChunk after seek:\ngot  %+v\nwant %+v", got, wantChunks[2])
+	}
+}
+
+func TestReaderRootNodeLocation(tt *testing.T) {
+	testCases := []struct {
+		name        string
+		encoded     string
+		wantOffset  int64
+		wantFromEnd bool
+	}{
+		{"ILAStart", writerWantILAStart, 0x00, false},
+		{"ILAEnd", writerWantILAEnd, 0x1C, true},
+	}
+
+	for _, tc := range testCases {
+		encoded := undoHexDump(tc.encoded)
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+		}
+		offset, fromEnd, err := r.RootNodeLocation()
+		if err != nil {
+			tt.Fatalf("%s: %v", tc.name, err)
+		}
+		if (offset != tc.wantOffset) || (fromEnd != tc.wantFromEnd) {
+			tt.Errorf("%s: got (0x%X, %t), want (0x%X, %t)",
+				tc.name, offset, fromEnd, tc.wantOffset, tc.wantFromEnd)
+		}
+		if fromEnd {
+			root := encoded[offset:]
+			if got, want := int64(len(root)), int64(nodeSize(root[3])); got != want {
+				tt.Errorf("%s: root node is %d bytes from the end, want %d", tc.name, got, want)
+			}
+		}
+	}
+}
+
+// makeMixedCodecRAC returns a RAC file whose root node has the Mix Bit and
+// one branch node child per element of codecs. Each child is a leaf node
+// holding one 1-byte chunk with that Codec.
+func makeMixedCodecRAC(codecs []Codec) []byte {
+	const childSize = 32
+	arity := uint64(len(codecs))
+	rootSize := (16 * arity) + 16
+	cSize := rootSize + (childSize * arity)
+	encoded := make([]byte, cSize)
+
+	root := encoded[:rootSize]
+	copy(root, "\x72\xC3\x63")
+	root[3] = uint8(arity)
+	root[7] = 0xFE // Branch TTag.
+	for i := uint64(1); i < arity; i++ {
+		putU64LE(root[8*i:], i|(0xFE<<56)) // DPtr[i] and branch TTag.
+	}
+	// DPtrMax and the Mix Bit.
+	putU64LE(root[8*arity:], arity|(0x40<<56))
+	for i := uint64(0); i < arity; i++ {
+		putU64LE(root[(8*arity)+8+(8*i):], (rootSize+(childSize*i))|(0xFF<<56))
+	}
+	// CPtrMax, version and arity.
+	putU64LE(root[rootSize-8:], cSize|(0x01<<48)|(arity<<56))
+	resetChecksum(root)
+
+	for i, codec := range codecs {
+		child := encoded[rootSize+(childSize*uint64(i)):][:childSize]
+		copy(child, "\x72\xC3\x63")
+		child[3] = 1
+		child[7] = 0xFF // Leaf TTag.
+		// DPtrMax and the (short) Codec.
+		putU64LE(child[8:], 1|uint64(codec))
+		putU64LE(child[16:], 0|(0xFF<<56))
+		// CPtrMax, version and arity.
+		putU64LE(child[24:], cSize|(0x01<<48)|(1<<56))
+		resetChecksum(child)
+	}
+	return encoded
+}
+
+func TestChunkReaderSkipChunks(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{Writer: buf, TargetArity: 4}
+	// The resource adds empty chunks, which SkipChunks does not count.
+	res, err := w.AddResource([]byte("resource"))
+	if err != nil {
+		tt.Fatalf("AddResource: %v", err)
+	}
+	const numChunks = 13
+	for i := 0; i < numChunks; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d;", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, res, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	newChunkReader := func(start int, peek bool) *ChunkReader {
+		r := &ChunkReader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+		}
+		for i := 0; i < start; i++ {
+			if _, err := r.NextChunk(); err != nil {
+				tt.Fatalf("NextChunk: %v", err)
+			}
+		}
+		if peek {
+			if _, err := r.PeekChunk(); err != nil {
+				tt.Fatalf("PeekChunk: %v", err)
+			}
+		}
+		return r
+	}
+
+	for _, start := range []int{0, 3, 5} {
+		for _, peek := range []bool{false, true} {
+			for n := 0; n <= numChunks+1; n++ {
+				r0 := newChunkReader(start, peek)
+				gotErr := r0.SkipChunks(int64(n))
+
+				r1 := newChunkReader(start, peek)
+				wantErr := error(nil)
+				for i := 0; i < n; i++ {
+					if _, err := r1.NextChunk(); err != nil {
+						wantErr = err
+						break
+					}
+				}
+				if gotErr != wantErr {
+					tt.Fatalf("start=%d, peek=%t, n=%d: SkipChunks: got %v, want %v",
+						start, peek, n, gotErr, wantErr)
+				}
+
+				got, gotErr := r0.NextChunk()
+				want, wantErr := r1.NextChunk()
+				if (gotErr != wantErr) || !got.Equal(want) {
+					tt.Fatalf("start=%d, peek=%t, n=%d: NextChunk:\ngot  %+v, %v\nwant %+v, %v",
+						start, peek, n, got, gotErr, want, wantErr)
+				}
+			}
+		}
+	}
+
+	r := newChunkReader(0, false)
+	if err := r.SkipChunks(-1); err == nil {
+		tt.Fatalf("SkipChunks(-1): got nil error, want non-nil")
+	}
+}
+
+func TestChunkReaderNextChunkCodec(tt *testing.T) {
+	codecs := []Codec{CodecZlib, CodecLZ4, CodecZlib, CodecLZ4, CodecZstandard}
+	encoded := makeMixedCodecRAC(codecs)
+	r := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+
+	for i, want := range codecs {
+		got, err := r.NextChunkCodec()
+		if err != nil {
+			tt.Fatalf("i=%d: NextChunkCodec: %v", i, err)
+		}
+		if got != want {
+			tt.Fatalf("i=%d: NextChunkCodec: got 0x%X, want 0x%X", i, got, want)
+		}
+		c, err := r.NextChunk()
+		if err != nil {
+			tt.Fatalf("i=%d: NextChunk: %v", i, err)
+		}
+		if (c.Codec != got) || (c.DRange != Range{int64(i), int64(i + 1)}) {
+			tt.Fatalf("i=%d: NextChunk: got %+v, want Codec 0x%X", i, c, got)
+		}
+	}
+	if _, err := r.NextChunkCodec(); err != io.EOF {
+		tt.Fatalf("NextChunkCodec at EOF: got %v, want %v", err, io.EOF)
+	}
+
+	// Seeking resolves to a different leaf.
+	if err := r.SeekToChunkContaining(3); err != nil {
+		tt.Fatalf("SeekToChunkContaining: %v", err)
+	}
+	if got, err := r.NextChunkCodec(); (got != codecs[3]) || (err != nil) {
+		tt.Fatalf("after seek: got (0x%X, %v), want (0x%X, nil)", got, err, codecs[3])
+	}
+}
+
+func TestReaderTertiaryDictionaryFor(tt *testing.T) {
+	const numChunks = 20
+	const secondary, tertiary = "secondary dictionary", "tertiary dictionary"
+
+	// A small TargetArity gives a multi-level index, so that resolving the
+	// dictionaries' CSpace ranges involves the CBias of branch nodes.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	res2, err := w.AddResource([]byte(secondary))
+	if err != nil {
+		tt.Fatalf("AddResource: %v", err)
+	}
+	res3, err := w.AddResource([]byte(tertiary))
+	if err != nil {
+		tt.Fatalf("AddResource: %v", err)
+	}
+	for i := 0; i < numChunks; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, res2, res3); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	chunks, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+	if len(chunks) != numChunks {
+		tt.Fatalf("len(chunks): got %d, want %d", len(chunks), numChunks)
+	}
+
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	for i, c := range chunks {
+		if want := fmt.Sprintf("chunk #%02d", i); !bytes.HasPrefix(encoded[c.CPrimary[0]:], []byte(want)) {
+			tt.Fatalf("i=%d: CPrimary %v does not start with %q", i, c.CPrimary, want)
+		}
+		if !bytes.HasPrefix(encoded[c.CSecondary[0]:], []byte(secondary)) {
+			tt.Fatalf("i=%d: CSecondary %v does not start with %q", i, c.CSecondary, secondary)
+		}
+		got, err := r.TertiaryDictionaryFor(c)
+		if err != nil {
+			tt.Fatalf("i=%d: TertiaryDictionaryFor: %v", i, err)
+		}
+		if !bytes.HasPrefix(got, []byte(tertiary)) {
+			tt.Fatalf("i=%d: got %q, want a prefix of %q", i, got, tertiary)
+		}
+	}
+
+	// A chunk without a tertiary resource has no tertiary dictionary.
+	ilaEnd := undoHexDump(writerWantILAEnd)
+	r = &Reader{
+		ReadSeeker:     bytes.NewReader(ilaEnd),
+		CompressedSize: int64(len(ilaEnd)),
+	}
+	c := Chunk{CTertiary: Range{0x7C, 0x7C}, TTag: 0xFF}
+	if got, err := r.TertiaryDictionaryFor(c); (got != nil) || (err != nil) {
+		tt.Fatalf("no tertiary: got (%q, %v), want (nil, nil)", got, err)
+	}
+	c.CTertiary = Range{0x70, 0x80}
+	if _, err := r.TertiaryDictionaryFor(c); err != errInvalidCRange {
+		tt.Fatalf("out of bounds: got %v, want %v", err, errInvalidCRange)
+	}
+}
+
+func TestSeekToChunkIndex(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAStart)
+	wantChunks, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+	n := int64(len(wantChunks))
+
+	cr := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	for _, i := range []int64{0, n / 2, n - 1, 0} {
+		if err := cr.SeekToChunkIndex(i); err != nil {
+			tt.Fatalf("ChunkReader: SeekToChunkIndex(%d): %v", i, err)
+		}
+		if got, err := cr.NextChunk(); err != nil {
+			tt.Fatalf("ChunkReader: i=%d: NextChunk: %v", i, err)
+		} else if !got.Equal(wantChunks[i]) {
+			tt.Fatalf("ChunkReader: i=%d:\ngot  %+v\nwant %+v", i, got, wantChunks[i])
+		}
+	}
+	// An out of range index is an error and leaves the position unchanged.
+	for _, i := range []int64{n, -1} {
+		if err := cr.SeekToChunkIndex(i); err != errInvalidChunkIndex {
+			tt.Fatalf("ChunkReader: SeekToChunkIndex(%d): got %v, want %v", i, err, errInvalidChunkIndex)
+		}
+	}
+	if got, err := cr.NextChunk(); err != nil {
+		tt.Fatalf("ChunkReader: NextChunk: %v", err)
+	} else if !got.Equal(wantChunks[1]) {
+		tt.Fatalf("ChunkReader: after errors:\ngot  %+v\nwant %+v", got, wantChunks[1])
+	}
+
+	// The Reader positions Read at the start of the chunk.
+	const dSize = 0x30
+	want := make([]byte, dSize)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < dSize; i += 0x10 {
+		if err := w.AddChunk(0x10, storedCodec, want[i:i+0x10], 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	stored := buf.Bytes()
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(stored),
+		CompressedSize: int64(len(stored)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	}
+	for _, i := range []int64{0, 1, 2} {
+		if err := r.SeekToChunkIndex(i); err != nil {
+			tt.Fatalf("Reader: SeekToChunkIndex(%d): %v", i, err)
+		}
+		got := []byte{0}
+		if _, err := io.ReadFull(r, got); err != nil {
+			tt.Fatalf("Reader: i=%d: ReadFull: %v", i, err)
+		} else if wantByte := want[0x10*i]; got[0] != wantByte {
+			tt.Fatalf("Reader: i=%d: got 0x%02X, want 0x%02X", i, got[0], wantByte)
+		}
+	}
+	if err := r.SeekToChunkIndex(3); err != errInvalidChunkIndex {
+		tt.Fatalf("Reader: SeekToChunkIndex(3): got %v, want %v", err, errInvalidChunkIndex)
+	}
+}
+
+func TestReaderDecompressedRangeOf(tt *testing.T) {
+	// A multi-level index, with chunks of varying sizes.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	wantRanges := []Range(nil)
+	dPos := int64(0)
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d%s", i, strings.Repeat("!", i%3)))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+		wantRanges = append(wantRanges, Range{dPos, dPos + int64(len(data))})
+		dPos += int64(len(data))
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	}
+	defer r.Close()
+	if _, err := r.Seek(5, io.SeekStart); err != nil {
+		tt.Fatalf("Seek: %v", err)
+	}
+	for _, n := range []int64{0, 11, 19} {
+		got, err := r.DecompressedRangeOf(n)
+		if err != nil {
+			tt.Fatalf("n=%d: %v", n, err)
+		} else if got != wantRanges[n] {
+			tt.Fatalf("n=%d: got %v, want %v", n, got, wantRanges[n])
+		}
+	}
+	for _, n := range []int64{-1, 20} {
+		if _, err := r.DecompressedRangeOf(n); err != errInvalidChunkIndex {
+			tt.Fatalf("n=%d: got %v, want %v", n, err, errInvalidChunkIndex)
+		}
+	}
+
+	// The position for Read is unchanged.
+	if got, err := ioutil.ReadAll(r); err != nil {
+		tt.Fatalf("ReadAll: %v", err)
+	} else if !bytes.HasPrefix(got, []byte(" #00chunk #01!")) {
+		tt.Fatalf("ReadAll: got %q, want a \" #00chunk #01!\" prefix", got)
+	}
+}
+
+func TestReaderMetrics(tt *testing.T) {
+	const dSize = 0x30
+	want := make([]byte, dSize)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < dSize; i += 0x10 {
+		if err := w.AddChunk(0x10, storedCodec, want[i:i+0x10], 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	m := &Metrics{}
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+		Metrics:        m,
+	}
+
+	// Finding the (IndexLocationAtEnd) root node reads the first 4 bytes, the
+	// last byte and then the root node itself, which is validated.
+	rootSize := int64(nodeSize(3))
+	if _, err := r.DecompressedSize(); err != nil {
+		tt.Fatalf("DecompressedSize: %v", err)
+	}
+	if got, want := *m, (Metrics{ReadCalls: 3, BytesRead: 4 + 1 + rootSize, NodesValidated: 1}); got != want {
+		tt.Fatalf("after initialization:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// Seeking into the last chunk and reading it does not re-load the root
+	// node, the only node. The whole 0x10 byte chunk is read, even though the
+	// first 8 decompressed bytes are skipped.
+	prev := *m
+	if _, err := r.Seek(0x28, io.SeekStart); err != nil {
+		tt.Fatalf("Seek: %v", err)
+	}
+	if got, err := ioutil.ReadAll(r); err != nil {
+		tt.Fatalf("ReadAll: %v", err)
+	} else if !bytes.Equal(got, want[0x28:]) {
+		tt.Fatalf("ReadAll: got %x, want %x", got, want[0x28:])
+	}
+	if m.NodesValidated != prev.NodesValidated {
+		tt.Fatalf("NodesValidated: got %d, want %d", m.NodesValidated, prev.NodesValidated)
+	}
+	if got, want := m.BytesRead-prev.BytesRead, int64(0x10); got != want {
+		tt.Fatalf("BytesRead delta: got %d, want %d", got, want)
+	}
+
+	prev = *m
+	if _, err := r.ReadCSpace(Range{0, 4}, make([]byte, 4)); err != nil {
+		tt.Fatalf("ReadCSpace: %v", err)
+	}
+	if got, want := *m, (Metrics{prev.ReadCalls + 1, prev.BytesRead + 4, prev.NodesValidated}); got != want {
+		tt.Fatalf("after ReadCSpace:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// A multi-level index validates one node per level per seek.
+	chain := makeChainRAC(3)
+	m = &Metrics{}
+	cr := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(chain),
+		CompressedSize: int64(len(chain)),
+		Metrics:        m,
+	}
+	if err := cr.SeekToChunkContaining(2); err != nil {
+		tt.Fatalf("SeekToChunkContaining: %v", err)
+	}
+	if _, err := cr.NextChunk(); err != nil {
+		tt.Fatalf("NextChunk: %v", err)
+	}
+	if got, want := m.NodesValidated, int64(3); got != want {
+		tt.Fatalf("chain: NodesValidated: got %d, want %d", got, want)
+	}
+}
+
+type closeCountingReadSeeker struct {
+	io.ReadSeeker
+	closes int
+}
+
+func (r *closeCountingReadSeeker) Close() error {
+	r.closes++
+	return nil
+}
+
+func TestReaderCloseReadSeeker(tt *testing.T) {
+	encoded := undoHexDump(writerWantILAStart)
+	for _, closeReadSeeker := range []bool{false, true} {
+		for _, withoutWaiting := range []bool{false, true} {
+			rs := &closeCountingReadSeeker{ReadSeeker: bytes.NewReader(encoded)}
+			r := &Reader{
+				ReadSeeker:      rs,
+				CompressedSize:  int64(len(encoded)),
+				CloseReadSeeker: closeReadSeeker,
+			}
+			if _, err := r.Seek(5, io.SeekStart); err != nil {
+				tt.Fatalf("Seek: %v", err)
+			}
+
+			closeFunc := r.Close
+			if withoutWaiting {
+				closeFunc = r.CloseWithoutWaiting
+			}
+			if err := closeFunc(); err != nil {
+				tt.Fatalf("first Close: %v", err)
+			}
+			if err := closeFunc(); err != errAlreadyClosed {
+				tt.Fatalf("second Close: got %v, want %v", err, errAlreadyClosed)
+			}
+			wantCloses := 0
+			if closeReadSeeker && !withoutWaiting {
+				wantCloses = 1
+			}
+			if rs.closes != wantCloses {
+				tt.Fatalf("closeReadSeeker=%t, withoutWaiting=%t: closes: got %d, want %d",
+					closeReadSeeker, withoutWaiting, rs.closes, wantCloses)
+			}
+
+			if _, err := r.Read(make([]byte, 1)); err != errAlreadyClosed {
+				tt.Fatalf("Read: got %v, want %v", err, errAlreadyClosed)
+			}
+			if _, err := r.Seek(0, io.SeekStart); err != errAlreadyClosed {
+				tt.Fatalf("Seek: got %v, want %v", err, errAlreadyClosed)
+			}
+			if _, err := r.DecompressedSize(); err != errAlreadyClosed {
+				tt.Fatalf("DecompressedSize: got %v, want %v", err, errAlreadyClosed)
+			}
+		}
+	}
+}
+
+// countingCodecReader wraps storedCodecReader, counting (across all of its
+// clones) how many decompressors it has made.
+type countingCodecReader struct {
+	storedCodecReader
+	n *int64
+}
+
+func (c countingCodecReader) Clone() CodecReader { return c }
+func (c countingCodecReader) MakeDecompressor(racFile io.ReadSeeker, chunk Chunk) (io.Reader, error) {
+	atomic.AddInt64(c.n, 1)
+	return c.storedCodecReader.MakeDecompressor(racFile, chunk)
+}
+
+// boundedWriter checks, on every Write, that no more than maxAhead chunks
+// have been decompressed beyond those already written.
+type boundedWriter struct {
+	buf         bytes.Buffer
+	numWrites   int64
+	maxAhead    int64
+	numMade     *int64
+	maxSeenMade int64
+	err         error
+}
+
+func (w *boundedWriter) Write(p []byte) (int, error) {
+	// Give the workers a chance to run ahead of this (slow) Writer.
+	time.Sleep(time.Millisecond)
+	n := atomic.LoadInt64(w.numMade)
+	if ahead := n - w.numWrites; ahead > w.maxAhead {
+		w.err = fmt.Errorf("write #%d: %d chunks ahead, want at most %d", w.numWrites, ahead, w.maxAhead)
+	}
+	w.numWrites++
+	return w.buf.Write(p)
+}
+
+func TestReaderVerifyFunc(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	wantCRCs := map[int64]uint32{}
+	dPos := int64(0)
+	for i := 0; i < 6; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+		wantCRCs[dPos] = crc32.ChecksumIEEE(data)
+		dPos += int64(len(data))
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	original := buf.Bytes()
+
+	errMismatch := fmt.Errorf("CRC mismatch")
+	verify := func(dRangeStart int64, decompressed []byte) error {
+		if crc32.ChecksumIEEE(decompressed) != wantCRCs[dRangeStart] {
+			return errMismatch
+		}
+		return nil
+	}
+
+	// Mutate one chunk's compressed (stored) data. The index is unchanged, so
+	// nothing but the VerifyFunc can notice.
+	mutated := append([]byte(nil), original...)
+	if i := bytes.Index(mutated, []byte("chunk #03")); i < 0 {
+		tt.Fatalf("could not find chunk #03")
+	} else {
+		mutated[i] = 'C'
+	}
+
+	testCases := []struct {
+		name    string
+		encoded []byte
+		wantErr error
+	}{
+		{"original", original, nil},
+		{"mutated", mutated, errMismatch},
+	}
+	for _, concurrency := range []int{0, 2} {
+		for _, tc := range testCases {
+			r := &Reader{
+				ReadSeeker:     bytes.NewReader(tc.encoded),
+				CompressedSize: int64(len(tc.encoded)),
+				CodecReaders:   []CodecReader{storedCodecReader{}},
+				Concurrency:    concurrency,
+				VerifyFunc:     verify,
+			}
+			got, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != tc.wantErr {
+				tt.Fatalf("c=%d, %s: ReadAll: got %v, want %v", concurrency, tc.name, err, tc.wantErr)
+			} else if bytes.Contains(got, []byte("Chunk #03")) {
+				tt.Fatalf("c=%d, %s: got %q, which contains the mutated chunk", concurrency, tc.name, got)
+			} else if want := "chunk #00chunk #01chunk #02chunk #03chunk #04chunk #05"; (err == nil) && (string(got) != want) {
+				tt.Fatalf("c=%d, %s: got %q, want %q", concurrency, tc.name, got, want)
+			}
+		}
+	}
+}
+
+func TestReaderAvailablePrefix(tt *testing.T) {
+	// Index the file at its start, as for a download in progress.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:        buf,
+		IndexLocation: IndexLocationAtStart,
+		TempFile:      &bytes.Buffer{},
+	}
+	for i := 0; i < 4; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Only the index and the first two chunks are available.
+	prefix := int64(bytes.Index(encoded, []byte("chunk #02")))
+	if prefix < 0 {
+		tt.Fatalf("could not find chunk #02")
+	}
+	r := &Reader{
+		ReadSeeker:      bytes.NewReader(encoded),
+		CompressedSize:  int64(len(encoded)),
+		CodecReaders:    []CodecReader{storedCodecReader{}},
+		AvailablePrefix: prefix,
+	}
+	defer r.Close()
+
+	// The chunk metadata is all available.
+	chunks, err := r.ChunksInRange(Range{0, 36})
+	if err != nil {
+		tt.Fatalf("ChunksInRange: %v", err)
+	} else if len(chunks) != 4 {
+		tt.Fatalf("ChunksInRange: got %d chunks, want 4", len(chunks))
+	}
+
+	// The raw CSpace bytes are only available up to the prefix.
+	cSpace := make([]byte, len(encoded))
+	if n, err := r.ReadCSpace(Range{0, prefix}, cSpace); err != nil {
+		tt.Fatalf("ReadCSpace: %v", err)
+	} else if !bytes.Equal(cSpace[:n], encoded[:prefix]) {
+		tt.Fatalf("ReadCSpace: got %x, want %x", cSpace[:n], encoded[:prefix])
+	}
+	if _, err := r.ReadCSpace(Range{0, prefix + 1}, cSpace); err != ErrNotYetAvailable {
+		tt.Fatalf("ReadCSpace: got %v, want %v", err, ErrNotYetAvailable)
+	}
+	if _, err := r.ReadChunkData(chunks[3], cSpace); err != ErrNotYetAvailable {
+		tt.Fatalf("ReadChunkData: got %v, want %v", err, ErrNotYetAvailable)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != ErrNotYetAvailable {
+		tt.Fatalf("ReadAll: got %v, want %v", err, ErrNotYetAvailable)
+	} else if want := "chunk #00chunk #01"; string(got) != want {
+		tt.Fatalf("ReadAll: got %q, want %q", got, want)
+	}
+	if _, err := r.Read(make([]byte, 1)); err != ErrNotYetAvailable {
+		tt.Fatalf("Read: got %v, want %v", err, ErrNotYetAvailable)
+	}
+
+	// Once the rest arrives, reading resumes where it stopped.
+	r.AvailablePrefix = int64(len(encoded))
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		tt.Fatalf("ReadAll: %v", err)
+	} else if want := "chunk #02chunk #03"; string(got) != want {
+		tt.Fatalf("ReadAll: got %q, want %q", got, want)
+	}
+}
+
+func TestReaderEffectiveCodec(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	want := []byte(nil)
+	for i := 0; i < 8; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+		want = append(want, data...)
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	rootCOffset, _, err := r.RootNodeLocation()
+	if err != nil {
+		tt.Fatalf("RootNodeLocation: %v", err)
+	}
+
+	// The ChunkWriter does not support multiple Codecs, so change the root
+	// node's Codec Byte to CodecZeroes with the Mix Bit set, like it would for
+	// branch nodes with different Codecs. The leaf nodes keep the storedCodec.
+	root := encoded[rootCOffset:]
+	if root[7] != 0xFE {
+		tt.Fatalf("root node's first child is not a branch node, want a two-level index")
+	}
+	root[(8*int(root[3]))+7] = 0x40
+	resetChecksum(root)
+
+	r = &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	}
+	defer r.Close()
+	if n, err := r.NodeAt(rootCOffset); err != nil {
+		tt.Fatalf("NodeAt: %v", err)
+	} else if !n.CodecHasMixBit() || (n.Codec() != CodecZeroes) {
+		tt.Fatalf("root node: got Codec 0x%X, Mix Bit %t, want CodecZeroes, true",
+			n.Codec(), n.CodecHasMixBit())
+	}
+
+	chunks, err := r.ChunksInRange(Range{0, int64(len(want))})
+	if err != nil {
+		tt.Fatalf("ChunksInRange: %v", err)
+	} else if len(chunks) != 8 {
+		tt.Fatalf("ChunksInRange: got %d chunks, want 8", len(chunks))
+	}
+	for i, c := range chunks {
+		if got := r.EffectiveCodec(c); got != storedCodec {
+			tt.Fatalf("chunk #%d: got 0x%X, want 0x%X", i, got, storedCodec)
+		}
+	}
+
+	if got, err := ioutil.ReadAll(r); err != nil {
+		tt.Fatalf("ReadAll: %v", err)
+	} else if !bytes.Equal(got, want) {
+		tt.Fatalf("ReadAll: got %q, want %q", got, want)
+	}
+}
+
+// hintingCodecReader wraps storedCodecReader, logging the chunks that it
+// decompresses.
+type hintingCodecReader struct {
+	storedCodecReader
+	log *[]string
+}
+
+func (c hintingCodecReader) Clone() CodecReader { return c }
+func (c hintingCodecReader) MakeDecompressor(racFile io.ReadSeeker, chunk Chunk) (io.Reader, error) {
+	*c.log = append(*c.log, fmt.Sprintf("read %d", chunk.DRange[0]))
+	return c.storedCodecReader.MakeDecompressor(racFile, chunk)
+}
+
+func TestReaderPrefetchHint(tt *testing.T) {
+	// A multi-level index, so that reading moves between leaf nodes.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	log := []string(nil)
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{hintingCodecReader{log: &log}},
+		PrefetchHint: func(next Chunk) {
+			log = append(log, fmt.Sprintf("hint %d", next.DRange[0]))
+		},
+	}
+	defer r.Close()
+	chunks, err := r.ChunksInRange(Range{0, 90})
+	if err != nil {
+		tt.Fatalf("ChunksInRange: %v", err)
+	}
+	if _, err := r.Seek(27, io.SeekStart); err != nil {
+		tt.Fatalf("Seek: %v", err)
+	}
+	log = nil
+	if _, err := ioutil.ReadAll(r); err != nil {
+		tt.Fatalf("ReadAll: %v", err)
+	}
+
+	// Every chunk from the seek position onwards is hinted once, before it is
+	// read. Each hint is for that chunk or a later one in the same leaf node.
+	hinted := map[int64]bool{}
+	numHints := 0
+	for i, entry := range log {
+		dOff := int64(0)
+		if _, err := fmt.Sscanf(entry[5:], "%d", &dOff); err != nil {
+			tt.Fatalf("log entry %q: %v", entry, err)
+		}
+		if strings.HasPrefix(entry, "hint") {
+			if hinted[dOff] {
+				tt.Fatalf("log entry #%d %q: hinted more than once", i, entry)
+			}
+			hinted[dOff] = true
+			numHints++
+		} else if !hinted[dOff] {
+			tt.Fatalf("log entry #%d %q: not hinted before it was read, log %q", i, entry, log)
+		}
+	}
+	for _, c := range chunks {
+		if (c.DRange[0] >= 27) != hinted[c.DRange[0]] {
+			tt.Fatalf("chunk at %d: hinted %t", c.DRange[0], hinted[c.DRange[0]])
+		}
+	}
+	if numHints != 7 {
+		tt.Fatalf("number of hints: got %d, want 7", numHints)
+	}
+}
+
+func TestLockingReader(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{Writer: buf, TargetArity: 4}
+	want := []byte(nil)
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("#%02d chunk;", i))
+		want = append(want, data...)
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	l := NewLockingReader(&Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	})
+
+	const numGoroutines = 8
+	errc := make(chan error, numGoroutines)
+	for g := 0; g < numGoroutines; g++ {
+		go func(g int) {
+			rng := rand.New(rand.NewSource(int64(g)))
+			for i := 0; i < 200; i++ {
+				switch rng.Intn(4) {
+				case 0:
+					lo := rng.Int63n(int64(len(want)))
+					hi := lo + rng.Int63n(int64(len(want))-lo+1)
+					got := make([]byte, hi-lo)
+					if _, err := l.ReadAt(got, lo); err != nil {
+						errc <- fmt.Errorf("ReadAt(%d, %d): %v", lo, hi, err)
+						return
+					} else if !bytes.Equal(got, want[lo:hi]) {
+						errc <- fmt.Errorf("ReadAt(%d, %d): got %q, want %q", lo, hi, got, want[lo:hi])
+						return
+					}
+				case 1:
+					if _, err := l.Seek(rng.Int63n(int64(len(want))), io.SeekStart); err != nil {
+						errc <- fmt.Errorf("Seek: %v", err)
+						return
+					}
+				case 2:
+					if err := l.SeekToChunkIndex(rng.Int63n(20)); err != nil {
+						errc <- fmt.Errorf("SeekToChunkIndex: %v", err)
+						return
+					}
+				case 3:
+					if _, err := l.Read(make([]byte, 7)); (err != nil) && (err != io.EOF) {
+						errc <- fmt.Errorf("Read: %v", err)
+						return
+					}
+				}
+				if dSize, err := l.DecompressedSize(); err != nil {
+					errc <- fmt.Errorf("DecompressedSize: %v", err)
+					return
+				} else if dSize != int64(len(want)) {
+					errc <- fmt.Errorf("DecompressedSize: got %d, want %d", dSize, len(want))
+					return
+				}
+			}
+			errc <- nil
+		}(g)
+	}
+	for g := 0; g < numGoroutines; g++ {
+		if err := <-errc; err != nil {
+			tt.Error(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+}
+
+func TestReaderAllChunks(tt *testing.T) {
+	encode := func(add func(w *ChunkWriter) error) ([]byte, []Chunk) {
+		buf := &bytes.Buffer{}
+		w := &ChunkWriter{Writer: buf, TargetArity: 4}
+		if err := add(w); err != nil {
+			tt.Fatalf("add: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			tt.Fatalf("Close: %v", err)
+		}
+		encoded := buf.Bytes()
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+			CodecReaders:   []CodecReader{storedCodecReader{}},
+		}
+		chunks, err := r.AllChunks()
+		if err != nil {
+			tt.Fatalf("AllChunks: %v", err)
+		}
+		return encoded, chunks
+	}
+
+	encodedA, chunksA := encode(func(w *ChunkWriter) error {
+		// A CPrimary range can extend past its data, so this test's resource
+		// (like, in practice, a codec's dictionary) is length-prefixed.
+		res, err := w.AddResource([]byte("\x0Adictionary"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 7; i++ {
+			data := []byte(fmt.Sprintf("chunk #%d;", i))
+			secondary := OptResource(0)
+			if i%2 == 1 {
+				secondary = res
+			}
+			if err := w.AddChunk(uint64(len(data)), storedCodec, data, secondary, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	numEmpty := 0
+	for _, c := range chunksA {
+		if c.DRange.Empty() {
+			numEmpty++
+		}
+	}
+	if numEmpty == 0 {
+		tt.Fatalf("AllChunks: got no empty (resource) chunks")
+	}
+
+	// Transcode: re-add each resource (once, although each leaf node that
+	// uses it refers to it) and chunk, in order, mapping each resource's
+	// CSpace offset in file A to its OptResource in file B.
+	rA := &Reader{
+		ReadSeeker:     bytes.NewReader(encodedA),
+		CompressedSize: int64(len(encodedA)),
+	}
+	encodedB, chunksB := encode(func(w *ChunkWriter) error {
+		resources := map[int64]OptResource{}
+		for _, c := range chunksA {
+			primary := make([]byte, c.CPrimary.Size())
+			if _, err := rA.ReadCSpace(c.CPrimary, primary); err != nil {
+				return err
+			}
+			if c.DRange.Empty() {
+				if _, ok := resources[c.CPrimary[0]]; ok {
+					continue
+				}
+				res, err := w.AddResource(primary[:1+int(primary[0])])
+				if err != nil {
+					return err
+				}
+				resources[c.CPrimary[0]] = res
+				continue
+			}
+			primary = primary[:c.DRange.Size()]
+			secondary, tertiary := OptResource(0), OptResource(0)
+			if !c.CSecondary.Empty() {
+				secondary = resources[c.CSecondary[0]]
+			}
+			if !c.CTertiary.Empty() {
+				tertiary = resources[c.CTertiary[0]]
+			}
+			if err := w.AddChunk(uint64(c.DRange.Size()), c.Codec, primary, secondary, tertiary); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if !reflect.DeepEqual(chunksA, chunksB) {
+		tt.Fatalf("AllChunks:\nA: %v\nB: %v", chunksA, chunksB)
+	}
+	if !bytes.Equal(encodedA, encodedB) {
+		tt.Fatalf("encoded files differ")
+	}
+}
+
+func TestReaderIsStandalone(tt *testing.T) {
+	// A RAC file with one index node, whose chunks share a dictionary.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{Writer: buf}
+	res, err := w.AddResource([]byte("dictionary"))
+	if err != nil {
+		tt.Fatalf("AddResource: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		data := []byte(fmt.Sprintf("chunk #%d;", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, res, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	isStandalone := func(encoded []byte) (bool, error) {
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+		}
+		return r.IsStandalone()
+	}
+	if got, err := isStandalone(encoded); err != nil {
+		tt.Fatalf("IsStandalone: %v", err)
+	} else if !got {
+		tt.Fatalf("IsStandalone: got false, want true")
+	}
+
+	// Point the dictionary (the root node's first child, which the chunks'
+	// STags refer to) past the end of the file.
+	corrupt := append([]byte(nil), encoded...)
+	root := corrupt[len(corrupt)-nodeSize(corrupt[len(corrupt)-1]):]
+	arity := int(root[3])
+	if (root[(8*arity)+15] != 0xFF) || (root[(8*arity)+23] != 0x00) {
+		tt.Fatalf("root node: child 0 is not the shared dictionary")
+	}
+	cPtr := (8 * arity) + 8
+	putU64LE(root[cPtr:], uint64(len(corrupt)+100)|(uint64(root[cPtr+6])<<48)|(uint64(root[cPtr+7])<<56))
+	resetChecksum(root)
+	if got, err := isStandalone(corrupt); err != nil {
+		tt.Fatalf("external reference: IsStandalone: %v", err)
+	} else if got {
+		tt.Fatalf("external reference: IsStandalone: got true, want false")
+	}
+
+	// A RAC file with four index nodes, whose three non-root nodes each hold a
+	// copy of the dictionary reference. Point the first such node's dictionary
+	// past the end of the file.
+	buf = &bytes.Buffer{}
+	w = &ChunkWriter{Writer: buf, TargetArity: 4}
+	if res, err = w.AddResource([]byte("dictionary")); err != nil {
+		tt.Fatalf("AddResource: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf("chunk #%d;", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, res, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	deep := buf.Bytes()
+	if got, err := isStandalone(deep); err != nil {
+		tt.Fatalf("deep: IsStandalone: %v", err)
+	} else if !got {
+		tt.Fatalf("deep: IsStandalone: got false, want true")
+	}
+	extent, err := NewReaderBytes(deep).IndexExtent()
+	if err != nil {
+		tt.Fatalf("deep: IndexExtent: %v", err)
+	}
+	corrupt = append([]byte(nil), deep...)
+	node := corrupt[extent[0] : extent[0]+int64(nodeSize(corrupt[extent[0]+3]))]
+	arity = int(node[3])
+	if (extent[0]+int64(len(node)) == int64(len(corrupt))) || (node[(8*arity)+15] != 0xFF) || (node[(8*arity)+23] != 0x00) {
+		tt.Fatalf("deep: first node: child 0 is not the shared dictionary")
+	}
+	cPtr = (8 * arity) + 8
+	putU64LE(node[cPtr:], uint64(len(corrupt)+100)|(uint64(node[cPtr+6])<<48)|(uint64(node[cPtr+7])<<56))
+	resetChecksum(node)
+	if got, err := isStandalone(corrupt); err != nil {
+		tt.Fatalf("deep: external reference: IsStandalone: %v", err)
+	} else if got {
+		tt.Fatalf("deep: external reference: IsStandalone: got true, want false")
+	}
+
+	// Other corruption, such as a bad checksum, is an error.
+	corrupt = append([]byte(nil), encoded...)
+	corrupt[len(corrupt)-nodeSize(corrupt[len(corrupt)-1])+4] ^= 0xFF
+	if got, err := isStandalone(corrupt); err == nil {
+		tt.Fatalf("bad checksum: IsStandalone: got nil error, want non-nil")
+	} else if got {
+		tt.Fatalf("bad checksum: IsStandalone: got true, want false")
+	}
+}
+
+// TestReaderInspectIndexWhileReading interleaves reading with the methods that
+// inspect the index. With positive Concurrency, the Reader's own ChunkReader
+// belongs to other goroutines, so run this test with -race.
+func TestReaderInspectIndexWhileReading(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{Writer: buf, TargetArity: 4}
+	want := []byte(nil)
+	for i := 0; i < 200; i++ {
+		data := []byte(fmt.Sprintf("chunk #%03d;", i))
+		want = append(want, data...)
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	inspections := []struct {
+		name string
+		f    func(r *Reader) error
+	}{
+		{"NodeAt", func(r *Reader) error {
+			rootNodeCOffset, _, err := r.RootNodeLocation()
+			if err == nil {
+				_, err = r.NodeAt(rootNodeCOffset)
+			}
+			return err
+		}},
+		{"IndexExtent", func(r *Reader) error {
+			_, err := r.IndexExtent()
+			return err
+		}},
+		{"AverageFanout", func(r *Reader) error {
+			_, err := r.AverageFanout()
+			return err
+		}},
+		{"VerifyCodecHierarchy", func(r *Reader) error {
+			return r.VerifyCodecHierarchy()
+		}},
+		{"AllChunks", func(r *Reader) error {
+			_, err := r.AllChunks()
+			return err
+		}},
+		{"ChunkBoundaries", func(r *Reader) error {
+			_, err := r.ChunkBoundaries()
+			return err
+		}},
+		{"DecompressedRangeOf", func(r *Reader) error {
+			_, err := r.DecompressedRangeOf(150)
+			return err
+		}},
+		{"IsStandalone", func(r *Reader) error {
+			if ok, err := r.IsStandalone(); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("IsStandalone: got false, want true")
+			}
+			return nil
+		}},
+	}
+
+	for _, concurrency := range []int{0, 2} {
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+			CodecReaders:   []CodecReader{storedCodecReader{}},
+			Concurrency:    concurrency,
+		}
+		got := []byte(nil)
+		for i := 0; len(got) < len(want); i++ {
+			// Read less than a chunk at a time, so that decompression (and,
+			// with positive Concurrency, the index walk) is in progress.
+			p := make([]byte, 7)
+			n, err := r.Read(p)
+			got = append(got, p[:n]...)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				tt.Fatalf("c=%d: Read: %v", concurrency, err)
+			}
+			x := inspections[i%len(inspections)]
+			if err := x.f(r); err != nil {
+				tt.Fatalf("c=%d: %s: %v", concurrency, x.name, err)
+			}
+		}
+		if !bytes.Equal(got, want) {
+			tt.Fatalf("c=%d: got %q, want %q", concurrency, got, want)
+		}
+		r.Close()
+	}
+}
+
+func TestReaderDecompressPipelined(tt *testing.T) {
+	const dSize = 0x400
+	want := make([]byte, dSize)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	for i := 0; i < dSize; i += 0x40 {
+		if err := w.AddChunk(0x40, storedCodec, want[i:i+0x40], 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Decompress sequentially, for comparison.
+	{
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+			CodecReaders:   []CodecReader{storedCodecReader{}},
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			tt.Fatalf("ReadAll: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			tt.Fatalf("sequential: got %x, want %x", got, want)
+		}
+	}
+
+	for _, workers := range []int{0, 1, 3, 100} {
+		numMade := int64(0)
+		maxAhead := int64(workers)
+		if maxAhead < 1 {
+			maxAhead = 1
+		}
+		bw := &boundedWriter{maxAhead: maxAhead, numMade: &numMade}
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(encoded),
+			CompressedSize: int64(len(encoded)),
+			CodecReaders:   []CodecReader{countingCodecReader{n: &numMade}},
+		}
+		if _, err := r.Seek(0x123, io.SeekStart); err != nil {
+			tt.Fatalf("workers=%d: Seek: %v", workers, err)
+		}
+		if err := r.DecompressPipelined(context.Background(), bw, workers); err != nil {
+			tt.Fatalf("workers=%d: DecompressPipelined: %v", workers, err)
+		}
+		if bw.err != nil {
+			tt.Fatalf("workers=%d: %v", workers, bw.err)
+		}
+		if got := bw.buf.Bytes(); !bytes.Equal(got, want) {
+			tt.Fatalf("workers=%d: got %x, want %x", workers, got, want)
+		}
+		if got, want := bw.numWrites, int64(dSize/0x40); got != want {
+			tt.Fatalf("workers=%d: numWrites: got %d, want %d", workers, got, want)
+		}
+
+		// The Reader's position is unchanged.
+		if got, err := ioutil.ReadAll(r); err != nil {
+			tt.Fatalf("workers=%d: ReadAll: %v", workers, err)
+		} else if !bytes.Equal(got, want[0x123:]) {
+			tt.Fatalf("workers=%d: ReadAll: got %x, want %x", workers, got, want[0x123:])
+		}
+		r.Close()
+	}
+
+	// A canceled context stops the pipeline.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	}
+	if err := r.DecompressPipelined(ctx, ioutil.Discard, 2); err != context.Canceled {
+		tt.Fatalf("canceled: got %v, want %v", err, context.Canceled)
+	}
+
+	// More than 1 worker requires an io.ReaderAt.
+	r = &Reader{
+		ReadSeeker:     &countingReadSeeker{rs: bytes.NewReader(encoded)},
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	}
+	if err := r.DecompressPipelined(context.Background(), ioutil.Discard, 2); err == nil {
+		tt.Fatalf("non-ReaderAt: got nil error, want non-nil")
+	}
+}
+
+func TestNewReaderBytesSubSlicesNodes(tt *testing.T) {
+	encoded := makeChainRAC(3)
+	want, err := ioutil.ReadAll(&Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	})
+	if err != nil {
+		tt.Fatalf("bytes.NewReader: ReadAll: %v", err)
+	}
+
+	r := NewReaderBytes(encoded)
+	r.CodecReaders = []CodecReader{storedCodecReader{}}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		tt.Fatalf("NewReaderBytes: ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		tt.Fatalf("NewReaderBytes: got %x, want %x", got, want)
+	}
+
+	// The current node's bytes are those of the RAC file, not a copy.
+	cr := &r.chunkReader
+	if cr.src == nil {
+		tt.Fatalf("src: got nil, want non-nil")
+	}
+	found := false
+	for i := range encoded {
+		if &encoded[i] == &cr.currNode[0] {
+			found = true
+			break
+		}
+	}
+	if !found {
+		tt.Fatalf("currNode is not a sub-slice of the RAC file")
+	}
+	if got, want := len(cr.currNode), nodeSize(cr.currNode[3]); got != want {
+		tt.Fatalf("len(currNode): got %d, want %d", got, want)
+	}
+}
+
+func TestReaderMaxArity(tt *testing.T) {
+	// A RAC file whose root node (at the start) has arity 4.
+	const dSize = 0x40
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:        buf,
+		IndexLocation: IndexLocationAtStart,
+		TempFile:      &bytes.Buffer{},
+	}
+	for i := 0; i < 4; i++ {
+		if err := w.AddChunk(dSize/4, CodecZeroes, nil, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	arity4 := buf.Bytes()
+	if arity4[3] != 4 {
+		tt.Fatalf("root node arity: got %d, want 4", arity4[3])
+	}
+
+	// A hand-built RAC file whose only node has arity 200, each child being a
+	// 1 byte CodecZeroes chunk.
+	const arity = 200
+	const size = (16 * arity) + 16
+	arity200 := make([]byte, size)
+	copy(arity200, magic)
+	arity200[3] = arity
+	arity200[7] = 0xFF // Leaf TTag. DPtr[0] is implicitly zero.
+	for i := 0; i < arity; i++ {
+		if i > 0 {
+			putU64LE(arity200[8*i:], uint64(i)|(0xFF<<56)) // DPtr and leaf TTag.
+		}
+		putU64LE(arity200[(8*arity)+8+(8*i):], uint64(size)|(0xFF<<56)) // CPtr and STag.
+	}
+	putU64LE(arity200[8*arity:], arity) // DPtrMax and the Zeroes Codec.
+	putU64LE(arity200[size-8:], uint64(size)|(0x01<<48)|(arity<<56))
+	resetChecksum(arity200)
+
+	testCases := []struct {
+		encoded  []byte
+		maxArity uint8
+		wantErr  bool
+		wantSize int64
+	}{
+		{arity4, 0, false, dSize},
+		{arity4, 16, false, dSize},
+		{arity4, 3, true, 0},
+		{arity200, 0, false, arity},
+		{arity200, 16, true, 0},
+	}
+	for _, tc := range testCases {
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(tc.encoded),
+			CompressedSize: int64(len(tc.encoded)),
+			MaxArity:       tc.maxArity,
+		}
+		got, err := ioutil.ReadAll(r)
+		if tc.wantErr {
+			if e, ok := err.(*ErrCorruptIndex); !ok {
+				tt.Errorf("arity=%d, maxArity=%d: got %v, want an *ErrCorruptIndex",
+					tc.encoded[3], tc.maxArity, err)
+			} else if (e.NodeCOffset != 0) || (e.Child != -1) {
+				tt.Errorf("arity=%d, maxArity=%d: got %+v, want NodeCOffset 0, Child -1",
+					tc.encoded[3], tc.maxArity, e)
+			}
+			continue
+		}
+		if err != nil {
+			tt.Errorf("arity=%d, maxArity=%d: %v", tc.encoded[3], tc.maxArity, err)
+		} else if int64(len(got)) != tc.wantSize {
+			tt.Errorf("arity=%d, maxArity=%d: size: got %d, want %d",
+				tc.encoded[3], tc.maxArity, len(got), tc.wantSize)
+		}
+	}
+}
+
+func TestReaderMaxDecompressedSize(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{Writer: buf, TargetArity: 4}
+	want := []byte(nil)
+	for i := 0; i < 6; i++ {
+		data := []byte(fmt.Sprintf("chunk #%d;", i))
+		want = append(want, data...)
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+	dSize := int64(len(want))
+
+	testCases := []struct {
+		maxDecompressedSize int64
+		wantErr             bool
+	}{
+		{0, false},
+		{dSize, false},
+		{dSize + 1, false},
+		{dSize - 1, true},
+		{1, true},
+	}
+	for _, tc := range testCases {
+		numMade := int64(0)
+		r := &Reader{
+			ReadSeeker:          bytes.NewReader(encoded),
+			CompressedSize:      int64(len(encoded)),
+			CodecReaders:        []CodecReader{countingCodecReader{n: &numMade}},
+			MaxDecompressedSize: tc.maxDecompressedSize,
+		}
+		got, err := ioutil.ReadAll(r)
+		if tc.wantErr {
+			if err != ErrDecompressedSizeTooLarge {
+				tt.Errorf("max=%d: got %v, want %v", tc.maxDecompressedSize, err, ErrDecompressedSizeTooLarge)
+			} else if numMade != 0 {
+				tt.Errorf("max=%d: made %d decompressors, want 0", tc.maxDecompressedSize, numMade)
+			}
+			continue
+		}
+		if err != nil {
+			tt.Errorf("max=%d: %v", tc.maxDecompressedSize, err)
+		} else if !bytes.Equal(got, want) {
+			tt.Errorf("max=%d: got %q, want %q", tc.maxDecompressedSize, got, want)
+		}
+	}
+}
+
+func TestCodecContainsUnion(tt *testing.T) {
+	long := Codec(0x8000_0000_326F_646D)
+	mixed := codecMixBit | CodecZeroes
+
+	testCases := []struct {
+		outer, inner Codec
+		want         bool
+	}{
+		{CodecZlib, CodecZlib, true},
+		{CodecZlib, CodecLZ4, false},
+		{CodecZstandard, CodecLZ4, false},
+		{CodecZeroes, CodecZlib, false},
+		{long, long, true},
+		{long, CodecZlib, false},
+		{mixed, CodecZlib, true},
+		{mixed, long, true},
+		{CodecZlib, codecMixBit | CodecZlib, true},
+		{CodecZlib, mixed, false},
+	}
+	for _, tc := range testCases {
+		if got := tc.outer.Contains(tc.inner); got != tc.want {
+			tt.Errorf("0x%016X.Contains(0x%016X): got %t, want %t", uint64(tc.outer), uint64(tc.inner), got, tc.want)
+		}
+		// The relation checked when loading a branch node's child.
+		parent, parentHasMixBit := tc.outer&^codecMixBit, (tc.outer&codecMixBit) != 0
+		if got := parentChildCodecsValid(parent, tc.inner&^codecMixBit, parentHasMixBit); got != tc.want {
+			tt.Errorf("parentChildCodecsValid(0x%016X, 0x%016X): got %t, want %t",
+				uint64(tc.outer), uint64(tc.inner), got, tc.want)
+		}
+	}
+
+	// Folding Union over a node's children gives a Codec that Contains them all.
+	unionTestCases := []struct {
+		children []Codec
+		want     Codec
+	}{
+		{[]Codec{CodecZlib}, CodecZlib},
+		{[]Codec{CodecZlib, CodecZlib, CodecZlib}, CodecZlib},
+		{[]Codec{long, long}, long},
+		{[]Codec{CodecZlib, CodecLZ4}, mixed},
+		{[]Codec{CodecZlib, mixed, CodecZlib}, mixed},
+		{[]Codec{mixed, CodecZeroes}, mixed},
+		{[]Codec{CodecZlib, long}, mixed},
+	}
+	for _, tc := range unionTestCases {
+		got := tc.children[0]
+		for _, c := range tc.children[1:] {
+			got = got.Union(c)
+		}
+		if got != tc.want {
+			tt.Errorf("Union(%v): got 0x%016X, want 0x%016X", tc.children, uint64(got), uint64(tc.want))
+		}
+		for _, c := range tc.children {
+			if !got.Contains(c) {
+				tt.Errorf("Union(%v): result does not contain 0x%016X", tc.children, uint64(c))
+			}
+		}
+	}
+}
+
+func TestChunkTagKinds(tt *testing.T) {
+	testCases := []struct {
+		sTag, tTag   uint8
+		wantS, wantT TagKind
+	}{
+		{0x00, 0x00, TagKindChunkRef, TagKindChunkRef},
+		{0x03, 0xBF, TagKindChunkRef, TagKindChunkRef},
+		{0xFF, 0xC0, TagKindNone, TagKindReserved},
+		{0xFF, 0xFC, TagKindNone, TagKindReserved},
+		{0xFF, 0xFD, TagKindNone, TagKindReserved},
+		{0xFF, 0xFF, TagKindNone, TagKindNone},
+	}
+	for _, tc := range testCases {
+		c := Chunk{STag: tc.sTag, TTag: tc.tTag}
+		if got := c.SecondaryTagKind(); got != tc.wantS {
+			tt.Errorf("STag 0x%02X: got %d, want %d", tc.sTag, got, tc.wantS)
+		}
+		if got := c.TertiaryTagKind(); got != tc.wantT {
+			tt.Errorf("TTag 0x%02X: got %d, want %d", tc.tTag, got, tc.wantT)
+		}
+	}
+
+	// Chunks written with and without shared resources.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	res, err := w.AddResource([]byte("dictionary"))
+	if err != nil {
+		tt.Fatalf("AddResource: %v", err)
+	}
+	if err := w.AddChunk(1, storedCodec, []byte("a"), res, res); err != nil {
+		tt.Fatalf("AddChunk: %v", err)
+	}
+	if err := w.AddChunk(1, storedCodec, []byte("b"), 0, 0); err != nil {
+		tt.Fatalf("AddChunk: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+	chunks, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+	if len(chunks) != 2 {
+		tt.Fatalf("len(chunks): got %d, want 2", len(chunks))
+	}
+	for i, want := range []TagKind{TagKindChunkRef, TagKindNone} {
+		c := chunks[i]
+		if got := c.SecondaryTagKind(); got != want {
+			tt.Errorf("chunk #%d: SecondaryTagKind: got %d, want %d", i, got, want)
+		}
+		if got := c.TertiaryTagKind(); got != want {
+			tt.Errorf("chunk #%d: TertiaryTagKind: got %d, want %d", i, got, want)
+		}
+		if gotEmpty := c.CSecondary.Empty(); gotEmpty != (want == TagKindNone) {
+			tt.Errorf("chunk #%d: CSecondary.Empty: got %t", i, gotEmpty)
+		}
+	}
+}
+
+func TestChunkWriterAddDictionary(tt *testing.T) {
+	dicts := []string{"first dictionary", "second dictionary"}
+	chunkDicts := []int{0, 1, 0}
+
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer: buf,
+	}
+	ids := map[OptResource]bool{}
+	for i, d := range chunkDicts {
+		id, err := w.AddDictionary([]byte(dicts[d]))
+		if err != nil {
+			tt.Fatalf("AddDictionary: %v", err)
+		}
+		ids[id] = true
+		data := []byte(fmt.Sprintf("chunk #%d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, id, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	if len(ids) != len(dicts) {
+		tt.Fatalf("distinct OptResources: got %d, want %d", len(ids), len(dicts))
+	}
+	for _, d := range dicts {
+		if n := bytes.Count(encoded, []byte(d)); n != 1 {
+			tt.Fatalf("copies of %q: got %d, want 1", d, n)
+		}
+	}
+
+	chunks, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+	if len(chunks) != len(chunkDicts) {
+		tt.Fatalf("len(chunks): got %d, want %d", len(chunks), len(chunkDicts))
+	}
+	r := NewReaderBytes(encoded)
+	for i, c := range chunks {
+		got, err := r.SecondaryDictionaryFor(c)
+		if err != nil {
+			tt.Fatalf("i=%d: SecondaryDictionaryFor: %v", i, err)
+		}
+		if want := dicts[chunkDicts[i]]; !bytes.HasPrefix(got, []byte(want)) {
+			tt.Fatalf("i=%d: got %q, want a prefix of %q", i, got, want)
+		}
+		if got, err := r.TertiaryDictionaryFor(c); (got != nil) || (err != nil) {
+			tt.Fatalf("i=%d: TertiaryDictionaryFor: got (%q, %v), want (nil, nil)", i, got, err)
+		}
+	}
+}
+
+func TestReaderLastChunk(tt *testing.T) {
+	// A multi-level index, from a small TargetArity.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	multiLevel := buf.Bytes()
+
+	testCases := []struct {
+		name    string
+		encoded []byte
+	}{
+		{"single chunk", undoHexDump(writerWantILAEnd)},
+		{"multi-level", multiLevel},
+		{"chain", makeChainRAC(5)},
+	}
+	for _, tc := range testCases {
+		for _, concurrency := range []int{0, 2} {
+			chunks, err := readAllChunks(bytes.NewReader(tc.encoded), int64(len(tc.encoded)))
+			if err != nil {
+				tt.Fatalf("%s: readAllChunks: %v", tc.name, err)
+			}
+			r := &Reader{
+				ReadSeeker:     bytes.NewReader(tc.encoded),
+				CompressedSize: int64(len(tc.encoded)),
+				CodecReaders:   []CodecReader{storedCodecReader{}},
+				Concurrency:    concurrency,
+			}
+			got, err := r.LastChunk()
+			if err != nil {
+				tt.Fatalf("%s, c=%d: LastChunk: %v", tc.name, concurrency, err)
+			}
+			size, err := r.DecompressedSize()
+			if err != nil {
+				tt.Fatalf("%s, c=%d: DecompressedSize: %v", tc.name, concurrency, err)
+			}
+			if got.DRange[1] != size {
+				tt.Fatalf("%s, c=%d: DRange[1]: got %d, want %d", tc.name, concurrency, got.DRange[1], size)
+			}
+			if want := chunks[len(chunks)-1]; got != want {
+				tt.Fatalf("%s, c=%d: got %v, want %v", tc.name, concurrency, got, want)
+			}
+			if pos, err := r.Seek(0, io.SeekCurrent); (err != nil) || (pos != size) {
+				tt.Fatalf("%s, c=%d: position: got (%d, %v), want (%d, nil)", tc.name, concurrency, pos, err, size)
+			}
+			r.Close()
+		}
+	}
+
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(undoHexDump(writerWantEmpty)),
+		CompressedSize: int64(len(undoHexDump(writerWantEmpty))),
+	}
+	if _, err := r.LastChunk(); err != io.EOF {
+		tt.Fatalf("empty: got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReaderApproximateChunkIndexAt(tt *testing.T) {
+	// A two-level index, from a small TargetArity.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	for i := 0; i < 12; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+		CodecReaders:   []CodecReader{storedCodecReader{}},
+	}
+	defer r.Close()
+	rootCOffset, _, err := r.RootNodeLocation()
+	if err != nil {
+		tt.Fatalf("RootNodeLocation: %v", err)
+	}
+	root, err := r.NodeAt(rootCOffset)
+	if err != nil {
+		tt.Fatalf("NodeAt: %v", err)
+	}
+	numBranches := 0
+	for i := 0; i < root.Arity(); i++ {
+		if root.TTag(i) == 0xFE {
+			numBranches++
+		}
+	}
+	if numBranches == 0 {
+		tt.Fatalf("root node has no branch children, want a two-level index")
+	}
+
+	size, err := r.DecompressedSize()
+	if err != nil {
+		tt.Fatalf("DecompressedSize: %v", err)
+	}
+	for dOff := int64(0); dOff < size; dOff++ {
+		got, err := r.ApproximateChunkIndexAt(dOff)
+		if err != nil {
+			tt.Fatalf("dOff=%d: ApproximateChunkIndexAt: %v", dOff, err)
+		}
+		chunks, err := r.ChunksInRange(Range{dOff, dOff + 1})
+		if (err != nil) || (len(chunks) != 1) {
+			tt.Fatalf("dOff=%d: ChunksInRange: got (%d chunks, %v), want (1, nil)", dOff, len(chunks), err)
+		}
+		subtree := Range{root.DOff(got), root.DOff(got + 1)}
+		if dr := chunks[0].DRange; (dr[0] < subtree[0]) || (subtree[1] < dr[1]) {
+			tt.Fatalf("dOff=%d: root child #%d covers %v, but the exact chunk covers %v", dOff, got, subtree, dr)
+		}
+	}
+
+	if _, err := r.ApproximateChunkIndexAt(size); err != io.EOF {
+		tt.Fatalf("dOff=size: got %v, want %v", err, io.EOF)
+	}
+	if _, err := r.ApproximateChunkIndexAt(-1); err == nil {
+		tt.Fatalf("dOff=-1: got nil error, want non-nil")
+	}
+}
+
+func TestReaderVerifyCodecHierarchy(tt *testing.T) {
+	// A two-level index, whose nodes all have the storedCodec, without the Mix
+	// Bit.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	for i := 0; i < 12; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	if err := r.VerifyCodecHierarchy(); err != nil {
+		tt.Fatalf("original: VerifyCodecHierarchy: %v", err)
+	}
+	rootCOffset, _, err := r.RootNodeLocation()
+	if err != nil {
+		tt.Fatalf("RootNodeLocation: %v", err)
+	}
+	root, err := r.NodeAt(rootCOffset)
+	if err != nil {
+		tt.Fatalf("NodeAt: %v", err)
+	}
+	child := -1
+	for i := 0; i < root.Arity(); i++ {
+		if root.TTag(i) == 0xFE {
+			child = i
+			break
+		}
+	}
+	if child < 0 {
+		tt.Fatalf("root node has no branch children, want a two-level index")
+	}
+
+	// Change that child node's Codec Byte from storedCodec (0x3F) to CodecZlib
+	// (0x01), a bit that its parent does not have.
+	corrupt := append([]byte(nil), encoded...)
+	node := corrupt[root.COff(child):]
+	node[(8*int(node[3]))+7] = 0x01
+	resetChecksum(node)
+
+	r = &Reader{
+		ReadSeeker:     bytes.NewReader(corrupt),
+		CompressedSize: int64(len(corrupt)),
+	}
+	err = r.VerifyCodecHierarchy()
+	if e, ok := err.(*ErrCorruptIndex); !ok {
+		tt.Fatalf("corrupt: got %v, want an *ErrCorruptIndex", err)
+	} else if want := (ErrCorruptIndex{NodeCOffset: rootCOffset, Child: child}); *e != want {
+		tt.Fatalf("corrupt: got %v, want %v", e, &want)
+	}
+}
+
+func TestReaderBuildOffsetIndex(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	for i := 0; i < 40; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d%s", i, strings.Repeat("?", i%7)))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	defer r.Close()
+	x, err := r.BuildOffsetIndex()
+	if err != nil {
+		tt.Fatalf("BuildOffsetIndex: %v", err)
+	}
+	if got := x.Len(); got != 40 {
+		tt.Fatalf("Len: got %d, want 40", got)
+	}
+
+	cr := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	dSize, err := cr.DecompressedSize()
+	if err != nil {
+		tt.Fatalf("DecompressedSize: %v", err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		dOff := rng.Int63n(dSize)
+		want, err := cr.ChunkContaining(dOff)
+		if err != nil {
+			tt.Fatalf("dOff=%d: ChunkContaining: %v", dOff, err)
+		}
+		if got, ok := x.Lookup(dOff); !ok || (got != want) {
+			tt.Fatalf("dOff=%d: got (%v, %t), want (%v, true)", dOff, got, ok, want)
+		}
+	}
+
+	for _, dOff := range []int64{-1, dSize, dSize + 1} {
+		if _, ok := x.Lookup(dOff); ok {
+			tt.Errorf("dOff=%d: got ok, want !ok", dOff)
+		}
+	}
+}
+
+func TestReaderValidationErrors(tt *testing.T) {
+	// A three-level index, from a small TargetArity.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	for i := 0; i < 40; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Find a node two levels below the root.
+	r := &Reader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	cOffset, _, err := r.RootNodeLocation()
+	if err != nil {
+		tt.Fatalf("RootNodeLocation: %v", err)
+	}
+	for depth := 0; depth < 2; depth++ {
+		n, err := r.NodeAt(cOffset)
+		if err != nil {
+			tt.Fatalf("depth=%d: NodeAt: %v", depth, err)
+		}
+		i := 0
+		for ; (i < n.Arity()) && (n.TTag(i) != 0xFE); i++ {
+		}
+		if i == n.Arity() {
+			tt.Fatalf("depth=%d: node has no branch children, want a three-level index", depth)
+		}
+		cOffset = n.COff(i)
+	}
+
+	// Corrupt that node's checksum.
+	corrupt := append([]byte(nil), encoded...)
+	corrupt[cOffset+4] ^= 0xFF
+
+	for _, bestEffort := range []bool{false, true} {
+		r := &Reader{
+			ReadSeeker:     bytes.NewReader(corrupt),
+			CompressedSize: int64(len(corrupt)),
+			CodecReaders:   []CodecReader{storedCodecReader{}},
+			BestEffort:     bestEffort,
+		}
+		if got := r.ValidationErrors(); len(got) != 0 {
+			tt.Fatalf("bestEffort=%t: before reading: got %v, want none", bestEffort, got)
+		}
+		_, err := ioutil.ReadAll(r)
+		if bestEffort != (err == nil) {
+			tt.Fatalf("bestEffort=%t: ReadAll: %v", bestEffort, err)
+		}
+		got := r.ValidationErrors()
+		if (len(got) != 1) || !isCorruptIndex(got[0]) {
+			tt.Fatalf("bestEffort=%t: got %v, want 1 corrupt index error", bestEffort, got)
+		}
+		if bestEffort {
+			if n := len(r.SkippedRanges()); n != 1 {
+				tt.Fatalf("bestEffort=%t: len(SkippedRanges): got %d, want 1", bestEffort, n)
+			}
+		} else if got[0] != err {
+			tt.Fatalf("bestEffort=%t: got %v, want the ReadAll error %v", bestEffort, got[0], err)
+		}
+	}
+}
+
+func TestChunkReaderChunkContainingDuringScan(tt *testing.T) {
+	// A multi-level index, so that lookups load branch and leaf nodes other
+	// than the one that the scan is in.
+	buf := &bytes.Buffer{}
+	w := &ChunkWriter{
+		Writer:      buf,
+		TargetArity: 4,
+	}
+	for i := 0; i < 40; i++ {
+		data := []byte(fmt.Sprintf("chunk #%02d", i))
+		if err := w.AddChunk(uint64(len(data)), storedCodec, data, 0, 0); err != nil {
+			tt.Fatalf("AddChunk: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	want, err := readAllChunks(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		tt.Fatalf("readAllChunks: %v", err)
+	}
+
+	for _, inMemory := range []bool{false, true} {
+		rs := io.ReadSeeker(bytes.NewReader(encoded))
+		if inMemory {
+			rs = NewReaderBytes(encoded).ReadSeeker
+		}
+		r := &ChunkReader{
+			ReadSeeker:     rs,
+			CompressedSize: int64(len(encoded)),
+		}
+		for i := 0; ; i++ {
+			// Look up a chunk far from the scan's position.
+			j := (i + len(want)/2) % len(want)
+			if c, err := r.ChunkContaining(want[j].DRange[0] + 1); err != nil {
+				tt.Fatalf("inMemory=%t, i=%d: ChunkContaining: %v", inMemory, i, err)
+			} else if c != want[j] {
+				tt.Fatalf("inMemory=%t, i=%d: ChunkContaining: got %v, want %v", inMemory, i, c, want[j])
+			}
+
+			c, err := r.NextChunk()
+			if err == io.EOF {
+				if i != len(want) {
+					tt.Fatalf("inMemory=%t: got %d chunks, want %d", inMemory, i, len(want))
+				}
+				break
+			} else if err != nil {
+				tt.Fatalf("inMemory=%t, i=%d: NextChunk: %v", inMemory, i, err)
+			}
+			if c != want[i] {
+				tt.Fatalf("inMemory=%t, i=%d: NextChunk: got %v, want %v", inMemory, i, c, want[i])
+			}
+		}
+
+		size, _ := r.DecompressedSize()
+		if _, err := r.ChunkContaining(size); err != io.EOF {
+			tt.Fatalf("inMemory=%t: ChunkContaining(size): got %v, want %v", inMemory, err, io.EOF)
+		}
+	}
+}
+
+func TestReadNodeTooLarge(tt *testing.T) {
+	if got := nodeSize(255); got != maxNodeSize {
+		tt.Fatalf("nodeSize(255): got %d, want %d", got, maxNodeSize)
+	}
+
+	// An arity can be at most 255, so every node fits in a maxNodeSize
+	// buffer. Simulate a node that overflows its buffer with a smaller one:
+	// the 0x30 byte, arity 2 root node of makeChainRAC(3) in a 0x20 byte
+	// buffer.
+	encoded := makeChainRAC(3)
+	r := &ChunkReader{
+		ReadSeeker:     bytes.NewReader(encoded),
+		CompressedSize: int64(len(encoded)),
+	}
+	if _, err := r.DecompressedSize(); err != nil {
+		tt.Fatalf("DecompressedSize: %v", err)
+	}
+	size := nodeSize(encoded[3])
+	if _, err := r.readNode(make([]byte, size), 0, 0, size); err != nil {
+		tt.Fatalf("readNode (fits): %v", err)
+	}
+	_, err := r.readNode(make([]byte, size-16), 0, 0, size)
+	if e, ok := err.(*ErrCorruptIndex); !ok || (e.NodeCOffset != 0) || (e.Child != -1) {
+		tt.Fatalf("readNode (overflows): got %v, want an *ErrCorruptIndex for the node at 0", err)
+	}
+}
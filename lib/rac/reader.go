@@ -15,6 +15,7 @@
 package rac
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 )
@@ -85,6 +86,10 @@ type Reader struct {
 	// Zero is an invalid value. The smallest valid RAC file is 32 bytes long.
 	CompressedSize int64
 
+	// BaseOffset is where the RAC file starts within the ReadSeeker. See the
+	// ChunkReader field of the same name for more details.
+	BaseOffset int64
+
 	// CodecReaders are the compression codecs that this Reader can decompress.
 	//
 	// For example, use a raczlib.CodecReader from the sibilng "raczlib"
@@ -101,6 +106,84 @@ type Reader struct {
 	// (single-goroutine) reader.
 	Concurrency int
 
+	// SkipChecksumVerification is whether to skip verifying each index node's
+	// checksum. See the ChunkReader field of the same name for the tradeoff.
+	SkipChecksumVerification bool
+
+	// BestEffort is whether to skip, instead of failing on, corrupt parts of
+	// the index. The decompressed bytes of a skipped subtree read as zeroes,
+	// and SkippedRanges reports where they are. See the ChunkReader field of
+	// the same name for more details.
+	//
+	// It requires a non-positive Concurrency.
+	BestEffort bool
+
+	// CloseReadSeeker is whether Close should also close the ReadSeeker, if
+	// it implements io.Closer, such as an os.File that the Reader owns. It is
+	// closed once, after any concurrent goroutines have shut down, and its
+	// Close error (if any) is returned by the Reader's Close.
+	//
+	// CloseWithoutWaiting does not close the ReadSeeker, as those goroutines
+	// may still be using it.
+	CloseReadSeeker bool
+
+	// Metrics, if non-nil, is where this Reader counts its reads and node
+	// validations. See the Metrics type for more details.
+	Metrics *Metrics
+
+	// MaxArity, if non-zero, is the largest index node arity to accept. See
+	// the ChunkReader field of the same name for more details.
+	MaxArity uint8
+
+	// MaxDecompressedSize, if positive, is the largest decompressed size (the
+	// root node's DPtrMax) to accept. A RAC file claiming to be larger is
+	// rejected with ErrDecompressedSizeTooLarge before any chunk is read,
+	// bounding the resources spent on untrusted input. Zero means unlimited.
+	MaxDecompressedSize int64
+
+	// SmallIndexThreshold, if positive, is how many bytes of the RAC file to
+	// read in one go when finding the root node, so that index nodes within
+	// them need no further I/O. See the ChunkReader field of the same name for
+	// more details.
+	SmallIndexThreshold int64
+
+	// VerifyFunc, if non-nil, is called with each chunk's decompressed bytes,
+	// the whole of its DRange (including any implicit NUL bytes), before Read
+	// serves any of them. RAC checksums its index but not its chunk data, so
+	// this can layer end-to-end integrity checks on top, such as comparing
+	// against an expected hash keyed by dRangeStart. A non-nil error aborts
+	// the Read and, like other errors, is sticky.
+	//
+	// When set, each chunk is decompressed into memory in full. If Concurrency
+	// is positive, it may be called from multiple goroutines at once.
+	VerifyFunc func(dRangeStart int64, decompressed []byte) error
+
+	// AvailablePrefix, if positive, is how much of the RAC file (in CSpace,
+	// not counting any BaseOffset) is available to read, such as while the
+	// file is still being downloaded. The index must be available in full,
+	// so that chunk metadata (e.g. from ChunksInRange) can still be read, but
+	// when decompressing a chunk needs to read past the prefix, Read returns
+	// ErrNotYetAvailable, having served the data decompressed until then. Zero
+	// means that the whole file is available.
+	//
+	// If Concurrency is non-positive, ErrNotYetAvailable is not a sticky
+	// error and, unlike other exported fields, AvailablePrefix may be
+	// increased between Read calls, as more of the file arrives, so that the
+	// failed Read can be retried.
+	AvailablePrefix int64
+
+	// PrefetchHint, if non-nil, is called with upcoming chunks, so that an
+	// application reading from a high-latency source can prefetch their
+	// compressed data (their CPrimary, CSecondary and CTertiary ranges) into
+	// its own cache. Whenever reading moves to a new leaf node, it is called
+	// once per chunk, in DSpace order, for the leaf node's chunks from the one
+	// about to be decompressed onwards, before that chunk is decompressed.
+	//
+	// It is only a hint: the Reader's own I/O is unchanged. It must not call
+	// the Reader's methods. If Concurrency is positive, it may be called from
+	// multiple goroutines at once.
+	PrefetchHint func(next Chunk)
+
 	// err is the first error encountered. It is sticky: once a non-nil error
 	// occurs, all public methods will return that error.
 	err error
@@ -169,6 +252,21 @@ type Reader struct {
 	concReader concReader
 }
 
+// NewReaderBytes returns a Reader for a RAC file held entirely in memory. Its
+// CompressedSize is len(b). The caller should set the CodecReaders (and
+// optionally the Concurrency) field before calling any of the Reader's
+// methods.
+//
+// Index nodes are sub-sliced from b instead of being copied, so b must not be
+// modified while the Reader is in use. Those nodes are not reads, for the
+// purposes of the Metrics field, as they involve no I/O.
+func NewReaderBytes(b []byte) *Reader {
+	return &Reader{
+		ReadSeeker:     &bytesReadSeeker{bytes.NewReader(b), b},
+		CompressedSize: int64(len(b)),
+	}
+}
+
 func (r *Reader) initialize() error {
 	if r.err != nil {
 		return r.err
@@ -178,7 +276,17 @@ func (r *Reader) initialize() error {
 	}
 	r.chunkReader.ReadSeeker = r.ReadSeeker
 	r.chunkReader.CompressedSize = r.CompressedSize
+	r.chunkReader.BaseOffset = r.BaseOffset
+	r.chunkReader.SkipChecksumVerification = r.SkipChecksumVerification
+	r.chunkReader.BestEffort = r.BestEffort
+	r.chunkReader.Metrics = r.Metrics
+	r.chunkReader.MaxArity = r.MaxArity
+	r.chunkReader.SmallIndexThreshold = r.SmallIndexThreshold
 	if r.Concurrency > 0 {
+		if r.BestEffort {
+			r.err = fmt.Errorf("rac: BestEffort requires Concurrency <= 0")
+			return r.err
+		}
 		if _, ok := r.ReadSeeker.(io.ReaderAt); !ok {
 			r.err = fmt.Errorf("rac: Concurrency > 0 requires the ReadSeeker to be an io.ReaderAt")
 			return r.err
@@ -188,6 +296,10 @@ func (r *Reader) initialize() error {
 		r.err = err
 		return r.err
 	}
+	if (r.MaxDecompressedSize > 0) && (r.chunkReader.decompressedSize > r.MaxDecompressedSize) {
+		r.err = ErrDecompressedSizeTooLarge
+		return r.err
+	}
 	r.posLimit = r.chunkReader.decompressedSize
 	r.concReader.initialize(r)
 	return nil
@@ -197,8 +309,19 @@ func (r *Reader) clone() *Reader {
 	c := &Reader{
 		ReadSeeker:     r.ReadSeeker,
 		CompressedSize: r.CompressedSize,
+		BaseOffset:     r.BaseOffset,
 		CodecReaders:   make([]CodecReader, len(r.CodecReaders)),
 		Concurrency:    r.Concurrency,
+
+		SkipChecksumVerification: r.SkipChecksumVerification,
+		BestEffort:               r.BestEffort,
+		Metrics:                  r.Metrics,
+		MaxArity:                 r.MaxArity,
+		MaxDecompressedSize:      r.MaxDecompressedSize,
+		SmallIndexThreshold:      r.SmallIndexThreshold,
+		VerifyFunc:               r.VerifyFunc,
+		AvailablePrefix:          r.AvailablePrefix,
+		PrefetchHint:             r.PrefetchHint,
 	}
 	for i := range c.CodecReaders {
 		c.CodecReaders[i] = r.CodecReaders[i].Clone()
@@ -213,7 +336,9 @@ func (r *Reader) Read(p []byte) (int, error) {
 	}
 	if r.concReader.ready() {
 		n, err := r.concReader.Read(p)
-		r.err = err
+		if (err != nil) && (err != io.EOF) {
+			r.err = err
+		}
 		return n, err
 	}
 
@@ -275,6 +400,8 @@ func (r *Reader) readExplicitData(p []byte) (int, error) {
 		r.dRange[0] += int64(n)
 		if err == io.EOF {
 			return 0, r.transitionFromStateBToStateC()
+		} else if err == ErrNotYetAvailable {
+			return 0, r.retryLater()
 		}
 		if err != nil {
 			r.err = err
@@ -292,6 +419,8 @@ func (r *Reader) readExplicitData(p []byte) (int, error) {
 	r.dRange[0] += int64(n)
 	if err == io.EOF {
 		return n, r.transitionFromStateBToStateC()
+	} else if err == ErrNotYetAvailable {
+		return n, r.retryLater()
 	} else if err == io.ErrUnexpectedEOF {
 		err = errInvalidChunkTruncated
 	}
@@ -347,15 +476,37 @@ func (r *Reader) readImplicitZeroes(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// findCodecReader returns the first element of codecReaders that accepts c.
+func findCodecReader(codecReaders []CodecReader, c Codec) (CodecReader, error) {
+	for _, cr := range codecReaders {
+		if cr.Accepts(c) {
+			return cr, nil
+		}
+	}
+	name0, name1, name2 := "", c.name(), ""
+	if name1 != "" {
+		name0, name2 = " (", ")"
+	}
+	return nil, fmt.Errorf("rac: no matching CodecReader for Codec 0x%X%s%s%s",
+		c, name0, name1, name2)
+}
+
 // nextChunk loads the next independently compressed chunk. It transitions from
 // "State A" to "State B".
 //
 // It may return io.EOF, in which case the Reader stays in "State A", and the
 // r.err "sticky error" field stays nil.
 func (r *Reader) nextChunk() error {
+	numResolves := r.chunkReader.numResolves
 	chunk, err := r.chunkReader.NextChunk()
 	if err != nil {
 		if err == io.EOF {
+			if r.BestEffort && (r.pos < r.chunkReader.decompressedSize) {
+				// A corrupt subtree at the end was skipped.
+				r.dRange = Range{r.pos, r.chunkReader.decompressedSize}
+				r.inImplicitZeroes = true
+				return nil
+			}
 			return io.EOF
 		}
 		r.err = err
@@ -366,44 +517,668 @@ func (r *Reader) nextChunk() error {
 		return r.err
 	}
 
-	if (chunk.Codec == CodecZeroes) || (chunk.Codec == codecLongZeroes) {
+	if r.pos < chunk.DRange[0] {
+		// In BestEffort mode, the chunk reader skipped a corrupt subtree.
+		// Serve that gap as implicit zeroes ("State C") and then revisit the
+		// chunk.
+		if err := r.chunkReader.SeekToChunkContaining(chunk.DRange[0]); err != nil {
+			r.err = err
+			return r.err
+		}
+		r.dRange = Range{r.pos, chunk.DRange[0]}
+		r.inImplicitZeroes = true
+		return nil
+	}
+
+	if (r.PrefetchHint != nil) && (r.chunkReader.numResolves != numResolves) {
+		r.PrefetchHint(chunk)
+		r.chunkReader.forEachRemainingChunk(r.PrefetchHint)
+	}
+
+	codec := r.EffectiveCodec(chunk)
+	if (codec == CodecZeroes) || (codec == codecLongZeroes) {
 		r.dRange = chunk.DRange
 		r.zeroes = zeroesReader(r.dRange.Size())
 		r.decompressor = &r.zeroes
+		return r.verifyChunk()
+	}
+
+	codecReader, err := findCodecReader(r.CodecReaders, codec)
+	if err != nil {
+		r.err = err
+		return r.err
+	}
+
+	racFile := r.chunkReader.readSeeker
+	if r.AvailablePrefix > 0 {
+		racFile = &prefixReadSeeker{rs: racFile, limit: r.AvailablePrefix, pos: -1}
+	}
+	decompressor, err := codecReader.MakeDecompressor(racFile, chunk)
+	if err != nil {
+		if err == ErrNotYetAvailable {
+			return r.retryLater()
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+		return r.err
+	}
+	r.decompressor = decompressor
+	r.dRange = chunk.DRange
+	return r.verifyChunk()
+}
+
+// EffectiveCodec returns the Codec that decompressing c should use. It is
+// c.Codec, the Codec of c's leaf node: a Codec is not inherited from, or
+// combined with, the node's ancestors. If a branch node's Codec has the Mix
+// Bit set, its descendants' Codecs can be entirely different from its own.
+// If not, they must equal it exactly, so there is nothing to combine. Either
+// way, the leaf node is authoritative. The Mix Bit itself is never part of
+// the returned Codec.
+func (r *Reader) EffectiveCodec(c Chunk) Codec {
+	return c.Codec
+}
+
+// prefixReadSeeker wraps a RAC file's ReadSeeker, returning
+// ErrNotYetAvailable instead of reading at or past limit, the Reader's
+// AvailablePrefix.
+type prefixReadSeeker struct {
+	rs    io.ReadSeeker
+	limit int64
+
+	// pos is rs' position, or -1 if not yet known.
+	pos int64
+}
+
+// Read implements io.Reader.
+func (p *prefixReadSeeker) Read(b []byte) (int, error) {
+	if p.pos < 0 {
+		if _, err := p.Seek(0, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+	if p.pos >= p.limit {
+		return 0, ErrNotYetAvailable
+	}
+	if n := p.limit - p.pos; int64(len(b)) > n {
+		b = b[:n]
+	}
+	n, err := p.rs.Read(b)
+	p.pos += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (p *prefixReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := p.rs.Seek(offset, whence)
+	if err != nil {
+		p.pos = -1
+	} else {
+		p.pos = pos
+	}
+	return pos, err
+}
+
+// retryLater abandons the current chunk, whose compressed data is not yet
+// within r.AvailablePrefix, and returns ErrNotYetAvailable. Unlike other
+// errors, it is not sticky: it rewinds to "State A" at r.pos, so that a later
+// Read re-loads the chunk, discarding any part of it that was already served.
+func (r *Reader) retryLater() error {
+	if c, ok := r.decompressor.(io.Closer); ok {
+		c.Close()
+	}
+	r.decompressor = nil
+	r.inImplicitZeroes = false
+	r.dRange = Range{r.pos, r.pos}
+	if err := r.chunkReader.SeekToChunkContaining(r.pos); err != nil {
+		r.err = err
+		return r.err
+	}
+	return ErrNotYetAvailable
+}
+
+// verifyChunk, if r.VerifyFunc is non-nil, decompresses all of the chunk just
+// loaded by nextChunk, passes it to r.VerifyFunc and then replaces
+// r.decompressor with one that serves those already-decompressed bytes.
+func (r *Reader) verifyChunk() error {
+	if r.VerifyFunc == nil {
 		return nil
 	}
 
-	codecReader := CodecReader(nil)
-	for _, cr := range r.CodecReaders {
-		if cr.Accepts(chunk.Codec) {
-			codecReader = cr
+	// Allocate one more byte than the DRange size, to detect a decompressor
+	// that produces too much. Any unfilled suffix is the implicit NUL bytes.
+	size := r.dRange.Size()
+	buf := make([]byte, size+1)
+	n := int64(0)
+	for n <= size {
+		m, err := r.decompressor.Read(buf[n:])
+		n += int64(m)
+		if err == io.EOF {
 			break
+		} else if err == ErrNotYetAvailable {
+			return r.retryLater()
+		} else if err == io.ErrUnexpectedEOF {
+			r.err = errInvalidChunkTruncated
+			return r.err
+		} else if err != nil {
+			r.err = err
+			return r.err
 		}
 	}
-	if codecReader == nil {
-		name0, name1, name2 := "", chunk.Codec.name(), ""
-		if name1 != "" {
-			name0, name2 = " (", ")"
-		}
-		r.err = fmt.Errorf("rac: no matching CodecReader for Codec 0x%X%s%s%s",
-			chunk.Codec, name0, name1, name2)
+	if n > size {
+		r.err = errInvalidChunkTooLarge
+		return r.err
+	}
+
+	// Close the codec's decompressor, as the "State B" to "State C"
+	// transition does, but stay in "State B" to serve buf.
+	if err := r.transitionFromStateBToStateC(); err != nil {
+		return err
+	}
+	r.inImplicitZeroes = false
+
+	buf = buf[:size]
+	if err := r.VerifyFunc(r.dRange[0], buf); err != nil {
+		r.err = err
 		return r.err
 	}
+	r.decompressor = bytes.NewReader(buf)
+	return nil
+}
+
+// CompressedLen returns the size of the RAC file in CSpace: the
+// CompressedSize field. Compare DecompressedSize, which is in DSpace.
+func (r *Reader) CompressedLen() int64 {
+	return r.CompressedSize
+}
+
+// DecompressedSize returns the size of the decompressed data: the size in
+// DSpace. Compare CompressedLen, which is in CSpace.
+func (r *Reader) DecompressedSize() (int64, error) {
+	if err := r.initialize(); err != nil {
+		return 0, err
+	}
+	return r.chunkReader.decompressedSize, nil
+}
 
-	decompressor, err := codecReader.MakeDecompressor(r.chunkReader.readSeeker, chunk)
+// CompressionRatio returns CompressedLen divided by DecompressedSize, e.g. 0.25
+// for a RAC file a quarter the size of its decompressed data. It returns an
+// error if the decompressed size is zero.
+func (r *Reader) CompressionRatio() (float64, error) {
+	dSize, err := r.DecompressedSize()
 	if err != nil {
+		return 0, err
+	}
+	if dSize == 0 {
+		return 0, errZeroDecompressedSize
+	}
+	return float64(r.CompressedSize) / float64(dSize), nil
+}
+
+// SkippedRanges returns the DRanges of the corrupt parts of the index that
+// have been skipped so far. It is always empty unless BestEffort is set.
+func (r *Reader) SkippedRanges() []Range {
+	return r.chunkReader.SkippedRanges()
+}
+
+// ValidationErrors returns the corrupt index errors found so far by reading.
+// See ChunkReader.ValidationErrors for details. In BestEffort mode, there is
+// one error per SkippedRanges element.
+func (r *Reader) ValidationErrors() []error {
+	errs := r.chunkReader.ValidationErrors()
+	if (len(errs) == 0) && (r.err != nil) && isCorruptIndex(r.err) {
+		// With positive Concurrency, the index is walked by other goroutines'
+		// ChunkReaders, and their errors are passed on to r.err.
+		errs = []error{r.err}
+	}
+	return errs
+}
+
+// indexReader returns a ChunkReader for inspecting the index without
+// disturbing r.chunkReader, which tracks the position for subsequent Read
+// calls or, if Concurrency is positive, belongs to the concReader's
+// goroutines. r must be initialized, without error.
+//
+// The methods that use it do not set r.err: an error walking the index is
+// returned but is not sticky.
+func (r *Reader) indexReader() *ChunkReader {
+	return r.chunkReader.cloneForIndexWalk()
+}
+
+// NodeAt returns the index node at the given position in CSpace. It is
+// intended for inspection and debugging tools.
+//
+// It returns an *ErrCorruptIndex if there is no valid node at cOffset. Like
+// other errors from inspecting the index, that one is not sticky: r can still
+// be used afterwards.
+func (r *Reader) NodeAt(cOffset int64) (*Node, error) {
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
+	n := &Node{}
+	if err := r.indexReader().loadNode(n, cOffset); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// RootNodeLocation returns the CSpace offset of the root node and whether it
+// was found at the end of the RAC file instead of at the start. See the
+// ChunkReader method of the same name for more details.
+func (r *Reader) RootNodeLocation() (offset int64, fromEnd bool, err error) {
+	if err := r.initialize(); err != nil {
+		return 0, false, err
+	}
+	return r.chunkReader.rootNodeCOffset, r.chunkReader.rootNodeFromEnd, nil
+}
+
+// IndexExtent returns the CSpace range spanned by the RAC file's index nodes.
+// A client of a remote RAC file can fetch that range with one request, before
+// deciding which chunks' data to fetch.
+func (r *Reader) IndexExtent() (Range, error) {
+	if err := r.initialize(); err != nil {
+		return Range{}, err
+	}
+	return r.indexReader().IndexExtent()
+}
+
+// ChunksInRange returns every non-empty chunk whose DRange overlaps dr, in
+// DSpace order. Chunks entirely past the end of the decompressed data are not
+// returned, so a dr that extends past EOF yields only the tail chunks.
+//
+// It does not change the position for subsequent Read calls, although a
+// partially decompressed chunk may need to be re-decompressed.
+//
+// It returns an error if dr[0] > dr[1].
+func (r *Reader) ChunksInRange(dr Range) ([]Chunk, error) {
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
+	if dr[0] > dr[1] {
+		r.err = errSeekToNegativeRange
+		return nil, r.err
+	}
+	if dr[0] < 0 {
+		r.err = errSeekToNegativePosition
+		return nil, r.err
+	}
+	if dr.Empty() {
+		return nil, nil
+	}
+
+	cr := &r.chunkReader
+	if r.concReader.ready() {
+		// The concReader's goroutines own r.chunkReader, so use a separate
+		// ChunkReader. Concurrency > 0 means that r.ReadSeeker is an
+		// io.ReaderAt, so sharing it is safe.
+		cr = &ChunkReader{
+			ReadSeeker:               r.ReadSeeker,
+			CompressedSize:           r.CompressedSize,
+			BaseOffset:               r.BaseOffset,
+			SkipChecksumVerification: r.SkipChecksumVerification,
+			Metrics:                  r.Metrics,
+			MaxArity:                 r.MaxArity,
+		}
+	} else {
+		// Afterwards, restore r.chunkReader to r.pos and reset to "State A".
+		defer func() {
+			if err := r.chunkReader.SeekToChunkContaining(r.pos); err != nil {
+				r.err = err
+			}
+			r.dRange[0] = r.pos
+			r.dRange[1] = r.pos
+			if c, ok := r.decompressor.(io.Closer); ok {
+				c.Close()
+			}
+			r.decompressor = nil
+			r.inImplicitZeroes = false
+		}()
+	}
+
+	if err := cr.SeekToChunkContaining(dr[0]); err != nil {
+		r.err = err
+		return nil, r.err
+	}
+	ret := []Chunk(nil)
+	for {
+		chunk, err := cr.NextChunk()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			r.err = err
+			return nil, r.err
+		}
+		if chunk.DRange[0] >= dr[1] {
+			break
+		}
+		ret = append(ret, chunk)
+	}
+	return ret, nil
+}
+
+// ChunkBoundaries returns the DSpace offsets at which the non-empty chunks
+// begin, in increasing order, followed by the decompressed size. A binary
+// search over the result (e.g. with sort.Search) maps a DSpace offset to a
+// chunk index without re-walking the index.
+//
+// Like ChunksInRange, it does not change the position for subsequent Read
+// calls.
+func (r *Reader) ChunkBoundaries() ([]int64, error) {
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
+	cr := r.indexReader()
+	ret := []int64(nil)
+	for {
+		c, err := cr.NextChunk()
 		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ret = append(ret, c.DRange[0])
+	}
+	return append(ret, r.chunkReader.decompressedSize), nil
+}
+
+// SeekToChunkIndex sets the position for subsequent Read calls to the start
+// of the n'th (0-based) non-empty chunk, in DSpace order, as if by calling
+// Seek(boundaries[n], io.SeekStart) where boundaries is the result of
+// ChunkBoundaries. It returns an error if there are n or fewer non-empty
+// chunks.
+//
+// Like Seek, it removes any SeekRange limit.
+func (r *Reader) SeekToChunkIndex(n int64) error {
+	c, err := r.chunkAtIndex(n)
+	if err != nil {
+		return err
+	}
+	_, err = r.Seek(c.DRange[0], io.SeekStart)
+	return err
+}
+
+// DecompressedRangeOf returns the DRange of the n'th (0-based) non-empty
+// chunk, in DSpace order, the chunk that SeekToChunkIndex(n) would seek to. It
+// returns an error if there are n or fewer non-empty chunks.
+//
+// Like ChunksInRange, it does not change the position for subsequent Read
+// calls.
+func (r *Reader) DecompressedRangeOf(n int64) (Range, error) {
+	c, err := r.chunkAtIndex(n)
+	if err != nil {
+		return Range{}, err
+	}
+	return c.DRange, nil
+}
+
+// chunkAtIndex returns the n'th (0-based) non-empty chunk, in DSpace order.
+// It counts chunks on a private ChunkReader, as ChunkReader.SeekToChunkIndex
+// does, without collecting them.
+func (r *Reader) chunkAtIndex(n int64) (Chunk, error) {
+	if err := r.initialize(); err != nil {
+		return Chunk{}, err
+	}
+	cr := r.indexReader()
+	if err := cr.SeekToChunkIndex(n); err != nil {
+		return Chunk{}, err
+	}
+	return cr.NextChunk()
+}
+
+// LastChunk returns the final non-empty chunk: the one whose DRange ends at
+// the decompressed size. It finds it by resolving the last DSpace offset,
+// walking the index's rightmost path instead of scanning every chunk. It
+// returns io.EOF if the decompressed data is empty.
+//
+// Afterwards, the position for subsequent Read calls is after that chunk: at
+// the end of the decompressed data. Like Seek, it removes any SeekRange limit.
+func (r *Reader) LastChunk() (Chunk, error) {
+	if err := r.initialize(); err != nil {
+		return Chunk{}, err
+	}
+	size := r.chunkReader.decompressedSize
+	if size == 0 {
+		return Chunk{}, io.EOF
+	}
+	chunks, err := r.ChunksInRange(Range{size - 1, size})
+	if err != nil {
+		return Chunk{}, err
+	} else if len(chunks) == 0 {
+		// This can only happen in BestEffort mode, if the last chunk's
+		// subtree was skipped.
+		return Chunk{}, io.EOF
+	}
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		return Chunk{}, err
+	}
+	return chunks[len(chunks)-1], nil
+}
+
+// ApproximateChunkIndexAt returns which of the root node's children contains
+// dOff: the index, in [0, arity), of the top-level subtree (or, if that child
+// is a leaf, the chunk) covering that DSpace offset. It only consults the root
+// node, which is loaded when the Reader is initialized, so it does no further
+// I/O and does not descend into branch nodes. It suits a coarse progress
+// indicator, where the root node's DPtr values say roughly how far along dOff
+// is. It returns io.EOF if dOff is at or past the end of the decompressed
+// data.
+//
+// It does not change the position for subsequent Read calls.
+func (r *Reader) ApproximateChunkIndexAt(dOff int64) (int, error) {
+	if err := r.initialize(); err != nil {
+		return 0, err
+	}
+	if dOff < 0 {
+		return 0, errSeekToNegativePosition
+	} else if dOff >= r.chunkReader.decompressedSize {
+		return 0, io.EOF
+	}
+	return r.chunkReader.rootNode.findChunkContaining(dOff, 0), nil
+}
+
+// ReadChunkData reads c's CPrimary bytes, its raw compressed data, into dst.
+// It returns the number of bytes read, c.CPrimary.Size(), or an error if dst
+// is shorter than that. Like ReadCSpace, it returns ErrNotYetAvailable if
+// CPrimary extends past a positive AvailablePrefix.
+func (r *Reader) ReadChunkData(c Chunk, dst []byte) (int, error) {
+	return r.ReadCSpace(c.CPrimary, dst)
+}
+
+// SecondaryDictionaryFor returns the bytes of c's secondary resource: the
+// CSpace range c.CSecondary, which c.STag refers to. It returns nil if c has
+// no secondary resource. For codecs such as Zlib, this is the dictionary.
+//
+// Like TertiaryDictionaryFor, the bytes are as stored in the RAC file, and it
+// does not change the position for subsequent Read calls.
+func (r *Reader) SecondaryDictionaryFor(c Chunk) ([]byte, error) {
+	return r.readResource(c.CSecondary)
+}
+
+// TertiaryDictionaryFor returns the bytes of c's tertiary resource: the
+// CSpace range c.CTertiary, which c.TTag refers to. It returns nil if c has
+// no tertiary resource.
+//
+// The bytes are as stored in the RAC file. Any codec-specific wrapping, such
+// as the RAC common dictionary format's length prefix and checksum suffix, is
+// not removed. Absent a CLen, the range extends to its node's CPtrMax, so it
+// can also include bytes after the resource.
+//
+// It does not change the position for subsequent Read calls.
+func (r *Reader) TertiaryDictionaryFor(c Chunk) ([]byte, error) {
+	return r.readResource(c.CTertiary)
+}
+
+func (r *Reader) readResource(cr Range) ([]byte, error) {
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
+	if (cr[0] < 0) || (cr[0] > cr[1]) || (cr[1] > r.CompressedSize) {
+		return nil, errInvalidCRange
+	} else if cr.Empty() {
+		return nil, nil
+	}
+	dst := make([]byte, cr.Size())
+	if _, err := r.ReadCSpace(cr, dst); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// ReadCSpace reads the RAC file's bytes in the CSpace range cr, such as a
+// Chunk's CSecondary or CTertiary, into dst. It returns the number of bytes
+// read, cr.Size(), or an error if dst is shorter than that. If cr extends
+// past a positive AvailablePrefix, it returns ErrNotYetAvailable, which (like
+// errBufferTooSmall) is not a sticky error.
+//
+// It does not change the position for subsequent Read calls.
+func (r *Reader) ReadCSpace(cr Range, dst []byte) (int, error) {
+	if err := r.initialize(); err != nil {
+		return 0, err
+	}
+	if (cr[0] < 0) || (cr[0] > cr[1]) || (cr[1] > r.CompressedSize) {
+		return 0, errInvalidCRange
+	}
+	if int64(len(dst)) < cr.Size() {
+		return 0, errBufferTooSmall
+	}
+	if (r.AvailablePrefix > 0) && (cr[1] > r.AvailablePrefix) {
+		return 0, ErrNotYetAvailable
+	}
+	dst = dst[:cr.Size()]
+
+	if ra, ok := r.ReadSeeker.(io.ReaderAt); ok {
+		n, err := ra.ReadAt(dst, r.BaseOffset+cr[0])
+		r.Metrics.addRead(n)
+		if n == len(dst) {
+			return n, nil
+		} else if (err == nil) || (err == io.EOF) {
 			err = io.ErrUnexpectedEOF
 		}
 		r.err = err
-		return r.err
+		return n, r.err
+	}
+
+	// A decompressor may be partway through reading from r.ReadSeeker, so
+	// restore its position afterwards.
+	pos, err := r.ReadSeeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		r.err = err
+		return 0, r.err
+	}
+	if _, err := r.ReadSeeker.Seek(r.BaseOffset+cr[0], io.SeekStart); err != nil {
+		r.err = err
+		return 0, r.err
+	}
+	n, err := io.ReadFull(r.ReadSeeker, dst)
+	r.Metrics.addRead(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if _, seekErr := r.ReadSeeker.Seek(pos, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// NumNodes returns the number of index nodes in the RAC file. Like
+// IndexExtent, it walks the whole index but does not decompress anything.
+func (r *Reader) NumNodes() (int64, error) {
+	if err := r.initialize(); err != nil {
+		return 0, err
+	}
+	numNodes, _, err := r.indexReader().IndexStats()
+	return numNodes, err
+}
+
+// AverageFanout returns the mean number of children, not counting Codec
+// Entries, per branch node. See ChunkReader.IndexStats for how to interpret
+// it.
+func (r *Reader) AverageFanout() (float64, error) {
+	if err := r.initialize(); err != nil {
+		return 0, err
+	}
+	_, averageFanout, err := r.indexReader().IndexStats()
+	return averageFanout, err
+}
+
+// VerifyCodecHierarchy checks that every index node's Codec is consistent
+// with its parent's. See ChunkReader.VerifyCodecHierarchy for details. It does
+// not decompress anything, and it does not change the position for subsequent
+// Read calls.
+func (r *Reader) VerifyCodecHierarchy() error {
+	if err := r.initialize(); err != nil {
+		return err
+	}
+	return r.indexReader().VerifyCodecHierarchy()
+}
+
+// AllChunks returns every chunk, including empty ones, in DSpace order. See
+// ChunkReader.AllChunks for details. Together with ReadCSpace and the
+// ChunkWriter's AddResource and AddChunk methods, it can transcode a RAC file.
+// It does not decompress anything, and it does not change the position for
+// subsequent Read calls.
+func (r *Reader) AllChunks() ([]Chunk, error) {
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
+	return r.indexReader().AllChunks()
+}
+
+// IsStandalone returns whether the RAC file can be decompressed on its own,
+// without external inputs: whether every chunk's CSecondary and CTertiary
+// ranges, such as a shared dictionary, are within the file's own CSpace range.
+// See ChunkReader.IsStandalone for details. An external reference is a false
+// result, not an error, but other corruption is an error. It does not
+// decompress anything, and it does not change the position for subsequent
+// Read calls.
+func (r *Reader) IsStandalone() (bool, error) {
+	if err := r.initialize(); err != nil {
+		return r.chunkReader.standalone(err)
+	}
+	return r.indexReader().IsStandalone()
+}
+
+// VerifyDecompressedSize walks the whole index, visiting each node once, and
+// checks that the DSizes of every node's leaf children add up to exactly
+// DecompressedSize, the root node's DPtrMax. It does not decompress anything,
+// and it does not change the position for subsequent Read calls.
+//
+// Reading the file trusts each branch child's DSize to match that node's
+// DPtrMax, so it can't see an overstated DPtrMax or a subtree whose chunks
+// leave a gap. Summing the leaves independently catches both.
+//
+// It returns an *ErrCorruptIndex (or another error found during the walk) if
+// the index is inconsistent.
+func (r *Reader) VerifyDecompressedSize() error {
+	if err := r.initialize(); err != nil {
+		return err
+	}
+	sum := int64(0)
+	err := r.indexReader().walkIndex(func(n *Node, cBias int64) error {
+		for i, arity := 0, n.b.arity(); i < arity; i++ {
+			if n.b.isLeaf(i) {
+				sum += n.b.dSize(i)
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if sum != r.chunkReader.decompressedSize {
+		return &ErrCorruptIndex{NodeCOffset: r.chunkReader.rootNodeCOffset, Child: -1}
 	}
-	r.decompressor = decompressor
-	r.dRange = chunk.DRange
 	return nil
 }
 
 // Seek implements io.Seeker.
+//
+// Offsets are in DSpace, and io.SeekEnd is relative to the decompressed size.
+// As with an os.File, seeking to a negative position is an error but seeking
+// past the end is not: subsequent Read calls will return io.EOF.
 func (r *Reader) Seek(offset int64, whence int) (int64, error) {
 	if err := r.initialize(); err != nil {
 		return 0, err
@@ -438,7 +1213,9 @@ func (r *Reader) SeekRange(low int64, high int64) error {
 func (r *Reader) seek(offset int64, whence int, limit int64) (int64, error) {
 	if r.concReader.ready() {
 		n, err := r.concReader.seek(offset, whence, limit)
-		r.err = r.err
+		if err != nil {
+			r.err = err
+		}
 		return n, err
 	}
 
@@ -484,7 +1261,11 @@ func (r *Reader) seek(offset int64, whence int, limit int64) (int64, error) {
 
 // Close implements io.Closer.
 //
-// Calling Close will call Close on all of r's CodecReaders.
+// Calling Close will call Close on all of r's CodecReaders, and also on
+// r.ReadSeeker if r.CloseReadSeeker is set.
+//
+// After Close, other method calls return an "already closed" error. Calling
+// Close again is safe: it does nothing and returns that error.
 //
 // r.ReadSeeker will not be accessed after Close returns. If r.Concurrency is
 // non-zero, this may involve waiting for various goroutines to shut down,
@@ -528,6 +1309,11 @@ func (r *Reader) close(withoutWaiting bool) error {
 		if err := r.concReader.Close(); r.err == nil {
 			r.err = err
 		}
+		if c, ok := r.ReadSeeker.(io.Closer); ok && r.CloseReadSeeker {
+			if err := c.Close(); r.err == nil {
+				r.err = err
+			}
+		}
 	}
 
 	if r.err == nil {
@@ -0,0 +1,156 @@
+// Copyright 2020 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rac
+
+import (
+	"io"
+)
+
+// NewSequentialReader returns an io.Reader that serves a RAC file's
+// decompressed data from the start of DSpace to the end, one chunk at a time.
+//
+// Unlike a Reader, it cannot Seek, run concurrently or limit itself to a
+// SeekRange, but it is simpler and does not buffer anything beyond what the
+// CodecReaders' decompressors do. It suits consumers, such as hashers, that
+// only read forward.
+//
+// The ChunkReader should not be used by anything else while the returned
+// io.Reader is in use. Decompression errors are returned by the io.Reader's
+// Read method. Errors are sticky.
+func NewSequentialReader(chunkReader *ChunkReader, codecReaders []CodecReader) io.Reader {
+	return &sequentialReader{
+		chunkReader:  chunkReader,
+		codecReaders: codecReaders,
+	}
+}
+
+type sequentialReader struct {
+	chunkReader  *ChunkReader
+	codecReaders []CodecReader
+
+	// err is the first error encountered. It is sticky.
+	err error
+
+	// started is whether the chunkReader has been positioned at the start of
+	// DSpace.
+	started bool
+
+	// decompressor is the current chunk's decompressor, or nil if the next
+	// Read call needs to start the next chunk.
+	decompressor io.Reader
+
+	// remaining is the number of decompressed bytes left in the current
+	// chunk. Once the decompressor returns io.EOF, the rest are implicitly
+	// zero, as for a Reader.
+	remaining int64
+
+	// zeroes serves the Zeroes Codec and implicit zeroes.
+	zeroes zeroesReader
+}
+
+// Read implements io.Reader.
+func (s *sequentialReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if !s.started {
+		s.started = true
+		if err := s.chunkReader.SeekToChunkContaining(0); err != nil {
+			s.err = err
+			return 0, s.err
+		}
+	}
+
+	for s.remaining == 0 {
+		if err := s.closeDecompressor(); err != nil {
+			return 0, err
+		}
+		if err := s.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.decompressor.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF {
+		if err := s.closeDecompressor(); err != nil {
+			return n, err
+		}
+		s.zeroes = zeroesReader(s.remaining)
+		s.decompressor = &s.zeroes
+		err = nil
+	} else if err == io.ErrUnexpectedEOF {
+		err = errInvalidChunkTruncated
+	}
+	if err != nil {
+		s.err = err
+	}
+	return n, err
+}
+
+func (s *sequentialReader) nextChunk() error {
+	chunk, err := s.chunkReader.NextChunk()
+	if err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		s.err = err
+		return s.err
+	}
+
+	if (chunk.Codec == CodecZeroes) || (chunk.Codec == codecLongZeroes) {
+		s.zeroes = zeroesReader(chunk.DRange.Size())
+		s.decompressor = &s.zeroes
+		s.remaining = chunk.DRange.Size()
+		return nil
+	}
+
+	codecReader, err := findCodecReader(s.codecReaders, chunk.Codec)
+	if err != nil {
+		s.err = err
+		return s.err
+	}
+	decompressor, err := codecReader.MakeDecompressor(s.chunkReader.readSeeker, chunk)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		s.err = err
+		return s.err
+	}
+	s.decompressor = decompressor
+	s.remaining = chunk.DRange.Size()
+	return nil
+}
+
+func (s *sequentialReader) closeDecompressor() error {
+	if c, ok := s.decompressor.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			s.err = err
+			return s.err
+		}
+	}
+	s.decompressor = nil
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 The Wuffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rac
+
+import (
+	"io"
+
+	"github.com/google/wuffs/lib/readerat"
+)
+
+// NewSplitChunkReader returns a ChunkReader for a RAC file whose index and
+// data are stored separately, such as when a small index is cached apart from
+// a large data blob.
+//
+// The index source holds the CSpace bytes in indexRange: index.ReadAt(p, 0)
+// reads from CSpace offset indexRange[0]. That range must contain every index
+// node, which it does if it is the prefix (for IndexLocationAtStart) or
+// suffix (for IndexLocationAtEnd) of the RAC file that holds the whole index.
+//
+// The data source holds the other CSpace bytes, at their CSpace offsets. It
+// may simply be the whole RAC file, but it will never be asked for the bytes
+// in indexRange.
+func NewSplitChunkReader(index io.ReaderAt, indexRange Range, data io.ReaderAt, compressedSize int64) *ChunkReader {
+	return &ChunkReader{
+		ReadSeeker:     newSplitReadSeeker(index, indexRange, data, compressedSize),
+		CompressedSize: compressedSize,
+	}
+}
+
+// NewSplitReader is like NewSplitChunkReader but returns a Reader. The caller
+// should set the CodecReaders (and optionally the Concurrency) field before
+// calling any of the Reader's methods.
+func NewSplitReader(index io.ReaderAt, indexRange Range, data io.ReaderAt, compressedSize int64) *Reader {
+	return &Reader{
+		ReadSeeker:     newSplitReadSeeker(index, indexRange, data, compressedSize),
+		CompressedSize: compressedSize,
+	}
+}
+
+func newSplitReadSeeker(index io.ReaderAt, indexRange Range, data io.ReaderAt, compressedSize int64) *splitReadSeeker {
+	return &splitReadSeeker{readerat.ReadSeeker{
+		ReaderAt: &splitReaderAt{
+			index:      index,
+			indexRange: indexRange,
+			data:       data,
+		},
+		Size: compressedSize,
+	}}
+}
+
+// splitReadSeeker is a readerat.ReadSeeker that also implements io.ReaderAt,
+// so that a ChunkReader or Reader will prefer its (concurrency-safe) ReadAt
+// method.
+type splitReadSeeker struct {
+	readerat.ReadSeeker
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *splitReadSeeker) ReadAt(p []byte, off int64) (int, error) {
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+// splitReaderAt routes reads in indexRange to the index source and all other
+// reads to the data source.
+type splitReaderAt struct {
+	index      io.ReaderAt
+	indexRange Range
+	data       io.ReaderAt
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *splitReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if (r.indexRange[0] < 0) || (r.indexRange[0] > r.indexRange[1]) {
+		return 0, errInvalidIndexRange
+	}
+	if off < 0 {
+		return 0, errSeekToNegativePosition
+	}
+
+	total := 0
+	for len(p) > 0 {
+		src, srcOff, limit := r.data, off, int64(-1)
+		if off < r.indexRange[0] {
+			limit = r.indexRange[0]
+		} else if off < r.indexRange[1] {
+			src, srcOff, limit = r.index, off-r.indexRange[0], r.indexRange[1]
+		}
+
+		q := p
+		if (limit >= 0) && (int64(len(q)) > (limit - off)) {
+			q = q[:limit-off]
+		}
+		n, err := src.ReadAt(q, srcOff)
+		total += n
+		if n < len(q) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return total, err
+		}
+		p, off = p[n:], off+int64(n)
+	}
+	return total, nil
+}
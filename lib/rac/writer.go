@@ -311,6 +311,10 @@ type Writer struct {
 	// https://github.com/google/brotli/blob/master/research/dictionary_generator.cc
 	ResourcesData [][]byte
 
+	// TargetArity is the maximum arity of each index node. See the
+	// ChunkWriter field of the same name for more details.
+	TargetArity uint8
+
 	// resourcesIDs is the OptResource for each ResourcesData element. Zero
 	// means that corresponding resource is not yet used (and not yet written
 	// to the RAC file).
@@ -374,6 +378,7 @@ func (w *Writer) initialize() error {
 	w.chunkWriter.IndexLocation = w.IndexLocation
 	w.chunkWriter.TempFile = w.TempFile
 	w.chunkWriter.CPageSize = w.CPageSize
+	w.chunkWriter.TargetArity = w.TargetArity
 	return nil
 }
 